@@ -1,25 +1,193 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import api from '../utils/auth';
 
+// streamChat posts a message to /api/chat/stream and calls onEvent for each
+// Server-Sent Event. Returns false if streaming was refused (e.g. an expired
+// token) so the caller can fall back to /api/chat.
+async function streamChat(body, onEvent) {
+  const res = await fetch(`${api.defaults.baseURL}/api/chat/stream`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+      Authorization: `Bearer ${localStorage.getItem('token')}`,
+    },
+    body: JSON.stringify(body),
+  });
+  if (!res.ok || !res.body) return false;
+
+  const reader = res.body.getReader();
+  const decoder = new TextDecoder();
+  let buffer = '';
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) break;
+    buffer += decoder.decode(value, { stream: true });
+
+    let end;
+    while ((end = buffer.indexOf('\n\n')) !== -1) {
+      const frame = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+      const event = frame.match(/^event: (.*)$/m);
+      const data = frame.match(/^data: (.*)$/m);
+      if (event && data) onEvent(event[1], JSON.parse(data[1]));
+    }
+  }
+  return true;
+}
+
 export default function Chat() {
   const [message, setMessage] = useState('');
-  const [reply, setReply] = useState('');
+  const [conversationId, setConversationId] = useState(null);
+  const [messages, setMessages] = useState([]);
+  const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
+  const [personas, setPersonas] = useState([]);
+  const [personaId, setPersonaId] = useState('');
+
+  useEffect(() => {
+    api.get('/api/chat/personas')
+      .then((res) => setPersonas(res.data))
+      .catch((err) => console.error('Failed to fetch personas:', err));
+  }, []);
+
+  // choosePersona switches persona, including for the current conversation
+  const choosePersona = async (id) => {
+    setPersonaId(id);
+    if (!conversationId) return;
+    try {
+      await api.put(`/api/conversations/${conversationId}/persona`, { persona_id: id ? Number(id) : null });
+    } catch (err) {
+      setError('Sorry, the persona could not be changed.');
+    }
+  };
+
+  // replaceReply swaps the reply being shown, e.g. for a safety response
+  const replaceReply = (content) => {
+    setMessages((prev) => [...prev.slice(0, -1), { role: 'assistant', content }]);
+  };
+
+  // showContext attaches what was shared from the user's moods and journal
+  // to the reply being shown
+  const showContext = (context) => {
+    if (!context) return;
+    setMessages((prev) => [...prev.slice(0, -1), { ...prev[prev.length - 1], context }]);
+  };
+
+  // showToolCalls attaches the tools the assistant called to the reply
+  // being shown, so pending writes can be confirmed
+  const showToolCalls = (toolCalls) => {
+    if (!toolCalls || toolCalls.length === 0) return;
+    setMessages((prev) => [...prev.slice(0, -1), { ...prev[prev.length - 1], toolCalls }]);
+  };
+
+  // resolveToolCall confirms or rejects a pending write and shows the outcome
+  const resolveToolCall = async (toolCall, confirm) => {
+    try {
+      const res = await api.post(`/api/chat/tool-calls/${toolCall.id}/${confirm ? 'confirm' : 'reject'}`);
+      setMessages((prev) => [
+        ...prev.map((m) => (!m.toolCalls ? m : {
+          ...m,
+          toolCalls: m.toolCalls.map((tc) => (tc.id === toolCall.id ? res.data.tool_call : tc)),
+        })),
+        { role: 'assistant', content: res.data.message.content },
+      ]);
+    } catch (err) {
+      setError('Sorry, that could not be saved.');
+    }
+  };
+
+  // appendToReply adds streamed text to the reply being shown
+  const appendToReply = (content) => {
+    setMessages((prev) => {
+      const last = prev[prev.length - 1];
+      return [...prev.slice(0, -1), { ...last, content: last.content + content }];
+    });
+  };
 
   const send = async () => {
+    if (!message.trim()) return;
+    const body = {
+      message,
+      conversation_id: conversationId || undefined,
+      persona_id: personaId ? Number(personaId) : undefined,
+    };
     setLoading(true);
+    setError('');
+    setMessages((prev) => [...prev, { role: 'user', content: message }, { role: 'assistant', content: '' }]);
+    setMessage('');
     try {
-      const res = await api.post('/api/chat', { message });
-      setReply(res.data.reply);
+      const streamed = await streamChat(body, (event, data) => {
+        if (event === 'start') setConversationId(data.conversation_id);
+        if (event === 'delta') appendToReply(data.content);
+        if (event === 'safety') replaceReply(data.content);
+        if (event === 'done') {
+          showContext(data.context);
+          showToolCalls(data.tool_calls);
+        }
+        if (event === 'error') {
+          setError("Sorry, AI service failed.");
+          // Nothing was saved, so a conversation this message started is gone
+          if (!data.message_id) setConversationId(body.conversation_id || null);
+        }
+      });
+      if (!streamed) {
+        const res = await api.post('/api/chat', body);
+        setConversationId(res.data.conversation_id);
+        appendToReply(res.data.reply);
+        showContext(res.data.context);
+        showToolCalls(res.data.tool_calls);
+      }
     } catch (err) {
-      setReply("Sorry, AI service failed.");
+      setError("Sorry, AI service failed.");
     }
     setLoading(false);
   };
 
+  const startOver = () => {
+    setConversationId(null);
+    setMessages([]);
+    setError('');
+  };
+
   return (
     <div style={{ padding: 20 }}>
       <h2>💬 AI Mental Health Assistant</h2>
+      {personas.length > 0 && (
+        <div style={{ marginBottom: 10 }}>
+          <select value={personaId} onChange={(e) => choosePersona(e.target.value)}>
+            <option value="">Default assistant</option>
+            {personas.map((p) => (
+              <option key={p.id} value={p.id} title={p.description}>{p.name}</option>
+            ))}
+          </select>
+        </div>
+      )}
+      {messages.map((m, i) => (
+        <div key={i} style={{ marginBottom: 10, whiteSpace: 'pre-wrap' }}>
+          <strong>{m.role === 'user' ? 'You' : 'AI'}:</strong> {m.content}
+          {m.context && (
+            <details style={{ marginTop: 4, color: '#666', fontSize: 13 }}>
+              <summary>Shared from your moods and journal</summary>
+              {m.context.content}
+            </details>
+          )}
+          {m.toolCalls && m.toolCalls.filter((tc) => tc.name !== 'get_mood_summary' && tc.status !== 'failed').map((tc) => (
+            <div key={tc.id} style={{ marginTop: 4, padding: 8, border: '1px solid #ddd', borderRadius: 4 }}>
+              {tc.name === 'log_mood' && `Log mood: ${tc.arguments.mood}${tc.arguments.note ? ` (${tc.arguments.note})` : ''}`}
+              {tc.name === 'create_journal_draft' && `Save journal entry "${tc.arguments.title}": ${tc.arguments.body}`}
+              {tc.name === 'save_memory' && `Remember: ${tc.arguments.content}`}
+              {tc.status === 'pending' ? (
+                <div style={{ marginTop: 4 }}>
+                  <button onClick={() => resolveToolCall(tc, true)}>Save</button>
+                  <button onClick={() => resolveToolCall(tc, false)} style={{ marginLeft: 8 }}>Don't save</button>
+                </div>
+              ) : (
+                <div style={{ color: '#666' }}>{tc.status}</div>
+              )}
+            </div>
+          ))}
+        </div>
+      ))}
       <textarea
         value={message}
         onChange={(e) => setMessage(e.target.value)}
@@ -30,7 +198,12 @@ export default function Chat() {
       <button onClick={send} disabled={loading}>
         {loading ? 'Thinking...' : 'Send'}
       </button>
-      {reply && <div style={{ marginTop: 20 }}><strong>AI:</strong> {reply}</div>}
+      {conversationId && (
+        <button onClick={startOver} disabled={loading} style={{ marginLeft: 10 }}>
+          New conversation
+        </button>
+      )}
+      {error && <div style={{ marginTop: 20, color: 'red' }}>{error}</div>}
     </div>
   );
 }
\ No newline at end of file
@@ -11,7 +11,7 @@ export default function Journal() {
   const fetchJournals = async () => {
     try {
       const res = await api.get('/api/journals');
-      setJournals(res.data || []);
+      setJournals(res.data.data || []);
     } catch (err) {
       console.error('Failed to fetch journals:', err);
     }
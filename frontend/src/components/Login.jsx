@@ -1,25 +1,103 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import api from '../utils/auth';
 
+const oidcErrors = {
+  account_exists: 'An account with this email already exists. Log in with your password and link the provider from your profile.',
+  email_required: 'The provider did not share a verified email address.',
+};
+
 export default function Login({ onLogin }) {
   const [email, setEmail] = useState('john@example.com');
   const [password, setPassword] = useState('password123');
   const [error, setError] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
+
+  const [providers, setProviders] = useState([]);
+
+  const completeLogin = (data) => {
+    localStorage.setItem('token', data.access_token);
+    if (data.refresh_token) {
+      localStorage.setItem('refreshToken', data.refresh_token);
+    }
+    onLogin(data.user || { name: 'User' });
+  };
+
+  useEffect(() => {
+    api.get('/api/auth/oidc/providers')
+      .then((res) => setProviders(res.data.providers || []))
+      .catch(() => setProviders([]));
+
+    // Returning from a social login provider
+    const params = new URLSearchParams(window.location.search);
+    const oidcCode = params.get('oidc_code');
+    const oidcError = params.get('oidc_error');
+    if (oidcCode || oidcError) {
+      window.history.replaceState(null, '', window.location.pathname);
+    }
+    if (oidcError) {
+      setError(oidcErrors[oidcError] || 'Social login failed');
+    }
+    if (oidcCode) {
+      api.post('/api/auth/oidc/exchange', { code: oidcCode })
+        .then((res) => {
+          if (res.data.mfa_required) {
+            setMfaToken(res.data.mfa_token);
+            return;
+          }
+          completeLogin(res.data);
+        })
+        .catch(() => setError('Social login failed'));
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
 
   const handleSubmit = async (e) => {
     e.preventDefault();
     try {
       const res = await api.post('/api/login', { email, password });
-      localStorage.setItem('token', res.data.access_token);
-      if (res.data.refresh_token) {
-        localStorage.setItem('refreshToken', res.data.refresh_token);
+      if (res.data.mfa_required) {
+        setError('');
+        setMfaToken(res.data.mfa_token);
+        return;
       }
-      onLogin(res.data.user || { name: 'User' });
+      completeLogin(res.data);
     } catch (err) {
       setError('Invalid credentials');
     }
   };
 
+  const handleMfaSubmit = async (e) => {
+    e.preventDefault();
+    try {
+      const res = await api.post('/api/login/mfa', { mfa_token: mfaToken, code });
+      completeLogin(res.data);
+    } catch (err) {
+      setError('Invalid authentication code');
+    }
+  };
+
+  if (mfaToken) {
+    return (
+      <div style={{ padding: 20 }}>
+        <h2>Two-factor authentication</h2>
+        {error && <p style={{ color: 'red' }}>{error}</p>}
+        <form onSubmit={handleMfaSubmit}>
+          <input
+            type="text"
+            placeholder="Authentication or recovery code"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            autoComplete="one-time-code"
+            required
+            style={{ margin: '10px 0', padding: 8, width: '100%' }}
+          />
+          <button type="submit" style={{ padding: 10 }}>Verify</button>
+        </form>
+      </div>
+    );
+  }
+
   return (
     <div style={{ padding: 20 }}>
       <h2>Login</h2>
@@ -43,6 +121,15 @@ export default function Login({ onLogin }) {
         />
         <button type="submit" style={{ padding: 10 }}>Login</button>
       </form>
+      {providers.map((provider) => (
+        <a
+          key={provider}
+          href={`${api.defaults.baseURL}/api/auth/oidc/${provider}/login`}
+          style={{ display: 'block', margin: '10px 0', padding: 10, textAlign: 'center', border: '1px solid #4a6fa5', color: '#4a6fa5', textDecoration: 'none' }}
+        >
+          Continue with {provider}
+        </a>
+      ))}
     </div>
   );
 }
\ No newline at end of file
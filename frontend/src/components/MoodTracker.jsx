@@ -9,7 +9,7 @@ export default function MoodTracker() {
   const fetchMoods = async () => {
     try {
       const res = await api.get('/api/moods');
-      setMoods(res.data);
+      setMoods(res.data.data || []);
     } catch (err) {
       console.error('Failed to fetch moods');
     }
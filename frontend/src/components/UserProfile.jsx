@@ -4,6 +4,7 @@ import api from '../utils/auth';
 export default function UserProfile() {
   const [profile, setProfile] = useState(null);
   const [stats, setStats] = useState(null);
+  const [memories, setMemories] = useState([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState('');
 
@@ -27,10 +28,43 @@ export default function UserProfile() {
     }
   };
 
+  const fetchMemories = async () => {
+    try {
+      const res = await api.get('/api/chat/memories');
+      setMemories(res.data);
+    } catch (err) {
+      console.error('Failed to fetch memories:', err);
+      setError('Failed to load what the assistant remembers');
+    }
+  };
+
+  // forgetMemory deletes something the AI assistant remembers
+  const forgetMemory = async (id) => {
+    try {
+      await api.delete(`/api/chat/memories/${id}`);
+      setMemories((prev) => prev.filter((m) => m.id !== id));
+    } catch (err) {
+      console.error('Failed to delete memory:', err);
+      setError('Failed to delete memory');
+    }
+  };
+
+  // toggleChatContext turns sharing moods and journal entries with the AI
+  // chat on or off
+  const toggleChatContext = async (enabled) => {
+    try {
+      await api.put('/api/user/profile', { chat_context_enabled: enabled });
+      setProfile((prev) => ({ ...prev, chat_context_enabled: enabled }));
+    } catch (err) {
+      console.error('Failed to update chat context setting:', err);
+      setError('Failed to update chat settings');
+    }
+  };
+
   useEffect(() => {
     const loadData = async () => {
       setLoading(true);
-      await Promise.all([fetchProfile(), fetchStats()]);
+      await Promise.all([fetchProfile(), fetchStats(), fetchMemories()]);
       setLoading(false);
     };
     loadData();
@@ -81,6 +115,25 @@ export default function UserProfile() {
               <strong>User ID:</strong> {profile.user_id}
             </div>
           </div>
+          <label style={{ display: 'block', marginTop: 15 }}>
+            <input
+              type="checkbox"
+              checked={profile.chat_context_enabled}
+              onChange={(e) => toggleChatContext(e.target.checked)}
+            />{' '}
+            Let the AI assistant see my recent moods and related journal entries
+          </label>
+          <h4 style={{ marginBottom: 5 }}>What the AI assistant remembers</h4>
+          {memories.length > 0 ? (
+            memories.map((m) => (
+              <div key={m.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', padding: '4px 0' }}>
+                <span>{m.content}</span>
+                <button onClick={() => forgetMemory(m.id)}>Forget</button>
+              </div>
+            ))
+          ) : (
+            <p style={{ color: '#666', fontStyle: 'italic', margin: 0 }}>Nothing yet.</p>
+          )}
         </div>
       )}
 
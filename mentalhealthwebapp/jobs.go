@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/services"
+)
+
+// jobRunRetention is how long job history is kept
+const jobRunRetention = 30 * 24 * time.Hour
+
+// registerJobs adds the maintenance jobs to scheduler. A job's schedule can
+// be changed with JOB_<NAME>_SCHEDULE (e.g. JOB_TOKEN_CLEANUP_SCHEDULE="@every 10m"),
+// or set to "off" to disable it.
+func registerJobs(scheduler *services.Scheduler, db *sql.DB, loginLimiter *services.LoginLimiter) error {
+	jobs := []struct {
+		name     string
+		schedule string
+		run      services.JobFunc
+	}{
+		{"token-cleanup", "*/30 * * * *", func(ctx context.Context) error {
+			return cleanupTokens(ctx, db)
+		}},
+		{"account-purge", "@hourly", func(ctx context.Context) error {
+			n, err := services.NewAccountService(db).PurgeDueAccounts()
+			if err == nil && n > 0 {
+				log.Printf("🗑️ Purged %d deleted account(s)", n)
+			}
+			return err
+		}},
+		{"login-attempt-cleanup", "15 * * * *", func(ctx context.Context) error {
+			_, err := loginLimiter.Prune(ctx)
+			return err
+		}},
+		{"job-history-cleanup", "30 3 * * *", func(ctx context.Context) error {
+			_, err := scheduler.PruneRuns(ctx, time.Now().Add(-jobRunRetention))
+			return err
+		}},
+	}
+
+	for _, job := range jobs {
+		schedule := job.schedule
+		if override := os.Getenv("JOB_" + strings.ToUpper(strings.ReplaceAll(job.name, "-", "_")) + "_SCHEDULE"); override != "" {
+			schedule = override
+		}
+		if schedule == "off" {
+			continue
+		}
+		if err := scheduler.Register(job.name, schedule, job.run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupTokens deletes expired sessions, tokens and login state. Every
+// step runs even if an earlier one fails.
+func cleanupTokens(ctx context.Context, db *sql.DB) error {
+	var errs []error
+
+	if err := services.NewRefreshTokenService(db).CleanupExpiredTokens(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := services.NewUserTokenService(db).DeleteExpired(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := services.NewIdentityService(db).DeleteExpiredStates(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := services.NewPersonalAccessTokenService(db).DeleteStale(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
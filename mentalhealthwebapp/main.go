@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 
 	"github.com/leketech/mental-health-app/config"
 	"github.com/leketech/mental-health-app/middleware"
+	"github.com/leketech/mental-health-app/migrations"
 	"github.com/leketech/mental-health-app/routes"
+	"github.com/leketech/mental-health-app/services"
+	"github.com/leketech/mental-health-app/utils"
 )
 
 func main() {
@@ -18,19 +24,139 @@ func main() {
 		log.Printf("⚠️ .env file not found, using system env")
 	}
 
+	// Cancelled on SIGINT/SIGTERM to shut down background work and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Connect to DB
 	if err := config.ConnectDB(); err != nil {
 		log.Fatal("❌ Failed to connect to database: ", err)
 	}
 	defer config.DB.Close()
 
-	// Fiber app
-	app := fiber.New()
+	// "main migrate up|down|status" manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal("❌ Migration failed: ", err)
+		}
+		return
+	}
+
+	// Apply pending migrations unless disabled (e.g. when run as a separate release step)
+	if os.Getenv("DB_AUTO_MIGRATE") != "false" {
+		migrator, err := migrations.NewMigrator(config.DB)
+		if err != nil {
+			log.Fatal("❌ Failed to load migrations: ", err)
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatal("❌ Failed to apply migrations: ", err)
+		}
+	}
+
+	// Token signing keys. There is deliberately no built-in default key.
+	keySet, err := utils.LoadKeySetFromEnv()
+	if err != nil {
+		log.Fatal("❌ Failed to load JWT signing keys: ", err)
+	}
+	if os.Getenv("JWT_EPHEMERAL_KEY") == "true" && os.Getenv("JWT_SIGNING_KEYS") == "" && os.Getenv("JWT_SIGNING_KEYS_FILE") == "" {
+		log.Printf("⚠️ Using an ephemeral JWT signing key; tokens will not survive a restart")
+	}
+	utils.SetKeySet(keySet)
+
+	// Revoked access tokens are checked in memory; other replicas'
+	// revocations arrive over LISTEN/NOTIFY
+	tokenBlacklist := services.NewTokenBlacklist(config.DB)
+	if err := tokenBlacklist.Load(); err != nil {
+		log.Fatal("❌ Failed to load token blacklist: ", err)
+	}
+	services.SetTokenBlacklist(tokenBlacklist)
+	go tokenBlacklist.Listen(ctx, os.Getenv("DB_CONNECTION_STRING"))
 
-	// CORS middleware
+	// Outgoing email
+	mailer, err := services.NewMailerFromEnv()
+	if err != nil {
+		log.Fatal("❌ Failed to configure mailer: ", err)
+	}
+	if os.Getenv("MAILER") != "smtp" {
+		log.Printf("⚠️ MAILER=%s keeps live email links in logs or files; use smtp in production", os.Getenv("MAILER"))
+	}
+
+	// Social login providers
+	oidcConfigs, err := services.OIDCConfigsFromEnv()
+	if err != nil {
+		log.Fatal("❌ Failed to configure OIDC providers: ", err)
+	}
+	oidcProviders := services.NewOIDCRegistry(oidcConfigs)
+
+	// AI chat provider (OpenAI or a compatible server, falling back to
+	// rule-based replies when it is down)
+	chatProvider, err := services.ChatProviderFromEnv()
+	if err != nil {
+		log.Fatal("❌ Failed to configure chat provider: ", err)
+	}
+	log.Printf("💬 Chat provider: %s", chatProvider.Name())
+
+	// Screens chat messages and replies for crises and medical advice
+	safetyClassifier := services.NewLexiconClassifier()
+
+	// Personal details are redacted before chat content reaches the provider
+	chatRedactor, err := services.RedactorFromEnv()
+	if err != nil {
+		log.Fatal("❌ Failed to configure chat redaction: ", err)
+	}
+
+	// Finds journal entries to share with the chat for users who opted in
+	journalRetriever, err := services.JournalRetrieverFromEnv(config.DB)
+	if err != nil {
+		log.Fatal("❌ Failed to configure chat context: ", err)
+	}
+
+	chatUsage := services.NewChatUsageService(config.DB, services.ChatQuotaConfigFromEnv())
+	chatServices := routes.ChatServices{
+		Provider:   chatProvider,
+		Classifier: safetyClassifier,
+		Redactor:   chatRedactor,
+		Usage:      chatUsage,
+		Context:    services.NewChatContextService(config.DB, journalRetriever),
+		Summaries:  services.NewChatSummaryService(config.DB, chatUsage),
+		Redactions: services.NewChatRedactionService(config.DB),
+	}
+
+	// Failed login tracking (Postgres by default so all replicas share counters)
+	var loginAttempts services.LoginAttemptStore = services.NewPostgresLoginAttemptStore(config.DB)
+	if os.Getenv("LOGIN_ATTEMPT_STORE") == "memory" {
+		loginAttempts = services.NewMemoryLoginAttemptStore()
+	}
+	loginLimiter := services.NewLoginLimiter(loginAttempts, services.LoginLimiterConfigFromEnv())
+
+	// Background jobs (token cleanup, account purge, ...). Only one replica
+	// runs them at a time; set SCHEDULER_ENABLED=false to opt a replica out.
+	scheduler := services.NewScheduler(config.DB)
+	if err := registerJobs(scheduler, config.DB, loginLimiter); err != nil {
+		log.Fatal("❌ Failed to register jobs: ", err)
+	}
+	if os.Getenv("SCHEDULER_ENABLED") != "false" {
+		scheduler.Start(ctx)
+	}
+
+	// Fiber app. Behind a load balancer, set PROXY_HEADER (e.g. X-Forwarded-For)
+	// so rate limiting sees the real client IP.
+	app := fiber.New(fiber.Config{
+		ProxyHeader: os.Getenv("PROXY_HEADER"),
+	})
+
+	// CORS middleware. The frontend may send cookies, which linking a social
+	// login needs to tie the request to the browser.
+	appOrigin := routes.AppOrigin()
 	app.Use(func(c *fiber.Ctx) error {
-		c.Set("Access-Control-Allow-Origin", "*")
-		c.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		if origin := c.Get(fiber.HeaderOrigin); origin != "" && origin == appOrigin {
+			c.Set("Access-Control-Allow-Origin", origin)
+			c.Set("Access-Control-Allow-Credentials", "true")
+			c.Vary(fiber.HeaderOrigin)
+		} else {
+			c.Set("Access-Control-Allow-Origin", "*")
+		}
+		c.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		c.Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if c.Method() == "OPTIONS" {
@@ -45,38 +171,116 @@ func main() {
 		return c.SendString("Mental Health API 🚀")
 	})
 
+	// Public keys for verifying tokens issued by this app
+	app.Get("/.well-known/jwks.json", routes.JWKS(keySet))
+
 	// Public routes (no authentication required)
-	app.Post("/api/chat", routes.ChatHandler)
-	app.Post("/api/login", routes.Login(config.DB))
-	app.Post("/api/register", routes.Register(config.DB))
+	app.Post("/api/login", routes.Login(config.DB, loginLimiter, mailer))
+	app.Post("/api/login/mfa", routes.LoginMFA(config.DB, loginLimiter, mailer))
+	app.Post("/api/register", routes.Register(config.DB, mailer))
 	app.Post("/api/refresh", routes.RefreshToken(config.DB))
+	app.Post("/api/user/email/confirm", routes.ConfirmEmailChange(config.DB))
+	app.Post("/api/verify-email", routes.VerifyEmail(config.DB))
+	app.Post("/api/password/forgot", routes.ForgotPassword(config.DB, mailer))
+	app.Post("/api/password/reset", routes.ResetPassword(config.DB))
+	app.Get("/api/auth/oidc/providers", routes.ListOIDCProviders(oidcProviders))
+	app.Get("/api/auth/oidc/:provider/login", routes.StartOIDCLogin(config.DB, oidcProviders))
+	app.Get("/api/auth/oidc/:provider/callback", routes.OIDCCallback(config.DB, oidcProviders))
+	app.Post("/api/auth/oidc/exchange", routes.ExchangeOIDCLogin(config.DB))
 
 	// JWT Middleware with blacklist checking
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Fatal("❌ JWT_SECRET is not set")
-	}
-	jwtMiddleware := middleware.JWTProtectedWithBlacklist(secret, config.DB)
+	jwtMiddleware := middleware.JWTProtectedWithBlacklist(keySet, config.DB, tokenBlacklist)
 
 	// Protected API routes (authentication required)
 	api := app.Group("/api", jwtMiddleware)
 
+	// Optionally block unverified accounts from everything but verification and logout.
+	// Accounts from before email verification count as verified (migration 025).
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" {
+		api.Use(middleware.RequireVerifiedEmail(config.DB, "/api/logout", "/api/verify-email/resend", "/api/user/profile"))
+	}
+
+	// Endpoints without a scope turn personal access tokens away
+	noTokens := middleware.RejectPersonalAccessTokens()
+
 	// Logout endpoint (requires authentication to blacklist current token)
-	api.Post("/logout", routes.Logout(config.DB))
+	api.Post("/logout", noTokens, routes.Logout(config.DB))
+	api.Post("/verify-email/resend", noTokens, routes.ResendVerificationEmail(config.DB, mailer))
+
+	// Session (device) endpoints
+	sessions := api.Group("/sessions", noTokens)
+	sessions.Get("/", routes.ListSessions(config.DB))
+	sessions.Patch("/:id", routes.UpdateSession(config.DB))
+	sessions.Delete("/:id", routes.RevokeSession(config.DB))
 
-	// Mood endpoints
-	api.Get("/moods", routes.GetMoods(config.DB))
-	api.Post("/moods", routes.CreateMood(config.DB))
+	// AI chat endpoints
+	chat := api.Group("/chat", noTokens)
+	chat.Post("/", routes.ChatHandler(config.DB, chatServices))
+	chat.Post("/stream", routes.ChatStreamHandler(config.DB, chatServices))
+	chat.Get("/usage", routes.GetChatUsage(chatServices.Usage))
+	chat.Post("/tool-calls/:id/confirm", routes.ResolveChatToolCall(config.DB, true))
+	chat.Post("/tool-calls/:id/reject", routes.ResolveChatToolCall(config.DB, false))
+	chat.Get("/memories", routes.ListChatMemories(config.DB))
+	chat.Delete("/memories/:id", routes.DeleteChatMemory(config.DB))
+	chat.Get("/personas", routes.ListChatPersonas(config.DB))
+	conversations := api.Group("/conversations", noTokens)
+	conversations.Get("/", routes.ListConversations(config.DB))
+	conversations.Post("/", routes.CreateConversation(config.DB))
+	conversations.Get("/:id/messages", routes.GetConversationMessages(config.DB, chatServices.Summaries))
+	conversations.Get("/:id/context", routes.GetConversationContext(config.DB, chatServices.Context))
+	conversations.Put("/:id/persona", routes.SetConversationPersona(config.DB))
+	conversations.Delete("/:id", routes.DeleteConversation(config.DB))
 
-	// Journal endpoints
-	api.Get("/journals", routes.GetJournals(config.DB))
-	api.Post("/journals", routes.CreateJournal(config.DB))
-	api.Put("/journals/:id", routes.UpdateJournal(config.DB))
-	api.Delete("/journals/:id", routes.DeleteJournal(config.DB))
+	// Mood endpoints (personal access tokens need a moods scope)
+	moodsRead, moodsWrite := middleware.RequireScope(services.ScopeMoodsRead), middleware.RequireScope(services.ScopeMoodsWrite)
+	api.Get("/moods", moodsRead, routes.GetMoods(config.DB))
+	api.Post("/moods", moodsWrite, routes.CreateMood(config.DB))
+	api.Get("/moods/:id", moodsRead, routes.GetMood(config.DB))
+	api.Put("/moods/:id", moodsWrite, routes.UpdateMood(config.DB))
+	api.Patch("/moods/:id", moodsWrite, routes.PatchMood(config.DB))
+	api.Delete("/moods/:id", moodsWrite, routes.DeleteMood(config.DB))
+
+	// Journal endpoints (personal access tokens need a journals scope)
+	journalsRead, journalsWrite := middleware.RequireScope(services.ScopeJournalsRead), middleware.RequireScope(services.ScopeJournalsWrite)
+	api.Get("/journals", journalsRead, routes.GetJournals(config.DB))
+	api.Post("/journals", journalsWrite, routes.CreateJournal(config.DB))
+	api.Get("/journals/:id", journalsRead, routes.GetJournal(config.DB))
+	api.Put("/journals/:id", journalsWrite, routes.UpdateJournal(config.DB))
+	api.Patch("/journals/:id", journalsWrite, routes.PatchJournal(config.DB))
+	api.Delete("/journals/:id", journalsWrite, routes.DeleteJournal(config.DB))
 
 	// User endpoints
-	api.Get("/user/profile", routes.GetUserProfile(config.DB))
-	api.Get("/user/stats", routes.GetUserStats(config.DB))
+	profileRead := middleware.RequireScope(services.ScopeProfileRead)
+	api.Get("/user/profile", profileRead, routes.GetUserProfile(config.DB))
+	api.Put("/user/profile", noTokens, routes.UpdateUserProfile(config.DB))
+	api.Post("/user/password", noTokens, routes.ChangePassword(config.DB))
+	api.Post("/user/email", noTokens, routes.RequestEmailChange(config.DB, mailer))
+	api.Post("/user/reauth", noTokens, routes.RequestReauthCode(config.DB, mailer))
+	api.Get("/user/security-events", noTokens, routes.GetSecurityEvents(config.DB))
+	api.Get("/user/safety-events", noTokens, routes.GetSafetyEvents(config.DB))
+	api.Post("/user/2fa/setup", noTokens, routes.SetupTwoFactor(config.DB))
+	api.Post("/user/2fa/verify", noTokens, routes.VerifyTwoFactor(config.DB))
+	api.Post("/user/2fa/disable", noTokens, routes.DisableTwoFactor(config.DB))
+	api.Get("/user/identities", noTokens, routes.ListIdentities(config.DB))
+	api.Post("/user/identities/:provider/link", noTokens, routes.StartOIDCLink(config.DB, oidcProviders))
+	api.Delete("/user/identities/:id", noTokens, routes.UnlinkIdentity(config.DB))
+	tokens := api.Group("/user/tokens", noTokens)
+	tokens.Get("/", routes.ListPersonalAccessTokens(config.DB))
+	tokens.Post("/", routes.CreatePersonalAccessToken(config.DB))
+	tokens.Delete("/:id", routes.RevokePersonalAccessToken(config.DB))
+	api.Get("/user/export", middleware.RequireScope(services.ScopeAccountExport), routes.ExportUserData(config.DB))
+	api.Delete("/user", noTokens, routes.DeleteAccount(config.DB))
+	api.Post("/user/deletion/cancel", noTokens, routes.CancelAccountDeletion(config.DB))
+	api.Get("/user/stats", profileRead, routes.GetUserStats(config.DB))
+
+	// Admin endpoints
+	admin := api.Group("/admin", noTokens, middleware.RequireAdmin(config.DB))
+	admin.Post("/users/:id/unlock", routes.UnlockUserLogin(config.DB, loginLimiter))
+	admin.Get("/jobs", routes.ListJobs(scheduler))
+	admin.Get("/chat/personas", routes.AdminListChatPersonas(config.DB))
+	admin.Post("/chat/personas", routes.CreateChatPersona(config.DB))
+	admin.Patch("/chat/personas/:id", routes.UpdateChatPersona(config.DB))
+	admin.Get("/chat/personas/:id/versions", routes.ListChatPersonaVersions(config.DB))
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -88,7 +292,18 @@ func main() {
 	log.Printf("✅ Server starting on port :%s", port)
 
 	// ✅ Use :port instead of 0.0.0.0:port (cleaner and works better on Render)
+	go func() {
+		<-ctx.Done()
+		log.Printf("🛑 Shutting down")
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatalf("❌ Failed to start server: %v", err)
 	}
+
+	// Let running jobs finish before the database connection closes
+	scheduler.Stop()
 }
\ No newline at end of file
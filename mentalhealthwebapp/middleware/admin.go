@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAdmin only lets administrators through. It must run after the JWT
+// middleware.
+func RequireAdmin(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Unauthorized: invalid user context",
+			})
+		}
+
+		var isAdmin bool
+		err := db.QueryRow(`SELECT is_admin FROM users WHERE id = $1`, userID).Scan(&isAdmin)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Admin check error: %v", err)
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Internal server error",
+			})
+		}
+
+		if !isAdmin {
+			return c.Status(403).JSON(fiber.Map{
+				"error": "Forbidden",
+			})
+		}
+
+		return c.Next()
+	}
+}
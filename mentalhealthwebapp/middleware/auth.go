@@ -4,6 +4,7 @@ package middleware
 import (
 	"database/sql"
 	"github.com/leketech/mental-health-app/services"
+	"github.com/leketech/mental-health-app/utils"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,15 +12,27 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// JWTProtected creates a JWT middleware using the provided secret
-func JWTProtected(secret string) fiber.Handler {
+// JWTProtected creates a JWT middleware that verifies tokens against keys
+func JWTProtected(keys *utils.KeySet) fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		SigningKey: []byte(secret),
+		KeyFunc: keys.Keyfunc,
 		SuccessHandler: func(c *fiber.Ctx) error {
 			// Extract user ID from JWT token and add to context
 			user := c.Locals("user").(*jwt.Token)
 			claims := user.Claims.(jwt.MapClaims)
 
+			if err := utils.VerifyIssuerAudience(claims); err != nil {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid token: " + err.Error(),
+				})
+			}
+
+			if claims["typ"] != utils.TokenTypeAccess {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid token type",
+				})
+			}
+
 			// Get user ID from claims
 			userIDFloat, ok := claims["sub"].(float64)
 			if !ok {
@@ -39,10 +52,13 @@ func JWTProtected(secret string) fiber.Handler {
 	})
 }
 
-// JWTProtectedWithBlacklist creates a JWT middleware with token blacklist checking
-func JWTProtectedWithBlacklist(secret string, db *sql.DB) fiber.Handler {
-	return jwtware.New(jwtware.Config{
-		SigningKey: []byte(secret),
+// JWTProtectedWithBlacklist creates a JWT middleware that rejects tokens in
+// blacklist. Personal access tokens are accepted too, but only routes that
+// declare a scope with RequireScope let them through; other routes should
+// use RejectPersonalAccessTokens.
+func JWTProtectedWithBlacklist(keys *utils.KeySet, db *sql.DB, blacklist *services.TokenBlacklist) fiber.Handler {
+	jwtHandler := jwtware.New(jwtware.Config{
+		KeyFunc: keys.Keyfunc,
 		SuccessHandler: func(c *fiber.Ctx) error {
 			// Extract access token from Authorization header
 			authHeader := c.Get("Authorization")
@@ -54,17 +70,32 @@ func JWTProtectedWithBlacklist(secret string, db *sql.DB) fiber.Handler {
 
 			accessToken := authHeader[7:]
 
+			// Extract user ID from JWT token and add to context
+			user := c.Locals("user").(*jwt.Token)
+			claims := user.Claims.(jwt.MapClaims)
+
 			// Check if token is blacklisted
-			refreshService := services.NewRefreshTokenService(db)
-			if refreshService.IsTokenBlacklisted(accessToken) {
+			tokenID := utils.TokenID(accessToken, claims)
+			if blacklist.Contains(tokenID) {
 				return c.Status(401).JSON(fiber.Map{
 					"error": "Token has been revoked",
 				})
 			}
 
-			// Extract user ID from JWT token and add to context
-			user := c.Locals("user").(*jwt.Token)
-			claims := user.Claims.(jwt.MapClaims)
+			// Tokens must be issued by this app for this API
+			if err := utils.VerifyIssuerAudience(claims); err != nil {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid token: " + err.Error(),
+				})
+			}
+
+			// Refresh and MFA challenge tokens are signed with the same key
+			// but must never authorize API calls
+			if claims["typ"] != utils.TokenTypeAccess {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid token type",
+				})
+			}
 
 			// Get user ID from claims
 			userIDFloat, ok := claims["sub"].(float64)
@@ -80,8 +111,38 @@ func JWTProtectedWithBlacklist(secret string, db *sql.DB) fiber.Handler {
 			c.Locals("userID", userID)
 			c.Locals("userIDStr", strconv.Itoa(userID))
 			c.Locals("accessToken", accessToken)
+			c.Locals("accessTokenID", tokenID)
+
+			// Session (refresh token family) the access token was issued for
+			if sessionID, ok := claims["sid"].(string); ok {
+				c.Locals("sessionID", sessionID)
+			}
 
 			return c.Next()
 		},
 	})
+
+	return personalAccessTokenAuth(services.NewPersonalAccessTokenService(db).Authenticate, jwtHandler)
+}
+
+// personalAccessTokenAuth checks personal access tokens with authenticate
+// and hands every other request to next
+func personalAccessTokenAuth(authenticate func(token string) (*services.AuthenticatedToken, error), next fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		authHeader := c.Get("Authorization")
+		if len(authHeader) > 7 && authHeader[:7] == "Bearer " && services.IsPersonalAccessToken(authHeader[7:]) {
+			token, err := authenticate(authHeader[7:])
+			if err != nil {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid or expired personal access token",
+				})
+			}
+
+			// userID is only set by RequireScope once the scope is checked
+			c.Locals("personalAccessToken", token)
+			return c.Next()
+		}
+
+		return next(c)
+	}
 }
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// newTestTokenApp serves a route scoped to moods:read and one no scope
+// covers. Personal access tokens "mhp_valid" (user 7, moods:read only) and
+// "mhp_readonly" (user 8, profile:read) exist; other bearer tokens stand in
+// for JWTs of user 1.
+func newTestTokenApp() *fiber.App {
+	tokens := map[string]*services.AuthenticatedToken{
+		services.PersonalAccessTokenPrefix + "valid":    {ID: 1, UserID: 7, Scopes: []string{services.ScopeMoodsRead}},
+		services.PersonalAccessTokenPrefix + "readonly": {ID: 2, UserID: 8, Scopes: []string{services.ScopeProfileRead}},
+	}
+	authenticate := func(token string) (*services.AuthenticatedToken, error) {
+		if t, ok := tokens[token]; ok {
+			return t, nil
+		}
+		return nil, services.ErrInvalidPersonalAccessToken
+	}
+	jwt := func(c *fiber.Ctx) error {
+		c.Locals("userID", 1)
+		return c.Next()
+	}
+
+	userID := func(c *fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(c.Locals("userID").(int)))
+	}
+	app := fiber.New()
+	app.Use(personalAccessTokenAuth(authenticate, jwt))
+	app.Get("/moods", RequireScope(services.ScopeMoodsRead), userID)
+	app.Get("/chat", RejectPersonalAccessTokens(), userID)
+	return app
+}
+
+// TestPersonalAccessTokenRoutes verifies personal access tokens only reach
+// routes their scopes cover, while JWTs reach every route
+func TestPersonalAccessTokenRoutes(t *testing.T) {
+	app := newTestTokenApp()
+	prefix := services.PersonalAccessTokenPrefix
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		body   string // the user ID, or the error
+	}{
+		{"token with scope", "/moods", prefix + "valid", 200, "7"},
+		{"token without scope", "/moods", prefix + "readonly", 403, "Token is missing the required scope: moods:read"},
+		{"unknown token", "/moods", prefix + "unknown", 401, "Invalid or expired personal access token"},
+		{"token on unscoped route", "/chat", prefix + "valid", 403, "Personal access tokens are not accepted for this endpoint"},
+		{"JWT on scoped route", "/moods", "jwt", 200, "1"},
+		{"JWT on unscoped route", "/chat", "jwt", 200, "1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, resp.StatusCode)
+			continue
+		}
+
+		if tt.status == 200 {
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("%s: expected user %s, got %s", tt.name, tt.body, got)
+			}
+			continue
+		}
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["error"] != tt.body {
+			t.Errorf("%s: expected error %q, got %q", tt.name, tt.body, body["error"])
+		}
+	}
+}
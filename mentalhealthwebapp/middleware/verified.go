@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// RequireVerifiedEmail rejects requests from users who have not verified
+// their email address. It must run after the JWT middleware. Paths in
+// exempt stay reachable so unverified users can resend the link or log out.
+func RequireVerifiedEmail(db *sql.DB, exempt ...string) fiber.Handler {
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		if exemptPaths[c.Path()] {
+			return c.Next()
+		}
+
+		// Personal access tokens have no user ID until their scope is checked
+		userID, ok := c.Locals("userID").(int)
+		if token, isToken := c.Locals("personalAccessToken").(*services.AuthenticatedToken); isToken {
+			userID, ok = token.UserID, true
+		}
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Unauthorized: invalid user context",
+			})
+		}
+
+		var verified bool
+		err := db.QueryRow(`SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&verified)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Email verification check error: %v", err)
+			}
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		if !verified {
+			return c.Status(403).JSON(fiber.Map{
+				"error": "Email address not verified",
+			})
+		}
+
+		return c.Next()
+	}
+}
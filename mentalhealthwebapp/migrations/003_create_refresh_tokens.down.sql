@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS cleanup_expired_tokens();
+DROP TABLE IF EXISTS blacklisted_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_journals_user_id_created_at;
+DROP INDEX IF EXISTS idx_moods_user_id_created_at;
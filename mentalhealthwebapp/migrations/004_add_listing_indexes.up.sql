@@ -0,0 +1,3 @@
+-- Keyset pagination over (created_at, id) for mood and journal listings
+CREATE INDEX IF NOT EXISTS idx_moods_user_id_created_at ON moods(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_journals_user_id_created_at ON journals(user_id, created_at DESC, id DESC);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS journals_set_updated_at ON journals;
+DROP TRIGGER IF EXISTS moods_set_updated_at ON moods;
+ALTER TABLE journals DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE moods DROP COLUMN IF EXISTS updated_at;
+DROP FUNCTION IF EXISTS set_updated_at();
@@ -0,0 +1,24 @@
+-- Keep updated_at current on every change
+CREATE OR REPLACE FUNCTION set_updated_at()
+RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE moods ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE moods SET updated_at = COALESCE(created_at, NOW()) WHERE updated_at IS NULL;
+ALTER TABLE moods ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL;
+
+ALTER TABLE journals ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE;
+UPDATE journals SET updated_at = COALESCE(created_at, NOW()) WHERE updated_at IS NULL;
+ALTER TABLE journals ALTER COLUMN updated_at SET DEFAULT NOW(), ALTER COLUMN updated_at SET NOT NULL;
+
+DROP TRIGGER IF EXISTS moods_set_updated_at ON moods;
+CREATE TRIGGER moods_set_updated_at BEFORE UPDATE ON moods
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+DROP TRIGGER IF EXISTS journals_set_updated_at ON journals;
+CREATE TRIGGER journals_set_updated_at BEFORE UPDATE ON journals
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS user_tokens;
+DROP TRIGGER IF EXISTS users_set_updated_at ON users;
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
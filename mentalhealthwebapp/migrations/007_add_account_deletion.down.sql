@@ -0,0 +1,12 @@
+ALTER TABLE user_tokens DROP CONSTRAINT IF EXISTS user_tokens_user_id_fkey,
+    ADD CONSTRAINT user_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_user_id_fkey,
+    ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE journals DROP CONSTRAINT IF EXISTS journals_user_id_fkey,
+    ADD CONSTRAINT journals_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE moods DROP CONSTRAINT IF EXISTS moods_user_id_fkey,
+    ADD CONSTRAINT moods_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_for;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_scheduled_for;
+ALTER TABLE users DROP COLUMN IF EXISTS deletion_requested_at;
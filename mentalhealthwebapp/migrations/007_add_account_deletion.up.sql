@@ -0,0 +1,16 @@
+-- Pending account deletion (cancellable until deletion_scheduled_for)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_for TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_for ON users(deletion_scheduled_for)
+    WHERE deletion_scheduled_for IS NOT NULL;
+
+-- Deleting a user removes everything they own
+ALTER TABLE moods DROP CONSTRAINT IF EXISTS moods_user_id_fkey,
+    ADD CONSTRAINT moods_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE journals DROP CONSTRAINT IF EXISTS journals_user_id_fkey,
+    ADD CONSTRAINT journals_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens DROP CONSTRAINT IF EXISTS refresh_tokens_user_id_fkey,
+    ADD CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE user_tokens DROP CONSTRAINT IF EXISTS user_tokens_user_id_fkey,
+    ADD CONSTRAINT user_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
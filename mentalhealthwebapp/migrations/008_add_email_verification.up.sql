@@ -0,0 +1,2 @@
+-- Set once the user proves they own their email address
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
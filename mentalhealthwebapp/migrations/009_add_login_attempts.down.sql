@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+DROP TABLE IF EXISTS login_attempts;
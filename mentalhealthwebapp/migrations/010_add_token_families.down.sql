@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS security_events;
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS access_expires_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS access_token_hash;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS user_identities;
+
+-- Fails if social-only accounts exist; remove or give them a password first
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
@@ -0,0 +1,30 @@
+-- Accounts created through social login have no password
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+
+-- External OpenID Connect identities linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_login_at TIMESTAMP WITH TIME ZONE NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- In-flight authorization requests (state, nonce and PKCE verifier)
+CREATE TABLE IF NOT EXISTS oidc_states (
+    id SERIAL PRIMARY KEY,
+    state_hash VARCHAR(255) UNIQUE NOT NULL,
+    provider VARCHAR(64) NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    user_id INTEGER NULL REFERENCES users(id) ON DELETE CASCADE,
+    nonce VARCHAR(255) NOT NULL,
+    code_verifier VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_states_expires_at ON oidc_states(expires_at);
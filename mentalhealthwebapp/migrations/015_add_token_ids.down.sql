@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS blacklisted_tokens_notify ON blacklisted_tokens;
+DROP FUNCTION IF EXISTS notify_token_blacklisted();
+
+ALTER TABLE refresh_tokens RENAME COLUMN access_token_id TO access_token_hash;
+ALTER TABLE blacklisted_tokens ALTER COLUMN expires_at TYPE TIMESTAMP;
+ALTER TABLE blacklisted_tokens RENAME COLUMN token_id TO token_hash;
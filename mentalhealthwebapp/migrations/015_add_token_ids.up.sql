@@ -0,0 +1,19 @@
+-- Access tokens carry a "jti" claim and are blacklisted by it instead of a
+-- hash of the whole token. Rows written before this migration hold token
+-- hashes, which remain the ID of tokens issued without a jti.
+ALTER TABLE blacklisted_tokens RENAME COLUMN token_hash TO token_id;
+ALTER TABLE blacklisted_tokens ALTER COLUMN expires_at TYPE TIMESTAMP WITH TIME ZONE;
+ALTER TABLE refresh_tokens RENAME COLUMN access_token_hash TO access_token_id;
+
+-- Tell every replica's in-memory blacklist about new entries
+CREATE OR REPLACE FUNCTION notify_token_blacklisted()
+RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('token_blacklist', NEW.token_id || ' ' || EXTRACT(EPOCH FROM NEW.expires_at)::BIGINT);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER blacklisted_tokens_notify
+    AFTER INSERT ON blacklisted_tokens
+    FOR EACH ROW EXECUTE FUNCTION notify_token_blacklisted();
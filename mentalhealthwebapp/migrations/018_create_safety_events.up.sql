@@ -0,0 +1,13 @@
+-- Chat messages flagged by the safety classifier
+CREATE TABLE IF NOT EXISTS safety_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id INTEGER REFERENCES conversations(id) ON DELETE SET NULL,
+    message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL,
+    source VARCHAR(16) NOT NULL CHECK (source IN ('user', 'assistant')),
+    categories TEXT[] NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_safety_events_user_id_created_at ON safety_events(user_id, created_at DESC);
@@ -0,0 +1,8 @@
+-- Number of personal details redacted from each conversation before it
+-- was sent to the chat provider, by kind
+CREATE TABLE IF NOT EXISTS conversation_redactions (
+    conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (conversation_id, kind)
+);
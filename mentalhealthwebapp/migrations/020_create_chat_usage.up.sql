@@ -0,0 +1,15 @@
+-- One row per chat request sent to a provider, with the tokens it used
+CREATE TABLE IF NOT EXISTS chat_usage (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id INTEGER REFERENCES conversations(id) ON DELETE SET NULL,
+    provider VARCHAR(32) NOT NULL DEFAULT '',
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    estimated BOOLEAN NOT NULL DEFAULT FALSE, -- the provider did not report usage
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_usage_user_id_created_at ON chat_usage(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_chat_usage_created_at ON chat_usage(created_at);
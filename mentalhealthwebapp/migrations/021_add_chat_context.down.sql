@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_context_shares;
+ALTER TABLE users DROP COLUMN IF EXISTS chat_context_enabled;
@@ -0,0 +1,17 @@
+-- Opt-in sharing of moods and journals with the AI chat
+ALTER TABLE users ADD COLUMN IF NOT EXISTS chat_context_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- What was shared with the chat provider for each reply
+CREATE TABLE IF NOT EXISTS chat_context_shares (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    mood_ids INTEGER[] NOT NULL DEFAULT '{}',
+    journal_ids INTEGER[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_context_shares_conversation_id_id ON chat_context_shares(conversation_id, id);
+CREATE INDEX IF NOT EXISTS idx_chat_context_shares_user_id ON chat_context_shares(user_id);
@@ -0,0 +1,17 @@
+-- Tools the AI chat called for a user, including writes waiting for the
+-- user to confirm them
+CREATE TABLE IF NOT EXISTS chat_tool_calls (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id INTEGER NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    message_id INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    arguments JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('completed', 'failed', 'pending', 'confirmed', 'rejected')),
+    result JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_tool_calls_conversation_id_id ON chat_tool_calls(conversation_id, id);
+CREATE INDEX IF NOT EXISTS idx_chat_tool_calls_user_id ON chat_tool_calls(user_id);
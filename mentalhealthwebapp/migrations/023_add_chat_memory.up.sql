@@ -0,0 +1,19 @@
+-- Rolling summaries of the older turns of each conversation, which stand
+-- in for them once they no longer fit in the prompt
+CREATE TABLE IF NOT EXISTS conversation_summaries (
+    conversation_id INTEGER PRIMARY KEY REFERENCES conversations(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    through_message_id INTEGER NOT NULL, -- the newest message summarized
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Facts the user approved for the assistant to remember across conversations
+CREATE TABLE IF NOT EXISTS chat_memories (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content VARCHAR(300) NOT NULL,
+    tool_call_id INTEGER REFERENCES chat_tool_calls(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_memories_user_id_id ON chat_memories(user_id, id);
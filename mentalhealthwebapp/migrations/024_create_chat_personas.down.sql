@@ -0,0 +1,4 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS persona_version_id;
+ALTER TABLE conversations DROP COLUMN IF EXISTS persona_id;
+DROP TABLE IF EXISTS chat_persona_versions;
+DROP TABLE IF EXISTS chat_personas;
@@ -0,0 +1,57 @@
+-- Assistant personas users can pick per conversation. Their settings are
+-- versioned: a change adds a version, and the newest is the one in use.
+CREATE TABLE IF NOT EXISTS chat_personas (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(500) NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- At most one persona is used for conversations that have not picked one
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_personas_default ON chat_personas(is_default) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS chat_persona_versions (
+    id SERIAL PRIMARY KEY,
+    persona_id INTEGER NOT NULL REFERENCES chat_personas(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    system_prompt TEXT NOT NULL,
+    temperature REAL NOT NULL CHECK (temperature > 0 AND temperature <= 2),
+    max_tokens INTEGER NOT NULL CHECK (max_tokens > 0),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (persona_id, version)
+);
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS persona_id INTEGER REFERENCES chat_personas(id) ON DELETE SET NULL;
+-- The persona version in use when each message was sent, for review
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS persona_version_id INTEGER REFERENCES chat_persona_versions(id) ON DELETE SET NULL;
+
+INSERT INTO chat_personas (slug, name, description, is_default) VALUES
+    ('supportive-listener', 'Supportive listener', 'Listens with warmth and helps you feel heard.', TRUE),
+    ('cbt-coach', 'CBT coach', 'Helps you notice unhelpful thoughts and find more balanced ones.', FALSE),
+    ('mindfulness-guide', 'Mindfulness guide', 'Guides you through breathing and grounding exercises.', FALSE),
+    ('journaling-helper', 'Journaling helper', 'Helps you put your thoughts and feelings into words.', FALSE)
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO chat_persona_versions (persona_id, version, system_prompt, temperature, max_tokens)
+SELECT p.id, 1, v.system_prompt, v.temperature, v.max_tokens
+FROM (VALUES
+    ('supportive-listener',
+     'You are a compassionate mental health assistant and a supportive listener. Respond kindly, reflect back what the user shares, and ask gentle, open questions. Do not rush to fix things.',
+     0.7, 800),
+    ('cbt-coach',
+     'You are a coach using techniques from cognitive behavioural therapy. Help the user notice unhelpful thoughts, weigh the evidence for and against them, and find more balanced alternatives. Suggest small, practical steps.',
+     0.5, 800),
+    ('mindfulness-guide',
+     'You are a calm mindfulness guide. Help the user notice the present moment in simple language, and offer short breathing or grounding exercises step by step when they would help.',
+     0.7, 600),
+    ('journaling-helper',
+     'You help the user reflect through journaling. Offer thoughtful prompts, help them put their feelings into words, and offer to draft a journal entry when it fits.',
+     0.8, 800)
+) AS v (slug, system_prompt, temperature, max_tokens)
+JOIN chat_personas p ON p.slug = v.slug
+ON CONFLICT (persona_id, version) DO NOTHING;
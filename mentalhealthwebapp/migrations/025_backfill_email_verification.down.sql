@@ -0,0 +1,3 @@
+UPDATE users SET email_verified_at = NULL
+WHERE email_verified_at = created_at
+    AND created_at < (SELECT applied_at FROM schema_migrations WHERE version = 8);
@@ -0,0 +1,6 @@
+-- Accounts created before email verification existed never got a link, so
+-- count their address as verified from sign-up; REQUIRE_EMAIL_VERIFICATION
+-- would otherwise lock them out. Later accounts must still verify.
+UPDATE users SET email_verified_at = created_at
+WHERE email_verified_at IS NULL
+    AND created_at < (SELECT applied_at FROM schema_migrations WHERE version = 8);
@@ -0,0 +1,2 @@
+-- The original case of lowercased addresses is not kept
+DROP INDEX IF EXISTS idx_users_lower_email;
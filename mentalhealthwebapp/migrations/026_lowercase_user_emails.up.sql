@@ -0,0 +1,25 @@
+-- Email addresses are matched case-insensitively, so store them lowercased
+-- and keep them unique regardless of case. Accounts whose addresses only
+-- differ by case have to be merged by hand first; stop and list them
+-- rather than picking one.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(ids, '; ') INTO duplicates
+    FROM (
+        SELECT string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY LOWER(email)
+        HAVING COUNT(*) > 1
+    ) AS clashes;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users with emails that only differ by case must be merged first (user ids: %)', duplicates;
+    END IF;
+END $$;
+
+UPDATE users SET email = LOWER(email) WHERE email <> LOWER(email);
+UPDATE user_tokens SET new_email = LOWER(new_email) WHERE new_email <> LOWER(new_email);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_lower_email ON users(LOWER(email));
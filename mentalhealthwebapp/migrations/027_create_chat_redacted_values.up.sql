@@ -0,0 +1,12 @@
+-- Names redacted from each user's chat requests. Later requests replace
+-- them too, so a name stays hidden in summaries and memories that no longer
+-- have the context it was found in.
+CREATE TABLE IF NOT EXISTS chat_redacted_values (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    value TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chat_redacted_values_user_id_value ON chat_redacted_values(user_id, LOWER(value));
@@ -0,0 +1,71 @@
+// migrations/migrate_test.go
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// TestLoadEmbedded verifies that the migrations shipped in the binary are well formed
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(Files)
+	if err != nil {
+		t.Fatalf("Load embedded migrations: %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations, got none")
+	}
+
+	for i, m := range migrations {
+		if m.Down == "" {
+			t.Errorf("Migration %03d_%s has no down script", m.Version, m.Name)
+		}
+		if i > 0 && migrations[i-1].Version >= m.Version {
+			t.Errorf("Migrations not sorted: %d before %d", migrations[i-1].Version, m.Version)
+		}
+	}
+}
+
+// TestLoadChecksumChangesWithContents verifies that editing an up script changes its checksum
+func TestLoadChecksumChangesWithContents(t *testing.T) {
+	original, err := Load(fstest.MapFS{
+		"001_init.up.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := Load(fstest.MapFS{
+		"001_init.up.sql": {Data: []byte("CREATE TABLE a (id BIGINT);")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if original[0].Checksum == edited[0].Checksum {
+		t.Error("Expected checksum to change when the up script is edited")
+	}
+}
+
+// TestLoadRejectsInvalidFiles verifies that malformed migration sets are reported
+func TestLoadRejectsInvalidFiles(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"bad name": {
+			"create_users.sql": {Data: []byte("SELECT 1;")},
+		},
+		"missing up": {
+			"001_init.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"duplicate version": {
+			"001_init.up.sql":  {Data: []byte("SELECT 1;")},
+			"001_other.up.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, fsys := range cases {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Conversation is a chat thread with the AI assistant
+type Conversation struct {
+    ID        int       `json:"id"`
+    Title     string    `json:"title"`
+    PersonaID *int      `json:"persona_id"` // nil for the default persona
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Message is one turn of a conversation
+type Message struct {
+    ID               int       `json:"id"`
+    ConversationID   int       `json:"conversation_id"`
+    Role             string    `json:"role"` // user or assistant
+    Content          string    `json:"content"`
+    PersonaVersionID *int      `json:"persona_version_id"` // the persona in use when it was sent
+    CreatedAt        time.Time `json:"created_at"`
+}
\ No newline at end of file
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Identity is an external (OpenID Connect) account linked to a user
+type Identity struct {
+    ID          int        `json:"id"`
+    Provider    string     `json:"provider"`
+    Email       *string    `json:"email"`
+    CreatedAt   time.Time  `json:"created_at"`
+    LastLoginAt *time.Time `json:"last_login_at"`
+}
\ No newline at end of file
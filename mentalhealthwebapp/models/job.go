@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// JobRun is one execution of a scheduled job
+type JobRun struct {
+    ID         int64      `json:"id"`
+    JobName    string     `json:"job_name"`
+    Status     string     `json:"status"`
+    StartedAt  time.Time  `json:"started_at"`
+    FinishedAt *time.Time `json:"finished_at"`
+    Error      *string    `json:"error"`
+}
+
+// Job is a registered scheduled job
+type Job struct {
+    Name      string     `json:"name"`
+    Schedule  string     `json:"schedule"`
+    NextRunAt *time.Time `json:"next_run_at"`
+    Running   bool       `json:"running"`
+}
\ No newline at end of file
@@ -8,4 +8,5 @@ type Journal struct {
     Title     string    `json:"title"`
     Body      string    `json:"body"`
     CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
 }
\ No newline at end of file
@@ -8,4 +8,5 @@ type Mood struct {
     Mood       string    `json:"mood"`
     Note       string    `json:"note"`
     CreatedAt  time.Time `json:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at"`
 }
\ No newline at end of file
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// PersonalAccessToken is a long-lived, scoped API token. The token itself is
+// only shown once; TokenPrefix lets users recognise it afterwards.
+type PersonalAccessToken struct {
+    ID          int        `json:"id"`
+    Name        string     `json:"name"`
+    TokenPrefix string     `json:"token_prefix"`
+    Scopes      []string   `json:"scopes"`
+    CreatedAt   time.Time  `json:"created_at"`
+    LastUsedAt  *time.Time `json:"last_used_at"`
+    ExpiresAt   *time.Time `json:"expires_at"`
+}
\ No newline at end of file
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session is a signed-in device. Its ID is the refresh token family ID.
+type Session struct {
+    ID         string    `json:"id"`
+    UserAgent  string    `json:"user_agent"`
+    IPAddress  string    `json:"ip_address"`
+    Label      *string   `json:"label"`
+    CreatedAt  time.Time `json:"created_at"`
+    LastUsedAt time.Time `json:"last_used_at"`
+    ExpiresAt  time.Time `json:"expires_at"`
+    Current    bool      `json:"current"`
+}
\ No newline at end of file
@@ -1,7 +1,16 @@
 package models
 
+import "time"
+
 type User struct {
-    ID    int    `json:"id"`
-    Name  string `json:"name"`
-    Email string `json:"email"`
+    ID                 int        `json:"id"`
+    Name               string     `json:"name"`
+    Email              string     `json:"email"`
+    Timezone           string     `json:"timezone"`
+    Locale             string     `json:"locale"`
+    AvatarURL          *string    `json:"avatar_url"`
+    EmailVerifiedAt    *time.Time `json:"email_verified_at"`
+    TwoFactorEnabled   bool       `json:"two_factor_enabled"`
+    ChatContextEnabled bool       `json:"chat_context_enabled"`
+    CreatedAt          time.Time  `json:"created_at"`
 }
\ No newline at end of file
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// accountDeletionGrace returns how long a deletion request can be cancelled,
+// configured in days with ACCOUNT_DELETION_GRACE_DAYS (default 14)
+func accountDeletionGrace() time.Duration {
+	days := 14
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ExportUserData returns everything stored about the authenticated user as
+// a JSON document (default) or, with ?format=zip, a ZIP archive
+func ExportUserData(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		format := c.Query("format", "json")
+		if format != "json" && format != "zip" {
+			return c.Status(400).JSON(fiber.Map{"error": "format must be 'json' or 'zip'"})
+		}
+
+		exportService := services.NewExportService(db)
+		export, err := exportService.ExportUser(userID)
+		if err != nil {
+			log.Printf("Export error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to export data"})
+		}
+
+		filename := fmt.Sprintf("mental-health-export-%s", export.ExportedAt.Format("20060102-150405"))
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		if format == "zip" {
+			var buf bytes.Buffer
+			if err := export.WriteZip(&buf); err != nil {
+				log.Printf("Export archive error: %v", err)
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to export data"})
+			}
+			c.Attachment(filename + ".zip")
+			return c.Send(buf.Bytes())
+		}
+
+		c.Attachment(filename + ".json")
+		return c.JSON(export)
+	}
+}
+
+// DeleteAccount schedules the authenticated user's account for permanent
+// deletion after a grace period and signs the user out everywhere
+func DeleteAccount(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Password   string `json:"password"`
+			ReauthCode string `json:"reauth_code"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if ok, err := confirmIdentity(c, db, userID, req.Password, req.ReauthCode); !ok {
+			return err
+		}
+
+		accountService := services.NewAccountService(db)
+		scheduledFor, err := accountService.ScheduleDeletion(userID, accountDeletionGrace())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to schedule account deletion"})
+		}
+
+		refreshService := services.NewRefreshTokenService(db)
+		if err := refreshService.RevokeAllUserTokens(userID); err != nil {
+			log.Printf("Failed to revoke tokens for deleted account: %v", err)
+		}
+		if err := services.NewPersonalAccessTokenService(db).RevokeAllForUser(userID); err != nil {
+			log.Printf("Failed to revoke personal access tokens for deleted account: %v", err)
+		}
+		if tokenID, ok := c.Locals("accessTokenID").(string); ok {
+			if err := refreshService.BlacklistAccessToken(tokenID, time.Now().Add(30*time.Minute)); err != nil {
+				log.Printf("Failed to blacklist access token: %v", err)
+			}
+		}
+
+		return c.Status(202).JSON(fiber.Map{
+			"message":                "Account scheduled for deletion. Log in and cancel before the scheduled time to keep it.",
+			"deletion_scheduled_for": scheduledFor,
+		})
+	}
+}
+
+// CancelAccountDeletion keeps an account that was scheduled for deletion
+func CancelAccountDeletion(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		accountService := services.NewAccountService(db)
+		cancelled, err := accountService.CancelDeletion(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel account deletion"})
+		}
+		if !cancelled {
+			return c.Status(404).JSON(fiber.Map{"error": "No account deletion is pending"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Account deletion cancelled",
+		})
+	}
+}
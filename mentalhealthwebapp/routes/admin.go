@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"database/sql"
+	"log"
+	"net"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// UnlockUserLogin clears failed login attempts and any lockout for a user.
+// An optional "ip" in the body also unlocks that client address.
+func UnlockUserLogin(db *sql.DB, limiter *services.LoginLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+		}
+
+		type Request struct {
+			IP string `json:"ip"`
+		}
+
+		var req Request
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+			}
+		}
+		if req.IP != "" && net.ParseIP(req.IP) == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid IP address"})
+		}
+
+		var email string
+		err = db.QueryRow(`SELECT email FROM users WHERE id = $1`, userID).Scan(&email)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		if err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		if err := limiter.UnlockAccount(c.Context(), email); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to unlock account"})
+		}
+		if req.IP != "" {
+			if err := limiter.UnlockIP(c.Context(), req.IP); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to unlock IP address"})
+			}
+		}
+
+		log.Printf("Admin %v unlocked login for user %d", c.Locals("userID"), userID)
+
+		return c.JSON(fiber.Map{
+			"message": "Login unlocked",
+			"user_id": userID,
+		})
+	}
+}
+
+// ListJobs returns the scheduled jobs and their recent runs. Only the
+// replica currently running jobs knows when each one runs next.
+func ListJobs(scheduler *services.Scheduler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			return c.Status(400).JSON(fiber.Map{"error": "limit must be between 1 and 200"})
+		}
+
+		runs, err := scheduler.RecentRuns(limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch job runs"})
+		}
+
+		return c.JSON(fiber.Map{
+			"leader": scheduler.IsLeader(),
+			"jobs":   scheduler.Jobs(),
+			"runs":   runs,
+		})
+	}
+}
@@ -2,9 +2,15 @@
 package routes
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,8 +21,139 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Login handles user authentication and returns access and refresh tokens
-func Login(db *sql.DB) fiber.Handler {
+// sessionMeta describes the client making the request
+func sessionMeta(c *fiber.Ctx) services.SessionMeta {
+	return services.SessionMeta{
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IPAddress: c.IP(),
+	}
+}
+
+// issueTokenPair starts a new session for userID on the requesting device
+// and returns its access and refresh tokens
+func issueTokenPair(c *fiber.Ctx, db *sql.DB, userID int) (*utils.TokenPair, error) {
+	refreshService := services.NewRefreshTokenService(db)
+	tokenPair, _, err := refreshService.StartSession(userID, sessionMeta(c))
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenPair, nil
+}
+
+// appLink builds a link to the frontend, carrying a single-use token if given
+func appLink(path, token string) string {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3000"
+	}
+	link := strings.TrimRight(baseURL, "/") + path
+	if token != "" {
+		link += "?token=" + url.QueryEscape(token)
+	}
+	return link
+}
+
+// AppOrigin returns the origin of APP_BASE_URL, where the frontend is served
+func AppOrigin() string {
+	u, err := url.Parse(appLink("", ""))
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// tooManyLoginAttempts responds 429 with a Retry-After header
+func tooManyLoginAttempts(c *fiber.Ctx, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(429).JSON(fiber.Map{
+		"error":       "Too many login attempts. Please try again later.",
+		"retry_after": seconds,
+	})
+}
+
+// sendLockoutNotification tells the account owner that logins were locked
+func sendLockoutNotification(mailer services.Mailer, email, ip string) {
+	msg := services.Message{
+		To:      email,
+		Subject: "Sign-in temporarily locked",
+		Body: "We noticed several failed attempts to sign in to your Mental Health App account from IP address " + ip +
+			", so we've temporarily locked sign-in.\n\n" +
+			"If this was you, wait a few minutes and try again, or reset your password:\n\n" +
+			appLink("/forgot-password", "") +
+			"\n\nIf this wasn't you, we recommend changing your password once you can sign in.",
+	}
+
+	go func() {
+		if err := mailer.Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send lockout notification: %v", err)
+		}
+	}()
+}
+
+// loginUser is the user summary returned on successful login
+type loginUser struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+}
+
+// completeLogin starts a session for a fully authenticated user and writes
+// the login response
+func completeLogin(c *fiber.Ctx, db *sql.DB, user loginUser, extra fiber.Map) error {
+	tokenPair, err := issueTokenPair(c, db, user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to generate tokens",
+		})
+	}
+
+	response := fiber.Map{
+		"message":       "Login successful",
+		"access_token":  tokenPair.AccessToken,
+		"refresh_token": tokenPair.RefreshToken,
+		"expires_in":    tokenPair.ExpiresIn,
+		"user":          user,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+
+	// Let the client offer to cancel a pending account deletion
+	accountService := services.NewAccountService(db)
+	if scheduledFor, err := accountService.PendingDeletion(user.ID); err == nil && scheduledFor != nil {
+		response["deletion_scheduled_for"] = scheduledFor
+	}
+
+	return c.JSON(response)
+}
+
+// mfaChallenge responds with a short-lived token to be exchanged, together
+// with a second factor, at /api/login/mfa
+func mfaChallenge(c *fiber.Ctx, userID int) error {
+	mfaToken, err := utils.GenerateMFAChallengeToken(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to generate tokens",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":      "Two-factor authentication required",
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+		"expires_in":   int64(utils.MFAChallengeTTL.Seconds()),
+	})
+}
+
+// Login handles user authentication and returns access and refresh tokens.
+// Failed attempts are tracked per account and per client IP; once locked
+// out, the caller gets 429 with Retry-After. Users with two-factor
+// authentication get a short-lived MFA challenge token instead, to be
+// exchanged at /api/login/mfa.
+func Login(db *sql.DB, limiter *services.LoginLimiter, mailer services.Mailer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		type Request struct {
 			Email    string `json:"email" validate:"required,email"`
@@ -29,21 +166,42 @@ func Login(db *sql.DB) fiber.Handler {
 				"error": "Invalid request body",
 			})
 		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
 
-		var user struct {
-			ID           int    `json:"id"`
-			Name         string `json:"name"`
-			Email        string `json:"email"`
-			PasswordHash string `json:"-"`
+		// Refuse to check passwords while the account or client IP is locked out
+		ip := c.IP()
+		retryAfter, err := limiter.Check(c.Context(), req.Email, ip)
+		if err != nil {
+			log.Printf("Login limiter error: %v", err)
+		}
+		if retryAfter > 0 {
+			return tooManyLoginAttempts(c, retryAfter)
+		}
+
+		// failLogin records the failed attempt and returns the same generic
+		// error whether or not the account exists
+		failLogin := func(accountEmail string) error {
+			locked, err := limiter.RecordFailure(c.Context(), req.Email, ip)
+			if err != nil {
+				log.Printf("Login limiter error: %v", err)
+			}
+			if locked && accountEmail != "" {
+				sendLockoutNotification(mailer, accountEmail, ip)
+			}
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Invalid email or password",
+			})
 		}
 
-		query := `SELECT id, name, email, password_hash FROM users WHERE email = $1`
-		err := db.QueryRow(query, req.Email).Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash)
+		var user loginUser
+		var twoFactorEnabled bool
+
+		// Accounts created through social login have no password and never match
+		query := `SELECT id, name, email, COALESCE(password_hash, ''), totp_enabled_at IS NOT NULL FROM users WHERE LOWER(email) = $1`
+		err = db.QueryRow(query, req.Email).Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash, &twoFactorEnabled)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				return c.Status(401).JSON(fiber.Map{
-					"error": "Invalid email or password",
-				})
+				return failLogin("")
 			}
 			log.Printf("Login query error: %v", err)
 			return c.Status(500).JSON(fiber.Map{
@@ -53,38 +211,110 @@ func Login(db *sql.DB) fiber.Handler {
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 		if err != nil {
-			return c.Status(401).JSON(fiber.Map{
-				"error": "Invalid email or password",
+			return failLogin(user.Email)
+		}
+
+		// The failure count is only reset once the second factor is verified
+		if twoFactorEnabled {
+			return mfaChallenge(c, user.ID)
+		}
+
+		if err := limiter.RecordSuccess(c.Context(), req.Email); err != nil {
+			log.Printf("Login limiter error: %v", err)
+		}
+
+		return completeLogin(c, db, user, nil)
+	}
+}
+
+// LoginMFA completes a two-factor login: it exchanges the MFA challenge
+// token from Login and a TOTP or recovery code for access and refresh
+// tokens. Wrong codes count towards the account lockout.
+func LoginMFA(db *sql.DB, limiter *services.LoginLimiter, mailer services.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			MFAToken string `json:"mfa_token" validate:"required"`
+			Code     string `json:"code" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid request body",
 			})
 		}
 
-		tokenPair, err := utils.GenerateTokenPair(user.ID)
+		userID, err := utils.ParseMFAChallengeToken(req.MFAToken)
 		if err != nil {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Invalid or expired MFA challenge",
+			})
+		}
+
+		var user loginUser
+		query := `SELECT id, name, email FROM users WHERE id = $1`
+		if err := db.QueryRow(query, userID).Scan(&user.ID, &user.Name, &user.Email); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid or expired MFA challenge",
+				})
+			}
+			log.Printf("Login query error: %v", err)
 			return c.Status(500).JSON(fiber.Map{
-				"error": "Failed to generate tokens",
+				"error": "Internal server error",
 			})
 		}
 
-		refreshService := services.NewRefreshTokenService(db)
-		expiresAt := time.Now().Add(7 * 24 * time.Hour)
-		if err := refreshService.StoreRefreshToken(user.ID, tokenPair.RefreshToken, expiresAt); err != nil {
+		ip := c.IP()
+		retryAfter, err := limiter.Check(c.Context(), user.Email, ip)
+		if err != nil {
+			log.Printf("Login limiter error: %v", err)
+		}
+		if retryAfter > 0 {
+			return tooManyLoginAttempts(c, retryAfter)
+		}
+
+		twoFactorService := services.NewTwoFactorService(db)
+		usedRecoveryCode, err := twoFactorService.Verify(user.ID, req.Code)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidTwoFactorCode) || errors.Is(err, services.ErrTwoFactorNotEnabled) {
+				locked, err := limiter.RecordFailure(c.Context(), user.Email, ip)
+				if err != nil {
+					log.Printf("Login limiter error: %v", err)
+				}
+				if locked {
+					sendLockoutNotification(mailer, user.Email, ip)
+				}
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid authentication code",
+				})
+			}
 			return c.Status(500).JSON(fiber.Map{
-				"error": "Failed to store refresh token",
+				"error": "Internal server error",
 			})
 		}
 
-		return c.JSON(fiber.Map{
-			"message":       "Login successful",
-			"access_token":  tokenPair.AccessToken,
-			"refresh_token": tokenPair.RefreshToken,
-			"expires_in":    tokenPair.ExpiresIn,
-			"user":          user,
-		})
+		if err := limiter.RecordSuccess(c.Context(), user.Email); err != nil {
+			log.Printf("Login limiter error: %v", err)
+		}
+
+		var extra fiber.Map
+		if usedRecoveryCode {
+			securityEvents := services.NewSecurityEventService(db)
+			securityEvents.Record(user.ID, services.SecurityEventRecoveryCodeUsed, nil, ip, c.Get(fiber.HeaderUserAgent))
+
+			// Warn the client when the user is running out of recovery codes
+			if remaining, err := twoFactorService.RemainingRecoveryCodes(user.ID); err == nil {
+				extra = fiber.Map{"recovery_codes_remaining": remaining}
+			}
+		}
+
+		return completeLogin(c, db, user, extra)
 	}
 }
 
-// Register handles user registration
-func Register(db *sql.DB) fiber.Handler {
+// Register handles user registration and sends an email verification link
+func Register(db *sql.DB, mailer services.Mailer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		type Request struct {
 			Name     string `json:"name" validate:"required"`
@@ -99,6 +329,26 @@ func Register(db *sql.DB) fiber.Handler {
 			})
 		}
 
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Invalid email address",
+			})
+		}
+		req.Email = email
+
+		if strings.TrimSpace(req.Name) == "" || len(req.Name) > 100 {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Name must be between 1 and 100 characters",
+			})
+		}
+
+		if len(req.Password) < 6 || len(req.Password) > 72 {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Password must be between 6 and 72 characters",
+			})
+		}
+
 		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{
@@ -121,8 +371,12 @@ func Register(db *sql.DB) fiber.Handler {
 			})
 		}
 
+		if err := sendVerificationEmail(c.Context(), db, mailer, userID, req.Email); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+		}
+
 		return c.Status(201).JSON(fiber.Map{
-			"message": "User registered successfully",
+			"message": "User registered successfully. Check your email to verify your address.",
 			"user_id": userID,
 			"email":   req.Email,
 		})
@@ -144,31 +398,29 @@ func RefreshToken(db *sql.DB) fiber.Handler {
 		}
 
 		refreshService := services.NewRefreshTokenService(db)
-		userID, err := refreshService.ValidateRefreshToken(req.RefreshToken)
+		tokenPair, err := refreshService.RotateRefreshToken(req.RefreshToken, sessionMeta(c))
 		if err != nil {
-			return c.Status(401).JSON(fiber.Map{
-				"error": "Invalid or expired refresh token",
-			})
-		}
+			// A replayed token means it leaked; its family is already revoked
+			var reuseErr *services.TokenReuseError
+			if errors.As(err, &reuseErr) {
+				log.Printf("Refresh token reuse detected for user %d (family %s)", reuseErr.UserID, reuseErr.FamilyID)
+				securityEvents := services.NewSecurityEventService(db)
+				details := map[string]interface{}{"family_id": reuseErr.FamilyID}
+				securityEvents.Record(reuseErr.UserID, services.SecurityEventRefreshTokenReuse, details, c.IP(), c.Get(fiber.HeaderUserAgent))
+			}
 
-		tokenPair, err := utils.GenerateTokenPair(userID)
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{
-				"error": "Failed to generate tokens",
-			})
-		}
+			var validationErr *jwt.ValidationError
+			if reuseErr != nil || errors.As(err, &validationErr) {
+				return c.Status(401).JSON(fiber.Map{
+					"error": "Invalid or expired refresh token",
+				})
+			}
 
-		expiresAt := time.Now().Add(7 * 24 * time.Hour)
-		if err := refreshService.StoreRefreshToken(userID, tokenPair.RefreshToken, expiresAt); err != nil {
 			return c.Status(500).JSON(fiber.Map{
-				"error": "Failed to store refresh token",
+				"error": "Failed to refresh token",
 			})
 		}
 
-		if err := refreshService.RevokeRefreshToken(req.RefreshToken); err != nil {
-			fmt.Printf("Warning: Failed to revoke old refresh token: %v\n", err)
-		}
-
 		return c.JSON(fiber.Map{
 			"message":       "Token refreshed successfully",
 			"access_token":  tokenPair.AccessToken,
@@ -202,34 +454,43 @@ func Logout(db *sql.DB) fiber.Handler {
 					"error": "Failed to logout from all devices",
 				})
 			}
+			if err := services.NewPersonalAccessTokenService(db).RevokeAllForUser(userID); err != nil {
+				return c.Status(500).JSON(fiber.Map{
+					"error": "Failed to logout from all devices",
+				})
+			}
 		} else if req.RefreshToken != "" {
 			if err := refreshService.RevokeRefreshToken(req.RefreshToken); err != nil {
 				fmt.Printf("Warning: Failed to revoke refresh token: %v\n", err)
 			}
 		}
 
+		// End the current session so it disappears from the device list
+		if sessionID, ok := c.Locals("sessionID").(string); ok && sessionID != "" && hasUserID && !req.LogoutAll {
+			sessionService := services.NewSessionService(db)
+			if _, err := sessionService.Revoke(userID, sessionID); err != nil {
+				log.Printf("Failed to revoke session: %v", err)
+			}
+		}
+
 		if hasUserID {
 			authHeader := c.Get("Authorization")
 			if authHeader != "" && len(authHeader) > 7 && authHeader[:7] == "Bearer " {
 				accessToken := authHeader[7:]
 
-				token, parseErr := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-					return utils.GetJWTSecret(), nil
-				})
-
 				var expiresAt time.Time
-				if parseErr == nil && token.Claims != nil {
-					if claims, ok := token.Claims.(jwt.MapClaims); ok {
-						if exp, exists := claims["exp"].(float64); exists {
-							expiresAt = time.Unix(int64(exp), 0)
-						}
+				tokenID := utils.HashToken(accessToken)
+				if claims, parseErr := utils.ParseToken(accessToken); parseErr == nil {
+					if exp, exists := claims["exp"].(float64); exists {
+						expiresAt = time.Unix(int64(exp), 0)
 					}
+					tokenID = utils.TokenID(accessToken, claims)
 				}
 				if expiresAt.IsZero() {
 					expiresAt = time.Now().Add(15 * time.Minute)
 				}
 
-				if err := refreshService.BlacklistAccessToken(accessToken, expiresAt); err != nil {
+				if err := refreshService.BlacklistAccessToken(tokenID, expiresAt); err != nil {
 					log.Printf("Failed to blacklist access token: %v", err)
 				}
 			}
@@ -2,42 +2,759 @@
 package routes
 
 import (
-    "os"
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-    "github.com/gofiber/fiber/v2"
-    openai "github.com/sashabaranov/go-openai"
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/services"
 )
 
-func ChatHandler(c *fiber.Ctx) error {
-    type Request struct {
-        Message string `json:"message" validate:"required"`
-    }
-
-    var req Request
-    if err := c.BodyParser(&req); err != nil {
-        return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
-    }
-
-    client := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
-    resp, err := client.CreateChatCompletion(c.Context(), openai.ChatCompletionRequest{
-        Model: openai.GPT3Dot5Turbo,
-        Messages: []openai.ChatCompletionMessage{
-            {
-                Role:    openai.ChatMessageRoleSystem,
-                Content: "You are a compassionate mental health assistant. Respond kindly.",
-            },
-            {
-                Role:    openai.ChatMessageRoleUser,
-                Content: req.Message,
-            },
-        },
-        MaxTokens: 150,
-    })
-    if err != nil {
-        return c.Status(500).JSON(fiber.Map{"error": "AI request failed"})
-    }
-
-    return c.JSON(fiber.Map{
-        "reply": resp.Choices[0].Message.Content,
-    })
+// chatSystemPrompt sets the assistant's tone when no persona is available
+const chatSystemPrompt = "You are a compassionate mental health assistant. Respond kindly."
+
+// chatSafetyPrompt sets the assistant's limits. It follows every persona's
+// prompt so admins cannot leave it out. Replies are still checked, as a
+// prompt alone cannot be relied on.
+const chatSafetyPrompt = "You are not a doctor: never diagnose conditions or advise on medication or doses, " +
+	"and suggest a qualified professional for medical questions. " +
+	"If the user may be in danger, encourage them to contact emergency services or a crisis line."
+
+// maxChatMessageLength is the longest message a user can send
+const maxChatMessageLength = 4000
+
+// defaultChatHistoryTokenBudget is how many tokens of earlier turns are sent
+// with each message unless CHAT_HISTORY_TOKEN_BUDGET says otherwise
+const defaultChatHistoryTokenBudget = 2000
+
+// defaultChatMaxTokens caps the length of a reply unless CHAT_MAX_TOKENS
+// says otherwise
+const defaultChatMaxTokens = 800
+
+// chatStreamTimeout bounds how long a streamed reply may take
+const chatStreamTimeout = 2 * time.Minute
+
+// chatSummaryTimeout bounds how long summarizing a conversation may take
+const chatSummaryTimeout = time.Minute
+
+// chatHistoryTokenBudget returns the configured budget for the memories,
+// summary and earlier turns sent with a message
+func chatHistoryTokenBudget() int {
+	if n, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_TOKEN_BUDGET")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultChatHistoryTokenBudget
+}
+
+// chatMaxTokens returns the configured reply length limit
+func chatMaxTokens() int {
+	if n, err := strconv.Atoi(os.Getenv("CHAT_MAX_TOKENS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultChatMaxTokens
+}
+
+// chatSystemPromptFor returns the system prompt of a persona, or the
+// default one when persona is nil, followed by the safety limits
+func chatSystemPromptFor(persona *services.ChatPersona) string {
+	if persona == nil {
+		return chatSystemPrompt + " " + chatSafetyPrompt
+	}
+	return persona.Version.SystemPrompt + "\n\n" + chatSafetyPrompt
+}
+
+// chatCompletionMessages builds the prompt: the system prompt with the
+// user's memories, the summary of older turns and any context the user
+// shares, then the recent turns and the new message
+func chatCompletionMessages(system string, prompt *services.ChatPrompt, message, sharedContext string) []services.ChatMessage {
+	for _, section := range []string{prompt.SystemContext(), sharedContext} {
+		if section != "" {
+			system += "\n\n" + section
+		}
+	}
+	messages := []services.ChatMessage{
+		{Role: "system", Content: system},
+	}
+	for _, m := range prompt.History {
+		messages = append(messages, services.ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	return append(messages, services.ChatMessage{Role: services.MessageRoleUser, Content: message})
+}
+
+// chatRequest builds the provider request for a turn
+func (t *chatTurn) chatRequest() services.ChatRequest {
+	sharedContext := ""
+	if t.context != nil {
+		sharedContext = t.context.Content
+	}
+	req := services.ChatRequest{
+		Messages:  chatCompletionMessages(chatSystemPromptFor(t.persona), t.prompt, t.message, sharedContext),
+		MaxTokens: chatMaxTokens(),
+	}
+	// CHAT_MAX_TOKENS caps every persona
+	if t.persona != nil {
+		req.Temperature = t.persona.Version.Temperature
+		if t.persona.Version.MaxTokens < req.MaxTokens {
+			req.MaxTokens = t.persona.Version.MaxTokens
+		}
+	}
+	return req
+}
+
+// personaVersionID returns the persona version the turn uses, if any
+func (t *chatTurn) personaVersionID() *int {
+	if t.persona == nil {
+		return nil
+	}
+	return &t.persona.Version.ID
+}
+
+// loadChatContext adds a summary of the user's moods and journals to the
+// turn if they have opted in. If it cannot be built the reply goes ahead
+// without it.
+func (t *chatTurn) loadChatContext(ctx context.Context, contexts *services.ChatContextService) {
+	if !t.contextEnabled || contexts == nil {
+		return
+	}
+	loc, err := time.LoadLocation(t.timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	shared, err := contexts.Build(ctx, t.userID, t.message, loc)
+	if err != nil {
+		log.Printf("Error building chat context: %v", err)
+		return
+	}
+	t.context = shared
+}
+
+// recordChatContext records the turn's context as the provider received
+// it, after redaction, and returns it for the client to show
+func recordChatContext(contexts *services.ChatContextService, turn *chatTurn, messageID int, redacting *services.RedactingChatProvider) *services.ChatContext {
+	if turn.context == nil {
+		return nil
+	}
+	shared := *turn.context
+	shared.Content = redacting.Redacted(shared.Content)
+	contexts.Record(turn.userID, turn.conversation.ID, messageID, &shared)
+	return &shared
+}
+
+// summarizeConversation folds older turns into the conversation's summary
+// once they outgrow the history budget, adding the tokens used to the
+// request usageID. It runs in the background so the reply is not held up.
+func summarizeConversation(chat ChatServices, userID, conversationID int, usageID int64) {
+	if chat.Summaries == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), chatSummaryTimeout)
+		defer cancel()
+
+		redacting := chat.redactingProvider(userID)
+		if err := chat.Summaries.Update(ctx, redacting, conversationID, usageID, chatHistoryTokenBudget()); err != nil {
+			log.Printf("Error summarizing conversation: %v", err)
+		}
+		chat.rememberRedactions(userID, redacting)
+	}()
+}
+
+// chatQuotaExceeded responds 429 with a Retry-After header and when the
+// limit resets
+func chatQuotaExceeded(c *fiber.Ctx, quotaErr *services.ChatQuotaError) error {
+	seconds := int(math.Ceil(time.Until(quotaErr.ResetAt).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	message := "You have reached your chat limit. Please try again later."
+	if quotaErr.Scope == "global" {
+		message = "The assistant is busy right now. Please try again later."
+	}
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(429).JSON(fiber.Map{
+		"error":       message,
+		"scope":       quotaErr.Scope,
+		"limit":       quotaErr.Limit,
+		"max":         quotaErr.Max,
+		"reset_at":    quotaErr.ResetAt,
+		"retry_after": seconds,
+	})
+}
+
+// reserveChatRequest counts the request against the user's chat limits. On
+// failure the error response has been sent and the returned ID is zero.
+func reserveChatRequest(c *fiber.Ctx, usage *services.ChatUsageService, turn *chatTurn) (int64, error) {
+	usageID, err := usage.Reserve(c.Context(), turn.userID, turn.conversation.ID)
+	if err != nil {
+		var quotaErr *services.ChatQuotaError
+		if errors.As(err, &quotaErr) {
+			return 0, chatQuotaExceeded(c, quotaErr)
+		}
+		return 0, c.Status(500).JSON(fiber.Map{"error": "Failed to check chat limits"})
+	}
+	return usageID, nil
+}
+
+// recordChatUsage stores the tokens a request used, estimating them when
+// the provider did not say
+func recordChatUsage(usage *services.ChatUsageService, usageID int64, req services.ChatRequest, result *services.ChatReply) {
+	if result == nil {
+		usage.Record(usageID, "", nil, false)
+		return
+	}
+	if result.Usage != nil {
+		usage.Record(usageID, result.Provider, result.Usage, false)
+		return
+	}
+	usage.Record(usageID, result.Provider, services.EstimateChatUsage(req, result.Content), true)
+}
+
+// chatErrorResponse picks the status and message for a reply that could
+// not be generated
+func chatErrorResponse(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 504, "AI service timed out"
+	}
+	return 503, "AI service unavailable, please try again later"
+}
+
+// ChatServices are what the chat handlers use besides the database
+type ChatServices struct {
+	Provider   services.ChatProvider
+	Classifier services.SafetyClassifier
+	Redactor   *services.Redactor
+	Usage      *services.ChatUsageService
+	Context    *services.ChatContextService
+	Summaries  *services.ChatSummaryService
+	Redactions *services.ChatRedactionService
+}
+
+// redactingProvider wraps the chat provider for one request of userID. The
+// names redacted from the user's earlier requests are replaced too, so the
+// summaries and memories that mention them stay redacted.
+func (chat ChatServices) redactingProvider(userID int) *services.RedactingChatProvider {
+	redacting := chat.Redactor.NewRedactingChatProvider(chat.Provider)
+	if chat.Redactions != nil {
+		if values, err := chat.Redactions.List(userID); err == nil {
+			redacting.Seed(values)
+		}
+	}
+	return redacting
+}
+
+// rememberRedactions keeps the names redacted for a request for the user's
+// later ones
+func (chat ChatServices) rememberRedactions(userID int, redacting *services.RedactingChatProvider) {
+	if chat.Redactions != nil {
+		chat.Redactions.Remember(userID, redacting.Values())
+	}
+}
+
+// chatTurn is a validated chat message with the conversation it belongs to
+// and what to send along
+type chatTurn struct {
+	userID          int
+	locale          string
+	timezone        string
+	contextEnabled  bool
+	conversation    *models.Conversation
+	newConversation bool // started for this message
+	message         string
+	persona         *services.ChatPersona // nil without an active default
+	prompt          *services.ChatPrompt
+	context         *services.ChatContext // set by loadChatContext
+}
+
+// abandon removes the conversation started for a turn that failed before
+// it was saved, so failed requests leave no empty conversations behind
+func (t *chatTurn) abandon(db *sql.DB) {
+	if t.newConversation {
+		services.NewConversationService(db).DeleteEmpty(t.userID, t.conversation.ID)
+	}
+}
+
+// beginChatTurn parses a chat request and loads its conversation, starting
+// a new one when conversation_id is omitted, and its persona. A persona_id
+// switches the conversation to that persona. On failure the error response
+// has been sent, any new conversation removed, and the returned turn is nil.
+// Once it succeeds, a failure before saveChatTurn must call abandon.
+func beginChatTurn(c *fiber.Ctx, db *sql.DB) (*chatTurn, error) {
+	// Get user ID from JWT context
+	userID, ok := c.Locals("userID").(int)
+	if !ok {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+	}
+
+	type Request struct {
+		Message        string `json:"message" validate:"required"`
+		ConversationID int    `json:"conversation_id"`
+		PersonaID      int    `json:"persona_id"`
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Message is required"})
+	}
+	if len(req.Message) > maxChatMessageLength {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Message must be 4000 characters or less"})
+	}
+
+	// The locale picks which crisis resources to offer; the timezone dates
+	// the moods and journals shared by users who opted in
+	turn := &chatTurn{userID: userID, message: req.Message}
+	query := `SELECT locale, timezone, chat_context_enabled FROM users WHERE id = $1`
+	if err := db.QueryRow(query, userID).Scan(&turn.locale, &turn.timezone, &turn.contextEnabled); err != nil {
+		log.Printf("Error loading user settings: %v", err)
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Failed to load user"})
+	}
+
+	conversationService := services.NewConversationService(db)
+
+	var personaID *int
+	if req.PersonaID != 0 {
+		if msg, status := checkPersona(db, req.PersonaID); msg != "" {
+			return nil, c.Status(status).JSON(fiber.Map{"error": msg})
+		}
+		personaID = &req.PersonaID
+	}
+
+	var conversation *models.Conversation
+	var err error
+	if req.ConversationID == 0 {
+		conversation, err = conversationService.Create(userID, "", personaID)
+		turn.newConversation = true
+	} else {
+		conversation, err = conversationService.Get(userID, req.ConversationID)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrConversationNotFound) {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "Conversation not found or access denied"})
+		}
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Failed to load conversation"})
+	}
+	turn.conversation = conversation
+
+	if personaID != nil && (conversation.PersonaID == nil || *conversation.PersonaID != *personaID) {
+		if _, err := conversationService.SetPersona(userID, conversation.ID, personaID); err != nil {
+			turn.abandon(db)
+			return nil, c.Status(500).JSON(fiber.Map{"error": "Failed to update conversation"})
+		}
+		conversation.PersonaID = personaID
+	}
+
+	turn.persona, err = services.NewChatPersonaService(db).ForConversation(conversation.PersonaID)
+	if err != nil {
+		turn.abandon(db)
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Failed to load persona"})
+	}
+
+	turn.prompt, err = services.NewChatMemoryService(db).Prompt(userID, conversation.ID, chatHistoryTokenBudget())
+	if err != nil {
+		turn.abandon(db)
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Failed to load conversation"})
+	}
+
+	return turn, nil
+}
+
+// saveChatTurn stores the message and its reply with the persona version
+// in use, the personal details redacted from the message, and the safety
+// intervention, if any, that produced the reply
+func saveChatTurn(db *sql.DB, turn *chatTurn, reply string, redactions map[string]int, intervention *services.SafetyIntervention) (*models.Message, error) {
+	conversationService := services.NewConversationService(db)
+	userMessage, assistantMessage, err := conversationService.AddTurn(turn.conversation.ID, turn.message, reply, turn.personaVersionID())
+	if err != nil {
+		return nil, err
+	}
+	conversationService.AddRedactions(turn.conversation.ID, redactions)
+
+	if intervention != nil {
+		// A crisis response flags the user's message; a replaced reply
+		// flags the model's, whose stored stand-in is the reply
+		source, messageID := services.MessageRoleAssistant, assistantMessage.ID
+		if intervention.Action == services.SafetyActionCrisisResponse {
+			source, messageID = services.MessageRoleUser, userMessage.ID
+		}
+		safetyEvents := services.NewSafetyEventService(db)
+		safetyEvents.Record(turn.userID, turn.conversation.ID, messageID, source, intervention)
+	}
+
+	return assistantMessage, nil
+}
+
+// ChatHandler replies to a message from the authenticated user. The message
+// continues conversation_id, or starts a new conversation when it is
+// omitted; earlier turns are sent along within CHAT_HISTORY_TOKEN_BUDGET.
+// Once they outgrow it the oldest are summarized after the reply, and the
+// summary is sent instead, along with the facts the user has approved for
+// the assistant to remember, all within the same budget.
+//
+// Messages suggesting the user is in danger are answered with crisis
+// resources for their locale without asking the model, and model replies
+// giving medical advice are withheld. Either is reported in "safety" and
+// recorded as a safety event. Personal details are replaced with
+// placeholders before the conversation is sent to the provider and put
+// back in the reply; "redactions" counts them by kind.
+//
+// The conversation's persona, or the default one, sets the system prompt,
+// temperature and reply length; persona_id switches to another persona.
+// Stored messages record the persona version in use.
+//
+// Requests to the provider count against the user's and the global chat
+// limits; over a limit the response is 429 with the time it resets. Crisis
+// responses are never limited.
+//
+// Users who opted in have a summary of their recent moods and related
+// journal entries added to the system prompt; "context" is exactly what
+// was sent.
+//
+// The model can call tools to summarize the user's moods, log a mood, draft
+// a journal entry or remember a fact. "tool_calls" lists the calls it made;
+// writes are pending until the user confirms them with POST
+// /api/chat/tool-calls/:id/confirm.
+func ChatHandler(db *sql.DB, chat ChatServices) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		turn, err := beginChatTurn(c, db)
+		if turn == nil {
+			return err
+		}
+
+		intervention, err := services.CheckChatMessage(c.Context(), chat.Classifier, turn.message, turn.locale)
+		if err != nil {
+			log.Printf("Safety check error: %v", err)
+			turn.abandon(db)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check message"})
+		}
+
+		var reply, providerName string
+		var redactions map[string]int
+		var redacting *services.RedactingChatProvider
+		var toolCalls []services.ChatToolResult
+		var usageID int64
+		tools := services.NewChatToolService(db)
+		if intervention != nil {
+			reply = intervention.Content
+		} else {
+			if usageID, err = reserveChatRequest(c, chat.Usage, turn); usageID == 0 {
+				turn.abandon(db)
+				return err
+			}
+
+			turn.loadChatContext(c.Context(), chat.Context)
+			request := turn.chatRequest()
+			redacting = chat.redactingProvider(turn.userID)
+			result, calls, err := tools.Run(c.Context(), redacting, request, turn.userID, turn.conversation.ID, nil)
+			chat.rememberRedactions(turn.userID, redacting)
+			recordChatUsage(chat.Usage, usageID, request, result)
+			toolCalls = calls
+			if err != nil {
+				log.Printf("AI request error: %v", err)
+				recordChatContext(chat.Context, turn, 0, redacting)
+				tools.FailPending(toolCalls)
+				turn.abandon(db)
+				status, message := chatErrorResponse(err)
+				return c.Status(status).JSON(fiber.Map{"error": message})
+			}
+			reply, providerName, redactions = result.Content, result.Provider, redacting.Counts()
+
+			intervention, err = services.CheckChatReply(c.Context(), chat.Classifier, reply)
+			if err != nil {
+				log.Printf("Safety check error: %v", err)
+				tools.FailPending(toolCalls)
+				turn.abandon(db)
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to check reply"})
+			}
+			if intervention != nil {
+				reply = intervention.Content
+			}
+		}
+
+		// The turn is only stored once there is a reply, so a failed request
+		// leaves no unanswered message behind
+		assistantMessage, err := saveChatTurn(db, turn, reply, redactions, intervention)
+		if err != nil {
+			recordChatContext(chat.Context, turn, 0, redacting)
+			tools.FailPending(toolCalls)
+			turn.abandon(db)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save conversation"})
+		}
+		sharedContext := recordChatContext(chat.Context, turn, assistantMessage.ID, redacting)
+		tools.AttachToMessage(toolCalls, assistantMessage.ID)
+		if usageID != 0 {
+			summarizeConversation(chat, turn.userID, turn.conversation.ID, usageID)
+		}
+
+		return c.JSON(fiber.Map{
+			"reply":           reply,
+			"conversation_id": turn.conversation.ID,
+			"message":         assistantMessage,
+			"provider":        providerName,
+			"safety":          intervention,
+			"redactions":      redactions,
+			"context":         sharedContext,
+			"tool_calls":      toolCalls,
+		})
+	}
+}
+
+// writeSSE writes one Server-Sent Event and flushes it to the client. An
+// error means the client has gone away.
+func writeSSE(w *bufio.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// errReplyWithheld stops a stream whose reply failed the safety check
+var errReplyWithheld = errors.New("reply withheld by safety check")
+
+// ChatStreamHandler is ChatHandler with the reply streamed as Server-Sent
+// Events: "start" with the conversation ID, a "delta" per chunk of text,
+// then "done" with the stored message ID and token usage, or "error". If
+// the client disconnects the upstream request is cancelled; whatever part
+// of the reply was received is still stored. An "error" without a
+// message_id means nothing was stored, and a conversation the message
+// started is removed again.
+//
+// A "safety" event replaces everything sent so far with its content: the
+// crisis response, sent without asking the model, or the stand-in for a
+// reply that failed the safety check. Each piece of the reply is checked
+// before it is sent. Any context shared from the user's moods and journals,
+// and any tools the model called, are reported in "done".
+func ChatStreamHandler(db *sql.DB, chat ChatServices) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		turn, err := beginChatTurn(c, db)
+		if turn == nil {
+			return err
+		}
+
+		crisis, err := services.CheckChatMessage(c.Context(), chat.Classifier, turn.message, turn.locale)
+		if err != nil {
+			log.Printf("Safety check error: %v", err)
+			turn.abandon(db)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check message"})
+		}
+
+		// Limits are checked before the stream starts so they can be
+		// reported with a 429
+		var usageID int64
+		if crisis == nil {
+			if usageID, err = reserveChatRequest(c, chat.Usage, turn); usageID == 0 {
+				turn.abandon(db)
+				return err
+			}
+			turn.loadChatContext(c.Context(), chat.Context)
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no") // stop nginx from buffering the stream
+
+		conversationID := turn.conversation.ID
+		request := turn.chatRequest()
+
+		// The writer runs after the handler returns, so it must not use c
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			ctx, cancel := context.WithTimeout(context.Background(), chatStreamTimeout)
+			defer cancel()
+
+			if err := writeSSE(w, "start", fiber.Map{"conversation_id": conversationID}); err != nil {
+				return
+			}
+
+			if crisis != nil {
+				assistantMessage, saveErr := saveChatTurn(db, turn, crisis.Content, nil, crisis)
+				if saveErr != nil {
+					turn.abandon(db)
+				}
+				if err := writeSSE(w, "safety", crisis); err != nil {
+					return
+				}
+				if saveErr != nil {
+					writeSSE(w, "error", fiber.Map{"error": "Failed to save conversation"})
+					return
+				}
+				writeSSE(w, "done", fiber.Map{
+					"conversation_id": conversationID,
+					"message_id":      assistantMessage.ID,
+				})
+				return
+			}
+
+			var sent strings.Builder
+			var withheld *services.SafetyIntervention
+			disconnected := false
+			redacting := chat.redactingProvider(turn.userID)
+			tools := services.NewChatToolService(db)
+			result, toolCalls, streamErr := tools.Run(ctx, redacting, request, turn.userID, conversationID, func(delta string) error {
+				intervention, err := services.CheckChatReply(ctx, chat.Classifier, sent.String()+delta)
+				if err != nil {
+					return err
+				}
+				if intervention != nil {
+					withheld = intervention
+					return errReplyWithheld
+				}
+
+				sent.WriteString(delta)
+				if err := writeSSE(w, "delta", fiber.Map{"content": delta}); err != nil {
+					disconnected = true
+					return err
+				}
+				return nil
+			})
+			if withheld != nil {
+				streamErr = nil
+			}
+			if streamErr != nil && !disconnected {
+				log.Printf("AI stream error: %v", streamErr)
+			}
+			chat.rememberRedactions(turn.userID, redacting)
+
+			// A stopped stream may not report usage, which is then
+			// estimated from the text received
+			if result == nil && sent.Len() > 0 {
+				result = &services.ChatReply{Content: sent.String(), Provider: chat.Provider.Name()}
+			}
+			recordChatUsage(chat.Usage, usageID, request, result)
+
+			reply := ""
+			if withheld != nil {
+				reply = withheld.Content
+			} else if result != nil {
+				reply = result.Content
+			}
+
+			var messageID int
+			if reply != "" {
+				if assistantMessage, err := saveChatTurn(db, turn, reply, redacting.Counts(), withheld); err == nil {
+					messageID = assistantMessage.ID
+				}
+			}
+			sharedContext := recordChatContext(chat.Context, turn, messageID, redacting)
+			// The client is only sent the tool calls with a finished reply
+			if streamErr != nil || messageID == 0 {
+				tools.FailPending(toolCalls)
+			}
+			if messageID != 0 {
+				tools.AttachToMessage(toolCalls, messageID)
+				summarizeConversation(chat, turn.userID, conversationID, usageID)
+			} else {
+				turn.abandon(db)
+			}
+
+			if disconnected {
+				return
+			}
+			if withheld != nil {
+				if err := writeSSE(w, "safety", withheld); err != nil {
+					return
+				}
+			}
+			if streamErr != nil {
+				_, message := chatErrorResponse(streamErr)
+				writeSSE(w, "error", fiber.Map{"error": message, "message_id": messageID})
+				return
+			}
+			if messageID == 0 {
+				writeSSE(w, "error", fiber.Map{"error": "Failed to save conversation"})
+				return
+			}
+
+			done := fiber.Map{
+				"conversation_id": conversationID,
+				"message_id":      messageID,
+				"redactions":      redacting.Counts(),
+				"context":         sharedContext,
+				"tool_calls":      toolCalls,
+			}
+			if result != nil {
+				done["usage"] = result.Usage
+				done["provider"] = result.Provider
+			}
+			writeSSE(w, "done", done)
+		})
+
+		return nil
+	}
+}
+
+// GetChatUsage returns the authenticated user's chat limits, how much of
+// them is used, and their daily usage over the last 30 days
+func GetChatUsage(usage *services.ChatUsageService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		summary, err := usage.Summary(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch chat usage"})
+		}
+
+		return c.JSON(summary)
+	}
+}
+
+// ResolveChatToolCall confirms or rejects a write the chat model proposed,
+// such as logging a mood. A confirmed write is saved; either way the outcome
+// is added to the conversation so the model knows of it.
+func ResolveChatToolCall(db *sql.DB, confirm bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		toolCallID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid tool call ID"})
+		}
+
+		toolCall, err := services.NewChatToolService(db).Resolve(userID, toolCallID, confirm)
+		if err != nil {
+			if errors.Is(err, services.ErrToolCallNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Tool call not found or access denied"})
+			}
+			if errors.Is(err, services.ErrToolCallResolved) {
+				return c.Status(409).JSON(fiber.Map{"error": "Tool call has already been confirmed or rejected"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save"})
+		}
+
+		message, err := services.NewConversationService(db).AddMessage(toolCall.ConversationID, toolCall.Outcome())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to save conversation"})
+		}
+
+		return c.JSON(fiber.Map{
+			"tool_call": toolCall,
+			"message":   message,
+		})
+	}
 }
\ No newline at end of file
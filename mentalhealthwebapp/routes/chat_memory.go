@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// ListChatMemories returns what the assistant remembers about the
+// authenticated user, newest first
+func ListChatMemories(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		memories, err := services.NewChatMemoryService(db).List(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch memories"})
+		}
+
+		return c.JSON(memories)
+	}
+}
+
+// DeleteChatMemory makes the assistant forget one of the user's memories
+func DeleteChatMemory(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		memoryID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid memory ID"})
+		}
+
+		deleted, err := services.NewChatMemoryService(db).Delete(userID, memoryID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete memory"})
+		}
+		if !deleted {
+			return c.Status(404).JSON(fiber.Map{"error": "Memory not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Memory deleted successfully",
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// ListChatPersonas returns the personas users can pick for a conversation,
+// without their settings
+func ListChatPersonas(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		personas, err := services.NewChatPersonaService(db).List(true)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch personas"})
+		}
+
+		for i := range personas {
+			personas[i].Version = nil
+		}
+		return c.JSON(personas)
+	}
+}
+
+// AdminListChatPersonas returns every persona, including inactive ones,
+// with its current settings
+func AdminListChatPersonas(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		personas, err := services.NewChatPersonaService(db).List(false)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch personas"})
+		}
+
+		return c.JSON(personas)
+	}
+}
+
+// trimPersonaInput trims the text fields of a persona
+func trimPersonaInput(input *services.ChatPersonaInput) {
+	input.Slug = strings.TrimSpace(input.Slug)
+	input.Name = strings.TrimSpace(input.Name)
+	input.Description = strings.TrimSpace(input.Description)
+	input.SystemPrompt = strings.TrimSpace(input.SystemPrompt)
+}
+
+// CreateChatPersona adds a persona with its first version. It is active
+// unless "active" is false.
+func CreateChatPersona(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		adminID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		input := services.ChatPersonaInput{Active: true}
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+		trimPersonaInput(&input)
+		if msg := services.ValidatePersona(input); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		persona, err := services.NewChatPersonaService(db).Create(input, adminID)
+		if err != nil {
+			if errors.Is(err, services.ErrPersonaSlugTaken) {
+				return c.Status(409).JSON(fiber.Map{"error": "A persona with this slug already exists"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create persona"})
+		}
+
+		log.Printf("Admin %d created chat persona %s", adminID, persona.Slug)
+
+		return c.Status(201).JSON(persona)
+	}
+}
+
+// UpdateChatPersona changes the fields given of a persona. Changing
+// system_prompt, temperature or max_tokens adds a version; conversations
+// use it from their next message.
+func UpdateChatPersona(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		adminID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		personaID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid persona ID"})
+		}
+
+		type Request struct {
+			Name         *string  `json:"name"`
+			Description  *string  `json:"description"`
+			Active       *bool    `json:"active"`
+			IsDefault    *bool    `json:"default"`
+			SystemPrompt *string  `json:"system_prompt"`
+			Temperature  *float32 `json:"temperature"`
+			MaxTokens    *int     `json:"max_tokens"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		personaService := services.NewChatPersonaService(db)
+		persona, err := personaService.Get(personaID)
+		if err != nil {
+			if errors.Is(err, services.ErrPersonaNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Persona not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch persona"})
+		}
+
+		input := persona.Input()
+		if req.Name != nil {
+			input.Name = *req.Name
+		}
+		if req.Description != nil {
+			input.Description = *req.Description
+		}
+		if req.Active != nil {
+			input.Active = *req.Active
+		}
+		if req.IsDefault != nil {
+			input.IsDefault = *req.IsDefault
+		}
+		if req.SystemPrompt != nil {
+			input.SystemPrompt = *req.SystemPrompt
+		}
+		if req.Temperature != nil {
+			input.Temperature = *req.Temperature
+		}
+		if req.MaxTokens != nil {
+			input.MaxTokens = *req.MaxTokens
+		}
+		trimPersonaInput(&input)
+		if msg := services.ValidatePersona(input); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		persona, err = personaService.Update(personaID, input, adminID)
+		if err != nil {
+			if errors.Is(err, services.ErrPersonaNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Persona not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update persona"})
+		}
+
+		log.Printf("Admin %d updated chat persona %s (version %d)", adminID, persona.Slug, persona.Version.Version)
+
+		return c.JSON(persona)
+	}
+}
+
+// ListChatPersonaVersions returns every version of a persona, newest first
+func ListChatPersonaVersions(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		personaID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid persona ID"})
+		}
+
+		personaService := services.NewChatPersonaService(db)
+		if _, err := personaService.Get(personaID); err != nil {
+			if errors.Is(err, services.ErrPersonaNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Persona not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch persona"})
+		}
+
+		versions, err := personaService.Versions(personaID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch persona versions"})
+		}
+
+		return c.JSON(versions)
+	}
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// TestWriteSSE verifies events are framed as Server-Sent Events
+func TestWriteSSE(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	if err := writeSSE(w, "delta", map[string]string{"content": "Hi\nthere"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "event: delta\ndata: {\"content\":\"Hi\\nthere\"}\n\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+// TestChatCompletionMessages verifies the prompt order
+func TestChatCompletionMessages(t *testing.T) {
+	prompt := &services.ChatPrompt{History: []models.Message{
+		{Role: "user", Content: "I feel low"},
+		{Role: "assistant", Content: "I'm sorry to hear that."},
+	}}
+
+	messages := chatCompletionMessages("Be kind.", prompt, "Still low today", "")
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages, got %d", len(messages))
+	}
+	if messages[0].Content != "Be kind." || messages[1].Content != "I feel low" || messages[3].Role != "user" || messages[3].Content != "Still low today" {
+		t.Errorf("Unexpected prompt %+v", messages)
+	}
+
+	// Shared context follows the system prompt
+	messages = chatCompletionMessages("Be kind.", &services.ChatPrompt{}, "Still low today", "Recent moods: sad")
+	if messages[0].Content != "Be kind.\n\nRecent moods: sad" {
+		t.Errorf("Expected shared context in the system prompt, got %q", messages[0].Content)
+	}
+
+	// Memories and the summary come before it
+	prompt.Summary = "They lost their job."
+	messages = chatCompletionMessages("Be kind.", prompt, "Still low today", "Recent moods: sad")
+	if want := "Be kind.\n\n" + prompt.SystemContext() + "\n\nRecent moods: sad"; messages[0].Content != want {
+		t.Errorf("Expected %q, got %q", want, messages[0].Content)
+	}
+}
+
+// TestChatRequestPersona verifies a persona sets the prompt, temperature
+// and reply length, within CHAT_MAX_TOKENS, and keeps the safety limits
+func TestChatRequestPersona(t *testing.T) {
+	t.Setenv("CHAT_MAX_TOKENS", "500")
+	turn := &chatTurn{message: "Hi", prompt: &services.ChatPrompt{}}
+
+	req := turn.chatRequest()
+	if req.Messages[0].Content != chatSystemPrompt+" "+chatSafetyPrompt || req.Temperature != 0 || req.MaxTokens != 500 || turn.personaVersionID() != nil {
+		t.Errorf("Unexpected request without a persona %+v", req)
+	}
+
+	turn.persona = &services.ChatPersona{Version: &services.ChatPersonaVersion{ID: 7, SystemPrompt: "You are a CBT coach.", Temperature: 0.5, MaxTokens: 300}}
+	req = turn.chatRequest()
+	if req.Messages[0].Content != "You are a CBT coach.\n\n"+chatSafetyPrompt || req.Temperature != 0.5 || req.MaxTokens != 300 {
+		t.Errorf("Unexpected request with a persona %+v", req)
+	}
+	if id := turn.personaVersionID(); id == nil || *id != 7 {
+		t.Errorf("Expected persona version 7, got %v", id)
+	}
+
+	turn.persona.Version.MaxTokens = 2000
+	if req = turn.chatRequest(); req.MaxTokens != 500 {
+		t.Errorf("Expected CHAT_MAX_TOKENS to cap the persona, got %d", req.MaxTokens)
+	}
+}
+
+// TestChatErrorResponse verifies timeouts are told apart from outages
+func TestChatErrorResponse(t *testing.T) {
+	if status, _ := chatErrorResponse(fmt.Errorf("request: %w", context.DeadlineExceeded)); status != 504 {
+		t.Errorf("Expected 504 for a timeout, got %d", status)
+	}
+	if status, _ := chatErrorResponse(errors.New("connection refused")); status != 503 {
+		t.Errorf("Expected 503 for an outage, got %d", status)
+	}
+}
+
+// TestChatQuotaExceeded verifies quota errors become 429s with a reset time
+func TestChatQuotaExceeded(t *testing.T) {
+	resetAt := time.Now().Add(90 * time.Second)
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return chatQuotaExceeded(c, &services.ChatQuotaError{Scope: "user", Limit: services.ChatLimitRequestsPerMinute, Max: 10, ResetAt: resetAt})
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 429 {
+		t.Errorf("Expected 429, got %d", resp.StatusCode)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "90" && retryAfter != "89" {
+		t.Errorf("Expected Retry-After of 90 seconds, got %q", retryAfter)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["limit"] != services.ChatLimitRequestsPerMinute || body["scope"] != "user" || body["reset_at"] == nil {
+		t.Errorf("Unexpected body %v", body)
+	}
+}
@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// CreateConversation starts an empty conversation with an optional title
+// and persona
+func CreateConversation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Title     string `json:"title"`
+			PersonaID *int   `json:"persona_id"`
+		}
+
+		var req Request
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+			}
+		}
+
+		req.Title = strings.TrimSpace(req.Title)
+		if len(req.Title) > 200 {
+			return c.Status(400).JSON(fiber.Map{"error": "Title must be 200 characters or less"})
+		}
+
+		if req.PersonaID != nil {
+			if msg, status := checkPersona(db, *req.PersonaID); msg != "" {
+				return c.Status(status).JSON(fiber.Map{"error": msg})
+			}
+		}
+
+		conversation, err := services.NewConversationService(db).Create(userID, req.Title, req.PersonaID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create conversation"})
+		}
+
+		return c.Status(201).JSON(conversation)
+	}
+}
+
+// ListConversations returns the authenticated user's conversations
+func ListConversations(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		conversations, err := services.NewConversationService(db).ListForUser(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversations"})
+		}
+
+		return c.JSON(conversations)
+	}
+}
+
+// GetConversationMessages returns the latest messages of a conversation in
+// chronological order, with counts of the personal details redacted from
+// it, the tools the model called and the summary of its older turns, if
+// any. Supports limit and before, a message ID to page back from.
+func GetConversationMessages(db *sql.DB, summaries *services.ChatSummaryService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		conversationID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid conversation ID"})
+		}
+
+		limit := c.QueryInt("limit", 50)
+		if limit < 1 || limit > 200 {
+			return c.Status(400).JSON(fiber.Map{"error": "limit must be between 1 and 200"})
+		}
+		before := c.QueryInt("before", 0)
+		if before < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid before message ID"})
+		}
+
+		conversationService := services.NewConversationService(db)
+		conversation, err := conversationService.Get(userID, conversationID)
+		if err != nil {
+			if errors.Is(err, services.ErrConversationNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Conversation not found or access denied"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversation"})
+		}
+
+		messages, err := conversationService.Messages(conversation.ID, before, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch messages"})
+		}
+
+		redactions, err := conversationService.Redactions(conversation.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversation"})
+		}
+
+		toolCalls, err := services.NewChatToolService(db).ListForConversation(conversation.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversation"})
+		}
+
+		summary, err := summaries.Get(conversation.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversation"})
+		}
+
+		return c.JSON(fiber.Map{
+			"conversation": conversation,
+			"messages":     messages,
+			"redactions":   redactions,
+			"tool_calls":   toolCalls,
+			"summary":      summary,
+		})
+	}
+}
+
+// GetConversationContext returns what was shared from the user's moods and
+// journals with each reply of a conversation, as the provider received it
+func GetConversationContext(db *sql.DB, contexts *services.ChatContextService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		conversationID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid conversation ID"})
+		}
+
+		conversation, err := services.NewConversationService(db).Get(userID, conversationID)
+		if err != nil {
+			if errors.Is(err, services.ErrConversationNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "Conversation not found or access denied"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch conversation"})
+		}
+
+		shares, err := contexts.ListForConversation(conversation.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch shared context"})
+		}
+
+		return c.JSON(shares)
+	}
+}
+
+// checkPersona checks that users can pick a persona, returning an error
+// message and status or ""
+func checkPersona(db *sql.DB, personaID int) (string, int) {
+	if _, err := services.NewChatPersonaService(db).GetActive(personaID); err != nil {
+		if errors.Is(err, services.ErrPersonaNotFound) {
+			return "Persona not found", 400
+		}
+		return "Failed to load persona", 500
+	}
+	return "", 0
+}
+
+// SetConversationPersona switches a conversation to persona_id, or to the
+// default persona when it is null
+func SetConversationPersona(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		conversationID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid conversation ID"})
+		}
+
+		type Request struct {
+			PersonaID *int `json:"persona_id"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if req.PersonaID != nil {
+			if msg, status := checkPersona(db, *req.PersonaID); msg != "" {
+				return c.Status(status).JSON(fiber.Map{"error": msg})
+			}
+		}
+
+		updated, err := services.NewConversationService(db).SetPersona(userID, conversationID, req.PersonaID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update conversation"})
+		}
+		if !updated {
+			return c.Status(404).JSON(fiber.Map{"error": "Conversation not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":    "Conversation persona updated successfully",
+			"persona_id": req.PersonaID,
+		})
+	}
+}
+
+// DeleteConversation removes a conversation and its messages
+func DeleteConversation(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		conversationID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid conversation ID"})
+		}
+
+		deleted, err := services.NewConversationService(db).Delete(userID, conversationID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete conversation"})
+		}
+		if !deleted {
+			return c.Status(404).JSON(fiber.Map{"error": "Conversation not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Conversation deleted successfully",
+		})
+	}
+}
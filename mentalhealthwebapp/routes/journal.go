@@ -4,11 +4,16 @@ import (
 	"database/sql"
 	"log"
 	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/services"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// GetJournals returns a page of the authenticated user's journals. Supports
+// limit, cursor, order (asc|desc), from/to date filters and q, a
+// case-insensitive substring matched against the title and body.
 func GetJournals(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from JWT context
@@ -17,29 +22,63 @@ func GetJournals(db *sql.DB) fiber.Handler {
 			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
 		}
 
+		params, err := parseListParams(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
 		// Query only journals for the authenticated user
-		query := "SELECT id, user_id, title, body, created_at FROM journals WHERE user_id = $1 ORDER BY created_at DESC"
-		rows, err := db.Query(query, userID)
+		q := &listQuery{}
+		q.where("user_id = " + q.arg(userID))
+		q.applyDateRange(params)
+
+		if search := strings.TrimSpace(c.Query("q")); search != "" {
+			if len(search) > 100 {
+				return c.Status(400).JSON(fiber.Map{"error": "Search query must be 100 characters or less"})
+			}
+			pattern := q.arg(likePattern(search))
+			q.where("(title ILIKE " + pattern + " OR body ILIKE " + pattern + ")")
+		}
+
+		var total int
+		countQuery := "SELECT COUNT(*) FROM journals " + q.whereClause()
+		if err := db.QueryRow(countQuery, q.args...).Scan(&total); err != nil {
+			log.Printf("DB error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch journals"})
+		}
+
+		query := "SELECT id, user_id, title, body, created_at, updated_at FROM journals " + q.pageClause(params)
+		rows, err := db.Query(query, q.args...)
 		if err != nil {
 			log.Printf("DB error: %v", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch journals"})
 		}
 		defer rows.Close()
 
-		var journals []models.Journal
+		journals := []models.Journal{}
 		for rows.Next() {
 			var j models.Journal
-			if err := rows.Scan(&j.ID, &j.UserID, &j.Title, &j.Body, &j.CreatedAt); err != nil {
+			if err := rows.Scan(&j.ID, &j.UserID, &j.Title, &j.Body, &j.CreatedAt, &j.UpdatedAt); err != nil {
 				log.Printf("Scan error: %v", err)
 				return c.Status(500).JSON(fiber.Map{"error": "Failed to parse journal"})
 			}
 			journals = append(journals, j)
 		}
 
-		if len(journals) == 0 {
-			return c.JSON([]models.Journal{})
+		next := nextCursor(params, len(journals), func() (time.Time, int) {
+			last := journals[params.Limit-1]
+			return last.CreatedAt, last.ID
+		})
+		if len(journals) > params.Limit {
+			journals = journals[:params.Limit]
 		}
-		return c.JSON(journals)
+
+		return c.JSON(fiber.Map{
+			"data":        journals,
+			"next_cursor": next,
+			"total":       total,
+			"limit":       params.Limit,
+		})
 	}
 }
 
@@ -62,25 +101,19 @@ func CreateJournal(db *sql.DB) fiber.Handler {
 		}
 
 		// Validate input lengths
-		if len(req.Title) > 100 {
-			return c.Status(400).JSON(fiber.Map{"error": "Title must be 100 characters or less"})
-		}
-		if len(req.Body) > 5000 {
-			return c.Status(400).JSON(fiber.Map{"error": "Body must be 5000 characters or less"})
+		if msg := services.ValidateJournal(req.Title, req.Body); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
 		}
 
-		query := `INSERT INTO journals (user_id, title, body, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
-		var id int
-		err := db.QueryRow(query, userID, req.Title, req.Body, time.Now()).Scan(&id)
+		journal, err := services.NewJournalService(db).Create(userID, req.Title, req.Body)
 		if err != nil {
-			log.Printf("Insert error: %v", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to save journal"})
 		}
 
 		return c.Status(201).JSON(fiber.Map{
 			"message": "Journal entry created",
-			"id":      id,
-			"title":   req.Title,
+			"id":      journal.ID,
+			"title":   journal.Title,
 		})
 	}
 }
@@ -95,9 +128,9 @@ func UpdateJournal(db *sql.DB) fiber.Handler {
 		}
 
 		// Get journal ID from URL parameter
-		journalID := c.Params("id")
-		if journalID == "" {
-			return c.Status(400).JSON(fiber.Map{"error": "Journal ID is required"})
+		journalID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid journal ID"})
 		}
 
 		type Request struct {
@@ -111,11 +144,8 @@ func UpdateJournal(db *sql.DB) fiber.Handler {
 		}
 
 		// Validate input lengths
-		if len(req.Title) > 100 {
-			return c.Status(400).JSON(fiber.Map{"error": "Title must be 100 characters or less"})
-		}
-		if len(req.Body) > 5000 {
-			return c.Status(400).JSON(fiber.Map{"error": "Body must be 5000 characters or less"})
+		if msg := services.ValidateJournal(req.Title, req.Body); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
 		}
 
 		// Update only if the journal belongs to the authenticated user
@@ -143,6 +173,100 @@ func UpdateJournal(db *sql.DB) fiber.Handler {
 	}
 }
 
+// GetJournal returns a single journal entry owned by the authenticated user
+func GetJournal(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		// Get journal ID from URL parameter
+		journalID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid journal ID"})
+		}
+
+		var j models.Journal
+		query := `SELECT id, user_id, title, body, created_at, updated_at FROM journals WHERE id = $1 AND user_id = $2`
+		err = db.QueryRow(query, journalID, userID).Scan(&j.ID, &j.UserID, &j.Title, &j.Body, &j.CreatedAt, &j.UpdatedAt)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Journal not found or access denied"})
+		}
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch journal"})
+		}
+
+		return c.JSON(j)
+	}
+}
+
+// PatchJournal updates only the fields present in the request body
+func PatchJournal(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		// Get journal ID from URL parameter
+		journalID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid journal ID"})
+		}
+
+		type Request struct {
+			Title *string `json:"title"`
+			Body  *string `json:"body"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if req.Title == nil && req.Body == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "No fields to update"})
+		}
+
+		// Validate only the fields being changed
+		title, body := "", ""
+		if req.Title != nil {
+			title = *req.Title
+		}
+		if req.Body != nil {
+			body = *req.Body
+		}
+		if msg := services.ValidateJournal(title, body); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		// Update only if the journal belongs to the authenticated user
+		var j models.Journal
+		query := `
+			UPDATE journals SET title = COALESCE($1, title), body = COALESCE($2, body)
+			WHERE id = $3 AND user_id = $4
+			RETURNING id, user_id, title, body, created_at, updated_at
+		`
+		err = db.QueryRow(query, req.Title, req.Body, journalID, userID).Scan(&j.ID, &j.UserID, &j.Title, &j.Body, &j.CreatedAt, &j.UpdatedAt)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Journal not found or access denied"})
+		}
+		if err != nil {
+			log.Printf("Update error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update journal"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Journal updated successfully",
+			"journal": j,
+		})
+	}
+}
+
 // DeleteJournal deletes a journal entry for the authenticated user
 func DeleteJournal(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -153,9 +277,9 @@ func DeleteJournal(db *sql.DB) fiber.Handler {
 		}
 
 		// Get journal ID from URL parameter
-		journalID := c.Params("id")
-		if journalID == "" {
-			return c.Status(400).JSON(fiber.Map{"error": "Journal ID is required"})
+		journalID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid journal ID"})
 		}
 
 		// Delete only if the journal belongs to the authenticated user
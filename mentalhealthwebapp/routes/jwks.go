@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/utils"
+)
+
+// JWKS serves the public keys tokens are signed with, including keys that
+// are being rotated out, so other services can verify tokens themselves
+func JWKS(keys *utils.KeySet) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(keys.JWKS())
+	}
+}
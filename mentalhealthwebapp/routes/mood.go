@@ -4,11 +4,17 @@ import (
 	"database/sql"
 	"log"
 	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/services"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
 )
 
+// GetMoods returns a page of the authenticated user's moods. Supports
+// limit, cursor, order (asc|desc), from/to date filters and a comma
+// separated mood filter.
 func GetMoods(db *sql.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get user ID from JWT context
@@ -17,29 +23,65 @@ func GetMoods(db *sql.DB) fiber.Handler {
 			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
 		}
 
+		params, err := parseListParams(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
 		// Query only moods for the authenticated user
-		query := "SELECT id, user_id, mood, note, created_at FROM moods WHERE user_id = $1 ORDER BY created_at DESC"
-		rows, err := db.Query(query, userID)
+		q := &listQuery{}
+		q.where("user_id = " + q.arg(userID))
+		q.applyDateRange(params)
+
+		if moodFilter := c.Query("mood"); moodFilter != "" {
+			moods := strings.Split(strings.ToLower(moodFilter), ",")
+			for _, mood := range moods {
+				if !services.ValidMoods[mood] {
+					return c.Status(400).JSON(fiber.Map{"error": "Invalid mood filter: " + mood})
+				}
+			}
+			q.where("mood = ANY(" + q.arg(pq.Array(moods)) + ")")
+		}
+
+		var total int
+		countQuery := "SELECT COUNT(*) FROM moods " + q.whereClause()
+		if err := db.QueryRow(countQuery, q.args...).Scan(&total); err != nil {
+			log.Printf("DB error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch moods"})
+		}
+
+		query := "SELECT id, user_id, mood, note, created_at, updated_at FROM moods " + q.pageClause(params)
+		rows, err := db.Query(query, q.args...)
 		if err != nil {
 			log.Printf("DB error: %v", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch moods"})
 		}
 		defer rows.Close()
 
-		var moods []models.Mood
+		moods := []models.Mood{}
 		for rows.Next() {
 			var m models.Mood
-			if err := rows.Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt); err != nil {
+			if err := rows.Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt, &m.UpdatedAt); err != nil {
 				log.Printf("Scan error: %v", err)
 				return c.Status(500).JSON(fiber.Map{"error": "Failed to parse mood"})
 			}
 			moods = append(moods, m)
 		}
 
-		if len(moods) == 0 {
-			return c.JSON([]models.Mood{})
+		next := nextCursor(params, len(moods), func() (time.Time, int) {
+			last := moods[params.Limit-1]
+			return last.CreatedAt, last.ID
+		})
+		if len(moods) > params.Limit {
+			moods = moods[:params.Limit]
 		}
-		return c.JSON(moods)
+
+		return c.JSON(fiber.Map{
+			"data":        moods,
+			"next_cursor": next,
+			"total":       total,
+			"limit":       params.Limit,
+		})
 	}
 }
 
@@ -63,37 +105,187 @@ func CreateMood(db *sql.DB) fiber.Handler {
 		}
 
 		// Validate mood values
-		validMoods := map[string]bool{
-			"happy":   true,
-			"sad":     true,
-			"anxious": true,
-			"calm":    true,
-			"angry":   true,
-			"excited": true,
-			"tired":   true,
-			"neutral": true,
-		}
-
-		if !validMoods[req.Mood] {
-			return c.Status(400).JSON(fiber.Map{
-				"error": "Invalid mood. Valid options: happy, sad, anxious, calm, angry, excited, tired, neutral",
-			})
-		}
-
-		// Insert mood with parameterized query
-		query := `INSERT INTO moods (user_id, mood, note, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
-		var id int
-		err := db.QueryRow(query, userID, req.Mood, req.Note, time.Now()).Scan(&id)
+		if msg := services.ValidateMood(req.Mood, req.Note); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		mood, err := services.NewMoodService(db).Create(userID, req.Mood, req.Note)
 		if err != nil {
-			log.Printf("Insert error: %v", err)
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to save mood"})
 		}
 
 		return c.Status(201).JSON(fiber.Map{
 			"message": "Mood logged successfully",
-			"id":      id,
-			"mood":    req.Mood,
-			"note":    req.Note,
+			"id":      mood.ID,
+			"mood":    mood.Mood,
+			"note":    mood.Note,
+		})
+	}
+}
+
+// GetMood returns a single mood entry owned by the authenticated user
+func GetMood(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		moodID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid mood ID"})
+		}
+
+		var m models.Mood
+		query := `SELECT id, user_id, mood, note, created_at, updated_at FROM moods WHERE id = $1 AND user_id = $2`
+		err = db.QueryRow(query, moodID, userID).Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt, &m.UpdatedAt)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "Mood not found or access denied"})
+		}
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch mood"})
+		}
+
+		return c.JSON(m)
+	}
+}
+
+// UpdateMood replaces the mood and note of an entry owned by the authenticated user
+func UpdateMood(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		moodID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid mood ID"})
+		}
+
+		type Request struct {
+			Mood string `json:"mood" validate:"required,max=50"`
+			Note string `json:"note" validate:"max=500"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if msg := services.ValidateMood(req.Mood, req.Note); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		return saveMood(c, db, moodID, userID, &req.Mood, &req.Note)
+	}
+}
+
+// PatchMood updates only the fields present in the request body
+func PatchMood(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		moodID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid mood ID"})
+		}
+
+		type Request struct {
+			Mood *string `json:"mood"`
+			Note *string `json:"note"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if req.Mood == nil && req.Note == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "No fields to update"})
+		}
+
+		// Validate only the fields being changed
+		mood, note := "neutral", ""
+		if req.Mood != nil {
+			mood = *req.Mood
+		}
+		if req.Note != nil {
+			note = *req.Note
+		}
+		if msg := services.ValidateMood(mood, note); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+
+		return saveMood(c, db, moodID, userID, req.Mood, req.Note)
+	}
+}
+
+// saveMood updates the non-nil fields of a mood owned by userID and
+// responds with the updated entry
+func saveMood(c *fiber.Ctx, db *sql.DB, moodID, userID int, mood, note *string) error {
+	// Update only if the mood belongs to the authenticated user
+	var m models.Mood
+	query := `
+		UPDATE moods SET mood = COALESCE($1, mood), note = COALESCE($2, note)
+		WHERE id = $3 AND user_id = $4
+		RETURNING id, user_id, mood, note, created_at, updated_at
+	`
+	err := db.QueryRow(query, mood, note, moodID, userID).Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "Mood not found or access denied"})
+	}
+	if err != nil {
+		log.Printf("Update error: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update mood"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Mood updated successfully",
+		"mood":    m,
+	})
+}
+
+// DeleteMood deletes a mood entry for the authenticated user
+func DeleteMood(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		moodID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid mood ID"})
+		}
+
+		// Delete only if the mood belongs to the authenticated user
+		result, err := db.Exec(`DELETE FROM moods WHERE id = $1 AND user_id = $2`, moodID, userID)
+		if err != nil {
+			log.Printf("Delete error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to delete mood"})
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			log.Printf("RowsAffected error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to verify deletion"})
+		}
+
+		if rowsAffected == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "Mood not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Mood deleted successfully",
 		})
 	}
 }
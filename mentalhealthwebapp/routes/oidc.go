@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+	"github.com/leketech/mental-health-app/utils"
+)
+
+// oidcLoginCodeTTL is how long the frontend has to exchange the one-time
+// code it receives after a social login
+const oidcLoginCodeTTL = 2 * time.Minute
+
+// oidcStateCookie ties an authorization request to the browser that
+// started it. Without it, anyone could start a login or link and have a
+// victim finish it: signing the victim into the attacker's account, or
+// linking the victim's identity to it.
+const oidcStateCookie = "oidc_state"
+
+// setOIDCStateCookie stores the hash of state in the browser until the
+// callback
+func setOIDCStateCookie(c *fiber.Ctx, state string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    utils.HashToken(state),
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(services.OIDCStateTTL.Seconds()),
+		Secure:   strings.HasPrefix(os.Getenv("API_BASE_URL"), "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// checkOIDCStateCookie reports whether the callback's state was issued to
+// this browser, and clears the cookie
+func checkOIDCStateCookie(c *fiber.Ctx) bool {
+	stored := c.Cookies(oidcStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/auth/oidc",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	state := c.Query("state")
+	return stored != "" && state != "" &&
+		subtle.ConstantTimeCompare([]byte(stored), []byte(utils.HashToken(state))) == 1
+}
+
+// oidcErrorRedirect sends the browser back to the frontend with an error code
+func oidcErrorRedirect(c *fiber.Ctx, path, code string) error {
+	return c.Redirect(appLink(path+"?oidc_error="+url.QueryEscape(code), ""))
+}
+
+// ListOIDCProviders returns the names of the configured social login providers
+func ListOIDCProviders(registry *services.OIDCRegistry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"providers": registry.Names(),
+		})
+	}
+}
+
+// StartOIDCLogin redirects the browser to the provider's login page using
+// the authorization code flow with PKCE
+func StartOIDCLogin(db *sql.DB, registry *services.OIDCRegistry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		providerName := c.Params("provider")
+		provider, err := registry.Provider(providerName)
+		if err != nil {
+			if errors.Is(err, services.ErrUnknownOIDCProvider) {
+				return c.Status(404).JSON(fiber.Map{"error": "Unknown login provider"})
+			}
+			log.Printf("OIDC discovery error: %v", err)
+			return c.Status(502).JSON(fiber.Map{"error": "Login provider is unavailable"})
+		}
+
+		identityService := services.NewIdentityService(db)
+		state, nonce, verifier, err := identityService.CreateState(providerName, services.OIDCPurposeLogin, 0)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to start login"})
+		}
+
+		setOIDCStateCookie(c, state)
+		return c.Redirect(provider.AuthCodeURL(state, nonce, verifier))
+	}
+}
+
+// StartOIDCLink returns the provider URL that links an identity to the
+// authenticated user. It is returned rather than redirected to because the
+// request carries the access token in a header; it must be sent with
+// credentials so the browser keeps the state cookie.
+func StartOIDCLink(db *sql.DB, registry *services.OIDCRegistry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		providerName := c.Params("provider")
+		provider, err := registry.Provider(providerName)
+		if err != nil {
+			if errors.Is(err, services.ErrUnknownOIDCProvider) {
+				return c.Status(404).JSON(fiber.Map{"error": "Unknown login provider"})
+			}
+			log.Printf("OIDC discovery error: %v", err)
+			return c.Status(502).JSON(fiber.Map{"error": "Login provider is unavailable"})
+		}
+
+		identityService := services.NewIdentityService(db)
+		state, nonce, verifier, err := identityService.CreateState(providerName, services.OIDCPurposeLink, userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to start linking"})
+		}
+
+		setOIDCStateCookie(c, state)
+
+		return c.JSON(fiber.Map{
+			"authorization_url": provider.AuthCodeURL(state, nonce, verifier),
+		})
+	}
+}
+
+// OIDCCallback handles the provider's redirect. Logins send the browser to
+// the frontend with a one-time code to exchange at /api/auth/oidc/exchange,
+// so tokens never appear in a URL; links return to the profile page. The
+// browser must be the one that started the request.
+func OIDCCallback(db *sql.DB, registry *services.OIDCRegistry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		providerName := c.Params("provider")
+
+		if !checkOIDCStateCookie(c) {
+			return oidcErrorRedirect(c, "/login", "invalid_state")
+		}
+
+		identityService := services.NewIdentityService(db)
+		state, err := identityService.ConsumeState(providerName, c.Query("state"))
+		if err != nil {
+			return oidcErrorRedirect(c, "/login", "invalid_state")
+		}
+
+		errorPath := "/login"
+		if state.Purpose == services.OIDCPurposeLink {
+			errorPath = "/profile"
+		}
+
+		// The user declined or the provider failed
+		if providerError := c.Query("error"); providerError != "" {
+			return oidcErrorRedirect(c, errorPath, providerError)
+		}
+
+		provider, err := registry.Provider(providerName)
+		if err != nil {
+			log.Printf("OIDC discovery error: %v", err)
+			return oidcErrorRedirect(c, errorPath, "provider_unavailable")
+		}
+
+		identity, err := provider.Exchange(c.Context(), c.Query("code"), state.Verifier, state.Nonce)
+		if err != nil {
+			log.Printf("OIDC exchange error: %v", err)
+			return oidcErrorRedirect(c, errorPath, "exchange_failed")
+		}
+
+		if state.Purpose == services.OIDCPurposeLink {
+			if err := identityService.Link(state.UserID, identity); err != nil {
+				switch {
+				case errors.Is(err, services.ErrIdentityLinkedElsewhere):
+					return oidcErrorRedirect(c, errorPath, "identity_linked_elsewhere")
+				case errors.Is(err, services.ErrProviderAlreadyLinked):
+					return oidcErrorRedirect(c, errorPath, "provider_already_linked")
+				}
+				return oidcErrorRedirect(c, errorPath, "server_error")
+			}
+
+			securityEvents := services.NewSecurityEventService(db)
+			details := map[string]interface{}{"provider": providerName}
+			securityEvents.Record(state.UserID, services.SecurityEventIdentityLinked, details, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+			return c.Redirect(appLink("/profile?linked="+url.QueryEscape(providerName), ""))
+		}
+
+		userID, _, err := identityService.ResolveLogin(identity)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrIdentityEmailInUse):
+				return oidcErrorRedirect(c, errorPath, "account_exists")
+			case errors.Is(err, services.ErrOIDCEmailRequired):
+				return oidcErrorRedirect(c, errorPath, "email_required")
+			}
+			return oidcErrorRedirect(c, errorPath, "server_error")
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		code, err := tokenService.CreateToken(userID, services.TokenPurposeOIDCLogin, "", oidcLoginCodeTTL)
+		if err != nil {
+			return oidcErrorRedirect(c, errorPath, "server_error")
+		}
+
+		return c.Redirect(appLink("/login?oidc_code="+url.QueryEscape(code), ""))
+	}
+}
+
+// ExchangeOIDCLogin trades the one-time code from OIDCCallback for the usual
+// login response, or an MFA challenge if the user has two-factor enabled
+func ExchangeOIDCLogin(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Code string `json:"code" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.ConsumeToken(services.TokenPurposeOIDCLogin, req.Code)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidUserToken) {
+				return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired login code"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		var user loginUser
+		var twoFactorEnabled bool
+		query := `SELECT id, name, email, totp_enabled_at IS NOT NULL FROM users WHERE id = $1`
+		if err := db.QueryRow(query, token.UserID).Scan(&user.ID, &user.Name, &user.Email, &twoFactorEnabled); err != nil {
+			log.Printf("Login query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		if twoFactorEnabled {
+			return mfaChallenge(c, user.ID)
+		}
+
+		return completeLogin(c, db, user, nil)
+	}
+}
+
+// ListIdentities returns the external identities linked to the authenticated user
+func ListIdentities(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		identityService := services.NewIdentityService(db)
+		identities, err := identityService.ListForUser(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch linked accounts"})
+		}
+
+		return c.JSON(identities)
+	}
+}
+
+// UnlinkIdentity removes a linked identity from the authenticated user
+func UnlinkIdentity(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		identityID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid identity ID"})
+		}
+
+		identityService := services.NewIdentityService(db)
+		provider, err := identityService.Unlink(userID, identityID)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrIdentityNotFound):
+				return c.Status(404).JSON(fiber.Map{"error": "Linked account not found or access denied"})
+			case errors.Is(err, services.ErrLastLoginMethod):
+				return c.Status(409).JSON(fiber.Map{"error": "Set a password before unlinking your only sign-in method"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to unlink account"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		details := map[string]interface{}{"provider": provider}
+		securityEvents.Record(userID, services.SecurityEventIdentityUnlinked, details, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+		return c.JSON(fiber.Map{
+			"message": "Account unlinked successfully",
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestCheckOIDCStateCookie verifies a callback is only accepted from the
+// browser that started the authorization request, and only once
+func TestCheckOIDCStateCookie(t *testing.T) {
+	app := fiber.New()
+	app.Get("/start", func(c *fiber.Ctx) error {
+		setOIDCStateCookie(c, "state-1")
+		return nil
+	})
+	app.Get("/callback", func(c *fiber.Ctx) error {
+		if checkOIDCStateCookie(c) {
+			return c.SendString("ok")
+		}
+		return c.SendString("rejected")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/start", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Value == "state-1" {
+		t.Fatalf("Expected an HttpOnly cookie holding the state's hash, got %v", cookies)
+	}
+	if !strings.Contains(resp.Header.Get("Set-Cookie"), "SameSite=Lax") {
+		t.Errorf("Expected a SameSite=Lax cookie, got %s", resp.Header.Get("Set-Cookie"))
+	}
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+		want   string
+	}{
+		{"same browser", "state-1", cookies[0].Value, "ok"},
+		{"other browser", "state-1", "", "rejected"},
+		{"other request's state", "state-2", cookies[0].Value, "rejected"},
+		{"no state", "", cookies[0].Value, "rejected"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/callback?state="+tt.state, nil)
+		if tt.cookie != "" {
+			req.Header.Set("Cookie", oidcStateCookie+"="+tt.cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, body)
+		}
+
+		// The cookie is cleared so it cannot be used again
+		cleared := resp.Cookies()
+		if len(cleared) != 1 || cleared[0].Value != "" {
+			t.Errorf("%s: expected the cookie to be cleared, got %v", tt.name, cleared)
+		}
+	}
+}
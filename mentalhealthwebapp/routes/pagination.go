@@ -0,0 +1,203 @@
+// routes/pagination.go
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageCursor is the position after the last item of a page. It is handed
+// to clients as an opaque base64 string.
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int       `json:"i"`
+	Order     string    `json:"o"`
+}
+
+// listParams holds the pagination, filtering and sorting options shared by
+// the mood and journal listings
+type listParams struct {
+	Limit  int
+	Order  string // "asc" or "desc"
+	Cursor *pageCursor
+	From   *time.Time
+	To     *time.Time
+}
+
+// encodeCursor serializes a cursor for the next_cursor field
+func encodeCursor(cur pageCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(s string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cur pageCursor
+	if err := json.Unmarshal(data, &cur); err != nil || cur.CreatedAt.IsZero() || cur.ID <= 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	if cur.Order != "asc" && cur.Order != "desc" {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &cur, nil
+}
+
+// parseDateParam accepts either an RFC3339 timestamp or a YYYY-MM-DD date.
+// Dates used as an upper bound cover the whole day.
+func parseDateParam(value string, endOfDay bool) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// parseListParams reads limit, cursor, order, from and to from the query string
+func parseListParams(c *fiber.Ctx) (*listParams, error) {
+	params := &listParams{Limit: defaultPageSize, Order: "desc"}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		params.Limit = n
+	}
+
+	if order := strings.ToLower(c.Query("order")); order != "" {
+		if order != "asc" && order != "desc" {
+			return nil, errors.New("order must be 'asc' or 'desc'")
+		}
+		params.Order = order
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		cur, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cur.Order != params.Order {
+			return nil, errors.New("cursor does not match the requested order")
+		}
+		params.Cursor = cur
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			return nil, errors.New("from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		}
+		params.From = t
+	}
+
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			return nil, errors.New("to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		}
+		params.To = t
+	}
+
+	if params.From != nil && params.To != nil && !params.From.Before(*params.To) {
+		return nil, errors.New("from must be before to")
+	}
+
+	return params, nil
+}
+
+// listQuery accumulates WHERE conditions and their positional arguments
+type listQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg registers a query argument and returns its placeholder
+func (q *listQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// where adds a condition built from the placeholders returned by arg
+func (q *listQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+// applyDateRange adds the from/to filters on created_at
+func (q *listQuery) applyDateRange(params *listParams) {
+	if params.From != nil {
+		q.where("created_at >= " + q.arg(*params.From))
+	}
+	if params.To != nil {
+		q.where("created_at < " + q.arg(*params.To))
+	}
+}
+
+// whereClause joins the accumulated conditions
+func (q *listQuery) whereClause() string {
+	return "WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// pageClause returns the keyset condition, ordering and limit for a page.
+// It must be called after the count query has been built, since the cursor
+// only applies to the page itself.
+func (q *listQuery) pageClause(params *listParams) string {
+	where := q.whereClause()
+	if params.Cursor != nil {
+		op := "<"
+		if params.Order == "asc" {
+			op = ">"
+		}
+		where += fmt.Sprintf(" AND (created_at, id) %s (%s, %s)",
+			op, q.arg(params.Cursor.CreatedAt), q.arg(params.Cursor.ID))
+	}
+
+	direction := "DESC"
+	if params.Order == "asc" {
+		direction = "ASC"
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	return fmt.Sprintf("%s ORDER BY created_at %s, id %s LIMIT %d", where, direction, direction, params.Limit+1)
+}
+
+// likePattern escapes LIKE wildcards so user input matches literally
+func likePattern(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(s) + "%"
+}
+
+// nextCursor returns the cursor for the page following items, or nil when
+// fewer than limit+1 rows were fetched
+func nextCursor(params *listParams, fetched int, last func() (time.Time, int)) *string {
+	if fetched <= params.Limit {
+		return nil
+	}
+	createdAt, id := last()
+	cursor := encodeCursor(pageCursor{CreatedAt: createdAt, ID: id, Order: params.Order})
+	return &cursor
+}
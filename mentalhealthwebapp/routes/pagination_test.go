@@ -0,0 +1,58 @@
+// routes/pagination_test.go
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCursorRoundTrip verifies that an encoded cursor decodes to the same position
+func TestCursorRoundTrip(t *testing.T) {
+	want := pageCursor{
+		CreatedAt: time.Date(2025, 3, 14, 9, 26, 53, 589793000, time.UTC),
+		ID:        42,
+		Order:     "desc",
+	}
+
+	got, err := decodeCursor(encodeCursor(want))
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID || got.Order != want.Order {
+		t.Errorf("Expected %+v, got %+v", want, *got)
+	}
+}
+
+// TestDecodeCursorRejectsGarbage verifies that tampered cursors are refused
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"not-base64!", "e30", encodeCursor(pageCursor{ID: 1, Order: "desc"})} {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("Expected error for cursor %q", s)
+		}
+	}
+}
+
+// TestPageClause verifies the keyset condition and placeholders for each order
+func TestPageClause(t *testing.T) {
+	cursor := &pageCursor{CreatedAt: time.Now(), ID: 7, Order: "asc"}
+
+	q := &listQuery{}
+	q.where("user_id = " + q.arg(1))
+	got := q.pageClause(&listParams{Limit: 10, Order: "asc", Cursor: cursor})
+
+	want := "WHERE user_id = $1 AND (created_at, id) > ($2, $3) ORDER BY created_at ASC, id ASC LIMIT 11"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if len(q.args) != 3 {
+		t.Errorf("Expected 3 args, got %d", len(q.args))
+	}
+}
+
+// TestLikePattern verifies that LIKE wildcards in user input are escaped
+func TestLikePattern(t *testing.T) {
+	if got := likePattern(`50%_off\`); got != `%50\%\_off\\%` {
+		t.Errorf("Unexpected pattern %q", got)
+	}
+}
@@ -0,0 +1,31 @@
+// routes/params.go
+package routes
+
+import (
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parseIDParam reads the ":id" route parameter as a positive integer
+func parseIDParam(c *fiber.Ctx) (int, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil || id <= 0 {
+		return 0, errors.New("invalid ID")
+	}
+	return id, nil
+}
+
+// normalizeEmail validates a bare email address, trims surrounding space
+// and lowercases it. Addresses are stored and matched lowercased.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 255 {
+		return "", errors.New("invalid email address")
+	}
+	return email, nil
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// Personal access token lifetime in days
+const (
+	defaultTokenExpiryDays = 90
+	maxTokenExpiryDays     = 365
+)
+
+// ListPersonalAccessTokens returns the authenticated user's active tokens
+func ListPersonalAccessTokens(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		tokenService := services.NewPersonalAccessTokenService(db)
+		tokens, err := tokenService.ListForUser(userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch tokens"})
+		}
+
+		return c.JSON(tokens)
+	}
+}
+
+// CreatePersonalAccessToken issues a scoped token for scripts and
+// integrations. The token is only included in this response.
+func CreatePersonalAccessToken(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Name          string   `json:"name" validate:"required,max=100"`
+			Scopes        []string `json:"scopes" validate:"required"`
+			ExpiresInDays int      `json:"expires_in_days"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" || len(name) > 100 {
+			return c.Status(400).JSON(fiber.Map{"error": "Name must be between 1 and 100 characters"})
+		}
+
+		scopes, err := services.ValidateScopes(req.Scopes)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":        err.Error(),
+				"valid_scopes": services.PersonalAccessTokenScopes,
+			})
+		}
+
+		if req.ExpiresInDays == 0 {
+			req.ExpiresInDays = defaultTokenExpiryDays
+		}
+		if req.ExpiresInDays < 1 || req.ExpiresInDays > maxTokenExpiryDays {
+			return c.Status(400).JSON(fiber.Map{"error": "expires_in_days must be between 1 and 365"})
+		}
+
+		tokenService := services.NewPersonalAccessTokenService(db)
+		token, pat, err := tokenService.Create(userID, name, scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create token"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		details := map[string]interface{}{"token_id": pat.ID, "name": pat.Name, "scopes": pat.Scopes}
+		securityEvents.Record(userID, services.SecurityEventTokenCreated, details, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Status(201).JSON(fiber.Map{
+			"message":        "Token created. Copy it now; it will not be shown again.",
+			"token":          token,
+			"personal_token": pat,
+		})
+	}
+}
+
+// RevokePersonalAccessToken disables one of the authenticated user's tokens
+func RevokePersonalAccessToken(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		tokenID, err := parseIDParam(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid token ID"})
+		}
+
+		tokenService := services.NewPersonalAccessTokenService(db)
+		revoked, err := tokenService.Revoke(userID, tokenID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke token"})
+		}
+		if !revoked {
+			return c.Status(404).JSON(fiber.Map{"error": "Token not found or access denied"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		details := map[string]interface{}{"token_id": tokenID}
+		securityEvents.Record(userID, services.SecurityEventTokenRevoked, details, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+		return c.JSON(fiber.Map{
+			"message": "Token revoked successfully",
+		})
+	}
+}
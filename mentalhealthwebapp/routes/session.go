@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// parseSessionID reads the ":id" route parameter as a session UUID
+func parseSessionID(c *fiber.Ctx) (string, bool) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// ListSessions returns the authenticated user's active sessions (devices),
+// marking the one the request was made from
+func ListSessions(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		currentSessionID, _ := c.Locals("sessionID").(string)
+
+		sessionService := services.NewSessionService(db)
+		sessions, err := sessionService.ListActive(userID, currentSessionID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch sessions"})
+		}
+
+		return c.JSON(sessions)
+	}
+}
+
+// RevokeSession signs out one of the authenticated user's sessions
+func RevokeSession(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		sessionID, ok := parseSessionID(c)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid session ID"})
+		}
+
+		sessionService := services.NewSessionService(db)
+		revoked, err := sessionService.Revoke(userID, sessionID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke session"})
+		}
+		if !revoked {
+			return c.Status(404).JSON(fiber.Map{"error": "Session not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Session revoked successfully",
+		})
+	}
+}
+
+// UpdateSession renames one of the authenticated user's sessions
+func UpdateSession(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		sessionID, ok := parseSessionID(c)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid session ID"})
+		}
+
+		type Request struct {
+			Label string `json:"label" validate:"max=100"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		label := strings.TrimSpace(req.Label)
+		if len(label) > 100 {
+			return c.Status(400).JSON(fiber.Map{"error": "Label must be 100 characters or less"})
+		}
+
+		sessionService := services.NewSessionService(db)
+		updated, err := sessionService.UpdateLabel(userID, sessionID, label)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update session"})
+		}
+		if !updated {
+			return c.Status(404).JSON(fiber.Map{"error": "Session not found or access denied"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Session updated successfully",
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+)
+
+// totpIssuer is the account name shown in authenticator apps, configured
+// with TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Mental Health App"
+}
+
+// SetupTwoFactor starts TOTP enrollment for the authenticated user and
+// returns the secret and an otpauth:// URI to show as a QR code. Enrollment
+// is completed with VerifyTwoFactor.
+func SetupTwoFactor(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Password   string `json:"password"`
+			ReauthCode string `json:"reauth_code"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if ok, err := confirmIdentity(c, db, userID, req.Password, req.ReauthCode); !ok {
+			return err
+		}
+
+		user, err := loadUser(db, userID)
+		if err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		twoFactorService := services.NewTwoFactorService(db)
+		secret, err := twoFactorService.BeginSetup(userID)
+		if err != nil {
+			if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+				return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor setup"})
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(fiber.Map{
+			"secret":      secret,
+			"otpauth_uri": services.TOTPProvisioningURI(totpIssuer(), user.Email, secret),
+		})
+	}
+}
+
+// VerifyTwoFactor completes TOTP enrollment with a code from the
+// authenticator app and returns one-time recovery codes. The codes are
+// shown only once.
+func VerifyTwoFactor(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Code string `json:"code" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		twoFactorService := services.NewTwoFactorService(db)
+		recoveryCodes, err := twoFactorService.Enable(userID, req.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+				return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+			case errors.Is(err, services.ErrTwoFactorSetupRequired):
+				return c.Status(400).JSON(fiber.Map{"error": "Start two-factor setup first"})
+			case errors.Is(err, services.ErrInvalidTwoFactorCode):
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid authentication code"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor authentication"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		securityEvents.Record(userID, services.SecurityEventTwoFactorEnabled, nil, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(fiber.Map{
+			"message":        "Two-factor authentication enabled. Store your recovery codes somewhere safe.",
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+// DisableTwoFactor turns off two-factor authentication after confirming the
+// user's identity and checking a current TOTP or recovery code
+func DisableTwoFactor(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Password   string `json:"password"`
+			ReauthCode string `json:"reauth_code"`
+			Code       string `json:"code" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if ok, err := confirmIdentity(c, db, userID, req.Password, req.ReauthCode); !ok {
+			return err
+		}
+
+		twoFactorService := services.NewTwoFactorService(db)
+		if _, err := twoFactorService.Verify(userID, req.Code); err != nil {
+			switch {
+			case errors.Is(err, services.ErrTwoFactorNotEnabled):
+				return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+			case errors.Is(err, services.ErrInvalidTwoFactorCode):
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid authentication code"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		if err := twoFactorService.Disable(userID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor authentication"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		securityEvents.Record(userID, services.SecurityEventTwoFactorDisabled, nil, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+		return c.JSON(fiber.Map{
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
@@ -2,9 +2,17 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/services"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // GetUserProfile returns the profile of the authenticated user
@@ -16,11 +24,18 @@ func GetUserProfile(db *sql.DB) fiber.Handler {
 			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
 		}
 
-		// For now, return mock user data since we don't have a users table
-		// In a real application, you would query the users table
+		user, err := loadUser(db, userID)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		if err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch profile"})
+		}
+
 		query := `SELECT COUNT(*) as mood_count FROM moods WHERE user_id = $1`
 		var moodCount int
-		err := db.QueryRow(query, userID).Scan(&moodCount)
+		err = db.QueryRow(query, userID).Scan(&moodCount)
 		if err != nil {
 			log.Printf("Mood count query error: %v", err)
 			moodCount = 0
@@ -36,12 +51,363 @@ func GetUserProfile(db *sql.DB) fiber.Handler {
 
 		// Return user profile with statistics
 		return c.JSON(fiber.Map{
-			"user_id":         userID,
-			"name":            "User",             // Mock data - in real app, get from users table
-			"email":           "user@example.com", // Mock data
-			"mood_entries":    moodCount,
-			"journal_entries": journalCount,
-			"member_since":    "2025-01-01", // Mock data
+			"user_id":              user.ID,
+			"name":                 user.Name,
+			"email":                user.Email,
+			"timezone":             user.Timezone,
+			"locale":               user.Locale,
+			"avatar_url":           user.AvatarURL,
+			"email_verified":       user.EmailVerifiedAt != nil,
+			"chat_context_enabled": user.ChatContextEnabled,
+			"mood_entries":         moodCount,
+			"journal_entries":      journalCount,
+			"member_since":         user.CreatedAt.Format("2006-01-02"),
+		})
+	}
+}
+
+// loadUser reads a user's profile from the users table
+func loadUser(db *sql.DB, userID int) (*models.User, error) {
+	var u models.User
+	query := `
+		SELECT id, name, email, timezone, locale, avatar_url, email_verified_at, totp_enabled_at IS NOT NULL, chat_context_enabled, created_at
+		FROM users WHERE id = $1
+	`
+	err := db.QueryRow(query, userID).Scan(&u.ID, &u.Name, &u.Email, &u.Timezone, &u.Locale, &u.AvatarURL, &u.EmailVerifiedAt, &u.TwoFactorEnabled, &u.ChatContextEnabled, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// localePattern matches simple BCP 47 tags such as "en" or "pt-BR"
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// UpdateUserProfile updates the name, timezone, locale, avatar and chat
+// context setting of the authenticated user. Fields omitted from the body
+// are left unchanged.
+func UpdateUserProfile(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			Name               *string `json:"name"`
+			Timezone           *string `json:"timezone"`
+			Locale             *string `json:"locale"`
+			AvatarURL          *string `json:"avatar_url"`
+			ChatContextEnabled *bool   `json:"chat_context_enabled"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name == "" || len(name) > 100 {
+				return c.Status(400).JSON(fiber.Map{"error": "Name must be between 1 and 100 characters"})
+			}
+			req.Name = &name
+		}
+		if req.Timezone != nil {
+			if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || len(*req.Timezone) > 64 {
+				return c.Status(400).JSON(fiber.Map{"error": "Invalid timezone"})
+			}
+		}
+		if req.Locale != nil && !localePattern.MatchString(*req.Locale) {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid locale"})
+		}
+		if req.AvatarURL != nil && *req.AvatarURL != "" {
+			u, err := url.Parse(*req.AvatarURL)
+			if err != nil || u.Scheme != "https" || u.Host == "" || len(*req.AvatarURL) > 2048 {
+				return c.Status(400).JSON(fiber.Map{"error": "Avatar URL must be an https URL"})
+			}
+		}
+
+		// An empty avatar_url clears the avatar
+		clearAvatar := req.AvatarURL != nil && *req.AvatarURL == ""
+
+		query := `
+			UPDATE users SET
+				name = COALESCE($1, name),
+				timezone = COALESCE($2, timezone),
+				locale = COALESCE($3, locale),
+				avatar_url = CASE WHEN $5 THEN NULL ELSE COALESCE($4, avatar_url) END,
+				chat_context_enabled = COALESCE($6, chat_context_enabled)
+			WHERE id = $7
+		`
+		result, err := db.Exec(query, req.Name, req.Timezone, req.Locale, req.AvatarURL, clearAvatar, req.ChatContextEnabled, userID)
+		if err != nil {
+			log.Printf("Profile update error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update profile"})
+		}
+
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		user, err := loadUser(db, userID)
+		if err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch profile"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Profile updated successfully",
+			"user":    user,
+		})
+	}
+}
+
+// reauthCodeTTL is how long a code from RequestReauthCode can be used
+const reauthCodeTTL = 15 * time.Minute
+
+// confirmIdentity re-authenticates userID before a sensitive change. Users
+// with a password confirm with it; accounts that only sign in through an
+// identity provider have no password and confirm with a code emailed by
+// RequestReauthCode instead. When the check fails the response has been
+// sent and false is returned with the error to return from the handler.
+func confirmIdentity(c *fiber.Ctx, db *sql.DB, userID int, password, code string) (bool, error) {
+	var passwordHash sql.NullString
+	err := db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash)
+	if err != nil {
+		log.Printf("Password query error: %v", err)
+		return false, c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+	}
+
+	if passwordHash.Valid {
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password)) != nil {
+			return false, c.Status(403).JSON(fiber.Map{"error": "Password is incorrect"})
+		}
+		return true, nil
+	}
+
+	if code == "" {
+		return false, c.Status(403).JSON(fiber.Map{
+			"error":           "This account has no password. Request a confirmation code with POST /api/user/reauth and send it as reauth_code",
+			"reauth_required": true,
+		})
+	}
+
+	tokenService := services.NewUserTokenService(db)
+	token, err := tokenService.ConsumeToken(services.TokenPurposeReauth, code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUserToken) {
+			return false, c.Status(403).JSON(fiber.Map{"error": "Invalid or expired confirmation code"})
+		}
+		return false, c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+	}
+	if token.UserID != userID {
+		return false, c.Status(403).JSON(fiber.Map{"error": "Invalid or expired confirmation code"})
+	}
+	return true, nil
+}
+
+// RequestReauthCode emails a single-use confirmation code to an account
+// without a password. The code stands in for the password when deleting
+// the account, changing its email or managing two-factor authentication.
+func RequestReauthCode(db *sql.DB, mailer services.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		var email string
+		var hasPassword bool
+		query := `SELECT email, password_hash IS NOT NULL FROM users WHERE id = $1`
+		if err := db.QueryRow(query, userID).Scan(&email, &hasPassword); err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+		if hasPassword {
+			return c.Status(400).JSON(fiber.Map{"error": "This account has a password; confirm with it instead"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		code, err := tokenService.CreateToken(userID, services.TokenPurposeReauth, "", reauthCodeTTL)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create confirmation code"})
+		}
+
+		msg := services.Message{
+			To:      email,
+			Subject: "Your confirmation code",
+			Body: "Use this code within 15 minutes to confirm a change to your Mental Health App account:\n\n" +
+				code +
+				"\n\nIf you did not ask for this, someone may be signed in to your account. Log out of all devices and review your linked sign-in providers.",
+		}
+		if err := mailer.Send(c.Context(), msg); err != nil {
+			log.Printf("Failed to send confirmation code email: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to send confirmation code"})
+		}
+
+		return c.Status(202).JSON(fiber.Map{"message": "A confirmation code has been sent to your email"})
+	}
+}
+
+// ChangePassword replaces the authenticated user's password after checking
+// the current one. Accounts without a password set one by confirming with a
+// code from RequestReauthCode. Every existing session is revoked and a fresh token pair
+// is returned for the caller.
+func ChangePassword(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			CurrentPassword string `json:"current_password"`
+			ReauthCode      string `json:"reauth_code"`
+			NewPassword     string `json:"new_password" validate:"required,min=6"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if len(req.NewPassword) < 6 || len(req.NewPassword) > 72 {
+			return c.Status(400).JSON(fiber.Map{"error": "Password must be between 6 and 72 characters"})
+		}
+
+		if ok, err := confirmIdentity(c, db, userID, req.CurrentPassword, req.ReauthCode); !ok {
+			return err
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Could not hash password"})
+		}
+
+		query := `UPDATE users SET password_hash = $1, password_changed_at = CURRENT_TIMESTAMP WHERE id = $2`
+		if _, err := db.Exec(query, string(hashed), userID); err != nil {
+			log.Printf("Password update error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update password"})
+		}
+
+		// Sign out everywhere else, then keep this client signed in
+		refreshService := services.NewRefreshTokenService(db)
+		if err := refreshService.RevokeAllUserTokens(userID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke existing sessions"})
+		}
+		if err := services.NewPersonalAccessTokenService(db).RevokeAllForUser(userID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke personal access tokens"})
+		}
+
+		tokenPair, err := issueTokenPair(c, db, userID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to generate tokens"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":       "Password changed successfully",
+			"access_token":  tokenPair.AccessToken,
+			"refresh_token": tokenPair.RefreshToken,
+			"expires_in":    tokenPair.ExpiresIn,
+		})
+	}
+}
+
+// RequestEmailChange starts an email change for the authenticated user. The
+// new address only takes effect once the link sent to it is confirmed.
+func RequestEmailChange(db *sql.DB, mailer services.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		type Request struct {
+			NewEmail   string `json:"new_email" validate:"required,email"`
+			Password   string `json:"password"`
+			ReauthCode string `json:"reauth_code"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		newEmail, err := normalizeEmail(req.NewEmail)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid email address"})
+		}
+
+		if ok, err := confirmIdentity(c, db, userID, req.Password, req.ReauthCode); !ok {
+			return err
+		}
+
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))`, newEmail).Scan(&exists); err != nil {
+			log.Printf("Email lookup error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+		if exists {
+			return c.Status(409).JSON(fiber.Map{"error": "Email already exists"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.CreateToken(userID, services.TokenPurposeEmailChange, newEmail, 24*time.Hour)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to start email change"})
+		}
+
+		msg := services.Message{
+			To:      newEmail,
+			Subject: "Confirm your new email address",
+			Body: "Confirm your new email address for Mental Health App by opening this link within 24 hours:\n\n" +
+				appLink("/confirm-email", token) +
+				"\n\nIf you did not request this change, you can ignore this email.",
+		}
+		if err := mailer.Send(c.Context(), msg); err != nil {
+			log.Printf("Failed to send email change confirmation: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to send confirmation email"})
+		}
+
+		return c.Status(202).JSON(fiber.Map{
+			"message": "Check your new email address for a confirmation link",
+		})
+	}
+}
+
+// ConfirmEmailChange applies a pending email change using the token that
+// was sent to the new address
+func ConfirmEmailChange(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Token string `json:"token" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.ConfirmEmailChange(req.Token)
+		if err == services.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+		if err == services.ErrEmailTaken {
+			return c.Status(409).JSON(fiber.Map{"error": "Email already exists"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to update email"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Email updated successfully",
+			"email":   token.NewEmail,
 		})
 	}
 }
@@ -123,3 +489,44 @@ func GetUserStats(db *sql.DB) fiber.Handler {
 		})
 	}
 }
+
+// GetSecurityEvents lists recent security events on the authenticated user's account
+func GetSecurityEvents(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		securityEvents := services.NewSecurityEventService(db)
+		events, err := securityEvents.ListForUser(userID, 50)
+		if err != nil {
+			log.Printf("Security events query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch security events"})
+		}
+
+		return c.JSON(events)
+	}
+}
+
+// GetSafetyEvents lists the authenticated user's recent chat messages that
+// were flagged by the safety check
+func GetSafetyEvents(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		safetyEvents := services.NewSafetyEventService(db)
+		events, err := safetyEvents.ListForUser(userID, 50)
+		if err != nil {
+			log.Printf("Safety events query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch safety events"})
+		}
+
+		return c.JSON(events)
+	}
+}
@@ -0,0 +1,213 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leketech/mental-health-app/services"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sendVerificationEmail issues a verification token bound to email and
+// mails the link to it
+func sendVerificationEmail(ctx context.Context, db *sql.DB, mailer services.Mailer, userID int, email string) error {
+	tokenService := services.NewUserTokenService(db)
+	token, err := tokenService.CreateToken(userID, services.TokenPurposeVerifyEmail, email, 48*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, services.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Welcome to Mental Health App! Verify your email address by opening this link within 48 hours:\n\n" +
+			appLink("/verify-email", token) +
+			"\n\nIf you did not create an account, you can ignore this email.",
+	})
+}
+
+// VerifyEmail marks the user's email as verified using the token from the
+// verification email
+func VerifyEmail(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Token string `json:"token" validate:"required"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.ConsumeToken(services.TokenPurposeVerifyEmail, req.Token)
+		if err == services.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		// The token only verifies the address it was sent to
+		query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1 AND email = $2`
+		result, err := db.Exec(query, token.UserID, token.NewEmail)
+		if err != nil {
+			log.Printf("Email verification error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to verify email"})
+		}
+		if rowsAffected, err := result.RowsAffected(); err == nil && rowsAffected == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Email verified successfully",
+		})
+	}
+}
+
+// ResendVerificationEmail sends a new verification link to the
+// authenticated user's current address
+func ResendVerificationEmail(db *sql.DB, mailer services.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Get user ID from JWT context
+		userID, ok := c.Locals("userID").(int)
+		if !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "Unauthorized: invalid user context"})
+		}
+
+		var email string
+		var verifiedAt sql.NullTime
+		err := db.QueryRow(`SELECT email, email_verified_at FROM users WHERE id = $1`, userID).Scan(&email, &verifiedAt)
+		if err != nil {
+			log.Printf("User query error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		if verifiedAt.Valid {
+			return c.Status(409).JSON(fiber.Map{"error": "Email is already verified"})
+		}
+
+		if err := sendVerificationEmail(c.Context(), db, mailer, userID, email); err != nil {
+			log.Printf("Failed to send verification email: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to send verification email"})
+		}
+
+		return c.Status(202).JSON(fiber.Map{
+			"message": "Verification email sent",
+		})
+	}
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the address belongs to an account.
+func ForgotPassword(db *sql.DB, mailer services.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		response := fiber.Map{
+			"message": "If an account exists for that email, a password reset link has been sent",
+		}
+
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			return c.Status(202).JSON(response)
+		}
+
+		var userID int
+		err = db.QueryRow(`SELECT id, email FROM users WHERE LOWER(email) = LOWER($1)`, email).Scan(&userID, &email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Forgot password query error: %v", err)
+			}
+			return c.Status(202).JSON(response)
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.CreateToken(userID, services.TokenPurposePasswordReset, "", time.Hour)
+		if err != nil {
+			return c.Status(202).JSON(response)
+		}
+
+		msg := services.Message{
+			To:      email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password for your Mental Health App account. Open this link within 1 hour to choose a new password:\n\n" +
+				appLink("/reset-password", token) +
+				"\n\nIf this wasn't you, you can ignore this email and your password will stay the same.",
+		}
+		if err := mailer.Send(c.Context(), msg); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+
+		return c.Status(202).JSON(response)
+	}
+}
+
+// ResetPassword sets a new password using the token from the reset email
+// and signs the user out everywhere
+func ResetPassword(db *sql.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type Request struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required,min=6"`
+		}
+
+		var req Request
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+
+		if len(req.Password) < 6 || len(req.Password) > 72 {
+			return c.Status(400).JSON(fiber.Map{"error": "Password must be between 6 and 72 characters"})
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Could not hash password"})
+		}
+
+		tokenService := services.NewUserTokenService(db)
+		token, err := tokenService.ConsumeToken(services.TokenPurposePasswordReset, req.Token)
+		if err == services.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired token"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Internal server error"})
+		}
+
+		// Receiving the reset email also proves ownership of the address
+		query := `
+			UPDATE users SET
+				password_hash = $1,
+				password_changed_at = CURRENT_TIMESTAMP,
+				email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+			WHERE id = $2
+		`
+		if _, err := db.Exec(query, string(hashed), token.UserID); err != nil {
+			log.Printf("Password reset error: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to reset password"})
+		}
+
+		refreshService := services.NewRefreshTokenService(db)
+		if err := refreshService.RevokeAllUserTokens(token.UserID); err != nil {
+			log.Printf("Failed to revoke sessions after password reset: %v", err)
+		}
+		if err := services.NewPersonalAccessTokenService(db).RevokeAllForUser(token.UserID); err != nil {
+			log.Printf("Failed to revoke personal access tokens after password reset: %v", err)
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Password reset successfully. Please log in with your new password.",
+		})
+	}
+}
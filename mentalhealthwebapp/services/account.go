@@ -0,0 +1,91 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// AccountService handles account deletion requests. Deletion is scheduled
+// after a grace period during which the user can cancel it; afterwards the
+// user row is hard-deleted and every user-owned table cascades.
+type AccountService struct {
+	db *sql.DB
+}
+
+// NewAccountService creates a new account service
+func NewAccountService(db *sql.DB) *AccountService {
+	return &AccountService{db: db}
+}
+
+// ScheduleDeletion marks the account for deletion after grace. Requesting
+// deletion again keeps the original schedule.
+func (s *AccountService) ScheduleDeletion(userID int, grace time.Duration) (time.Time, error) {
+	var scheduledFor time.Time
+	query := `
+		UPDATE users SET
+			deletion_requested_at = COALESCE(deletion_requested_at, CURRENT_TIMESTAMP),
+			deletion_scheduled_for = COALESCE(deletion_scheduled_for, $2)
+		WHERE id = $1
+		RETURNING deletion_scheduled_for
+	`
+	err := s.db.QueryRow(query, userID, time.Now().Add(grace)).Scan(&scheduledFor)
+	if err != nil {
+		log.Printf("Error scheduling account deletion: %v", err)
+		return time.Time{}, err
+	}
+
+	return scheduledFor, nil
+}
+
+// CancelDeletion clears a pending deletion. It reports whether one was pending.
+func (s *AccountService) CancelDeletion(userID int) (bool, error) {
+	query := `
+		UPDATE users SET deletion_requested_at = NULL, deletion_scheduled_for = NULL
+		WHERE id = $1 AND deletion_scheduled_for IS NOT NULL
+	`
+	result, err := s.db.Exec(query, userID)
+	if err != nil {
+		log.Printf("Error cancelling account deletion: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// PendingDeletion returns when the account is scheduled to be deleted, or
+// nil if no deletion is pending
+func (s *AccountService) PendingDeletion(userID int) (*time.Time, error) {
+	var scheduledFor sql.NullTime
+	err := s.db.QueryRow(`SELECT deletion_scheduled_for FROM users WHERE id = $1`, userID).Scan(&scheduledFor)
+	if err != nil {
+		return nil, err
+	}
+	if !scheduledFor.Valid {
+		return nil, nil
+	}
+	return &scheduledFor.Time, nil
+}
+
+// PurgeDueAccounts permanently deletes accounts whose grace period is over.
+// Foreign keys declared ON DELETE CASCADE remove moods, journals, tokens and
+// any other user-owned rows along with the user.
+func (s *AccountService) PurgeDueAccounts() (int, error) {
+	result, err := s.db.Exec(`DELETE FROM users WHERE deletion_scheduled_for <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Printf("Error purging deleted accounts: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(rowsAffected), nil
+}
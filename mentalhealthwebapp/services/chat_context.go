@@ -0,0 +1,456 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Limits on what is shared with the chat provider, so the context stays a
+// short summary rather than a copy of the user's records
+const (
+	chatContextMoodDays       = 14
+	chatContextMaxMoods       = 7
+	chatContextMaxJournals    = 3
+	chatContextMoodNoteLength = 120
+	chatContextExcerptLength  = 300
+	chatContextMaxKeywords    = 8
+)
+
+// chatContextHeader introduces the shared context in the system prompt
+const chatContextHeader = "The user has chosen to share the following from their mood log and journal. " +
+	"Use it to understand them, refer to it gently and only when relevant, and do not repeat it back in full."
+
+// JournalExcerpt is part of a journal entry relevant to a chat message
+type JournalExcerpt struct {
+	JournalID int       `json:"journal_id"`
+	Title     string    `json:"title"`
+	Excerpt   string    `json:"excerpt"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JournalRetriever finds the journal entries of a user most relevant to a
+// chat message
+type JournalRetriever interface {
+	Retrieve(ctx context.Context, userID int, query string, limit int) ([]JournalExcerpt, error)
+}
+
+// chatStopWords are left out of keyword searches
+var chatStopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "all": true, "also": true, "and": true, "any": true, "are": true,
+	"because": true, "been": true, "before": true, "but": true, "can": true, "could": true, "did": true, "does": true,
+	"didn": true, "doesn": true, "doing": true, "don": true, "for": true, "from": true, "had": true, "has": true,
+	"have": true, "how": true, "into": true, "isn": true, "its": true, "just": true, "like": true, "more": true,
+	"much": true, "not": true, "wasn": true, "won": true,
+	"now": true, "really": true, "should": true, "some": true, "than": true, "that": true, "the": true, "their": true,
+	"them": true, "then": true, "there": true, "they": true, "this": true, "today": true, "very": true, "want": true,
+	"was": true, "were": true, "what": true, "when": true, "which": true, "why": true, "will": true, "with": true,
+	"would": true, "you": true, "your": true, "feel": true, "feeling": true, "think": true, "know": true,
+}
+
+// chatWordPattern matches the words keywords are taken from
+var chatWordPattern = regexp.MustCompile(`\p{L}+`)
+
+// chatKeywords picks the distinct, meaningful words of a message to search
+// journals for
+func chatKeywords(text string) []string {
+	var keywords []string
+	seen := make(map[string]bool)
+	for _, word := range chatWordPattern.FindAllString(strings.ToLower(text), -1) {
+		if len([]rune(word)) < 3 || chatStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+		if len(keywords) == chatContextMaxKeywords {
+			break
+		}
+	}
+	return keywords
+}
+
+// chatContextExcerpt cuts body down to about maxLen bytes around the first
+// keyword it contains, or from the start if it contains none
+func chatContextExcerpt(body string, keywords []string, maxLen int) string {
+	body = strings.Join(strings.Fields(body), " ")
+	if len(body) <= maxLen {
+		return body
+	}
+
+	start := 0
+	lower := strings.ToLower(body)
+	for _, keyword := range keywords {
+		if i := strings.Index(lower, keyword); i >= 0 {
+			start = i - maxLen/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start+maxLen > len(body) {
+		start = len(body) - maxLen
+	}
+	end := start + maxLen
+
+	// Cut at spaces so words and multi-byte characters stay whole
+	if start > 0 {
+		if i := strings.IndexByte(body[start:end], ' '); i >= 0 {
+			start += i + 1
+		}
+	}
+	if end < len(body) {
+		if i := strings.LastIndexByte(body[start:end], ' '); i > 0 {
+			end = start + i
+		}
+	}
+
+	excerpt := body[start:end]
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(body) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// truncateText shortens text to at most maxLen bytes at a word boundary
+func truncateText(text string, maxLen int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= maxLen {
+		return text
+	}
+	if i := strings.LastIndexByte(text[:maxLen], ' '); i > 0 {
+		return text[:i] + "…"
+	}
+	return strings.ToValidUTF8(text[:maxLen], "") + "…"
+}
+
+// KeywordJournalRetriever ranks journal entries with PostgreSQL full-text
+// search on the keywords of the message
+type KeywordJournalRetriever struct {
+	db *sql.DB
+}
+
+// NewKeywordJournalRetriever creates a keyword retriever
+func NewKeywordJournalRetriever(db *sql.DB) *KeywordJournalRetriever {
+	return &KeywordJournalRetriever{db: db}
+}
+
+// Retrieve implements JournalRetriever
+func (r *KeywordJournalRetriever) Retrieve(ctx context.Context, userID int, query string, limit int) ([]JournalExcerpt, error) {
+	keywords := chatKeywords(query)
+	if len(keywords) == 0 {
+		return nil, nil
+	}
+
+	// Keywords are letters only, so they are safe to join into a tsquery;
+	// any one of them is enough to match
+	sqlQuery := `
+		SELECT id, title, body, created_at
+		FROM journals, to_tsquery('english', $2) AS q
+		WHERE user_id = $1 AND to_tsvector('english', title || ' ' || body) @@ q
+		ORDER BY ts_rank(to_tsvector('english', title || ' ' || body), q) DESC, created_at DESC
+		LIMIT $3
+	`
+	rows, err := r.db.QueryContext(ctx, sqlQuery, userID, strings.Join(keywords, " | "), limit)
+	if err != nil {
+		log.Printf("Error searching journals: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var excerpts []JournalExcerpt
+	for rows.Next() {
+		var e JournalExcerpt
+		var body string
+		if err := rows.Scan(&e.JournalID, &e.Title, &body, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Excerpt = chatContextExcerpt(body, keywords, chatContextExcerptLength)
+		excerpts = append(excerpts, e)
+	}
+	return excerpts, rows.Err()
+}
+
+// Embedder turns texts into embedding vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbeddingJournalRetriever ranks the user's most recent journal entries
+// by the similarity of their embeddings to the message's. Entries are
+// embedded on each request, so only the latest few are considered.
+type EmbeddingJournalRetriever struct {
+	db         *sql.DB
+	embedder   Embedder
+	candidates int
+}
+
+// NewEmbeddingJournalRetriever creates a retriever comparing the latest
+// candidates entries
+func NewEmbeddingJournalRetriever(db *sql.DB, embedder Embedder, candidates int) *EmbeddingJournalRetriever {
+	return &EmbeddingJournalRetriever{db: db, embedder: embedder, candidates: candidates}
+}
+
+// cosineSimilarity compares two embeddings; 0 if either is empty
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Retrieve implements JournalRetriever
+func (r *EmbeddingJournalRetriever) Retrieve(ctx context.Context, userID int, query string, limit int) ([]JournalExcerpt, error) {
+	sqlQuery := `SELECT id, title, body, created_at FROM journals WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, sqlQuery, userID, r.candidates)
+	if err != nil {
+		log.Printf("Error loading journals: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []JournalExcerpt
+	var bodies []string
+	texts := []string{query}
+	for rows.Next() {
+		var e JournalExcerpt
+		var body string
+		if err := rows.Scan(&e.JournalID, &e.Title, &body, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+		bodies = append(bodies, body)
+		texts = append(texts, truncateText(e.Title+". "+body, 8000))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := r.embedder.Embed(ctx, texts)
+	if err != nil {
+		log.Printf("Error embedding journals: %v", err)
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+
+	scores := make([]float64, len(entries))
+	order := make([]int, len(entries))
+	for i := range entries {
+		scores[i] = cosineSimilarity(vectors[0], vectors[i+1])
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	keywords := chatKeywords(query)
+	var excerpts []JournalExcerpt
+	for _, i := range order {
+		if len(excerpts) == limit {
+			break
+		}
+		e := entries[i]
+		e.Excerpt = chatContextExcerpt(bodies[i], keywords, chatContextExcerptLength)
+		excerpts = append(excerpts, e)
+	}
+	return excerpts, nil
+}
+
+// JournalRetrieverFromEnv picks the retriever named by
+// CHAT_CONTEXT_RETRIEVER: "keyword" (the default) or "embedding", which
+// uses the OpenAI embeddings API with OPENAI_EMBEDDING_MODEL
+func JournalRetrieverFromEnv(db *sql.DB) (JournalRetriever, error) {
+	switch name := strings.ToLower(os.Getenv("CHAT_CONTEXT_RETRIEVER")); name {
+	case "", "keyword":
+		return NewKeywordJournalRetriever(db), nil
+	case "embedding":
+		embedder := NewOpenAIEmbedder(OpenAIConfig{
+			APIKey:  os.Getenv("OPENAI_API_KEY"),
+			BaseURL: os.Getenv("OPENAI_BASE_URL"),
+			Model:   os.Getenv("OPENAI_EMBEDDING_MODEL"),
+		})
+		return NewEmbeddingJournalRetriever(db, embedder, 50), nil
+	default:
+		return nil, fmt.Errorf("unknown CHAT_CONTEXT_RETRIEVER %q", name)
+	}
+}
+
+// ChatContextMood is a mood entry as shared with the chat provider
+type ChatContextMood struct {
+	MoodID    int       `json:"mood_id"`
+	Mood      string    `json:"mood"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatContext is the summary of a user's moods and journals added to the
+// system prompt
+type ChatContext struct {
+	Moods    []ChatContextMood `json:"moods"`
+	Journals []JournalExcerpt  `json:"journals"`
+	Content  string            `json:"content"` // the text added to the prompt
+}
+
+// formatChatContext writes the summary added to the system prompt, with
+// dates in loc
+func formatChatContext(moods []ChatContextMood, journals []JournalExcerpt, loc *time.Location) string {
+	var b strings.Builder
+	b.WriteString(chatContextHeader)
+	if len(moods) > 0 {
+		b.WriteString("\n\nRecent moods:")
+		for _, m := range moods {
+			fmt.Fprintf(&b, "\n- %s: %s", m.CreatedAt.In(loc).Format("Mon 2 Jan"), m.Mood)
+			if m.Note != "" {
+				fmt.Fprintf(&b, " (%s)", m.Note)
+			}
+		}
+	}
+	if len(journals) > 0 {
+		b.WriteString("\n\nRelated journal entries:")
+		for _, j := range journals {
+			fmt.Fprintf(&b, "\n- %q, %s: %s", j.Title, j.CreatedAt.In(loc).Format("2 Jan 2006"), j.Excerpt)
+		}
+	}
+	return b.String()
+}
+
+// ChatContextShare records the context sent with one reply
+type ChatContextShare struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	MessageID      *int      `json:"message_id"`
+	Content        string    `json:"content"`
+	MoodIDs        []int64   `json:"mood_ids"`
+	JournalIDs     []int64   `json:"journal_ids"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ChatContextService builds the context shared with the chat provider for
+// users who opted in, and records what was shared
+type ChatContextService struct {
+	db        *sql.DB
+	retriever JournalRetriever
+	now       func() time.Time
+}
+
+// NewChatContextService creates a context service finding journal entries
+// with retriever
+func NewChatContextService(db *sql.DB, retriever JournalRetriever) *ChatContextService {
+	return &ChatContextService{db: db, retriever: retriever, now: time.Now}
+}
+
+// Build summarizes userID's recent moods and the journal entries relevant
+// to message. It returns nil when there is nothing to share.
+func (s *ChatContextService) Build(ctx context.Context, userID int, message string, loc *time.Location) (*ChatContext, error) {
+	query := `
+		SELECT id, COALESCE(mood, ''), COALESCE(note, ''), created_at
+		FROM moods WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC LIMIT $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID, s.now().AddDate(0, 0, -chatContextMoodDays), chatContextMaxMoods)
+	if err != nil {
+		log.Printf("Error loading moods for chat context: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	shared := &ChatContext{Moods: []ChatContextMood{}, Journals: []JournalExcerpt{}}
+	for rows.Next() {
+		var m ChatContextMood
+		if err := rows.Scan(&m.MoodID, &m.Mood, &m.Note, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Note = truncateText(m.Note, chatContextMoodNoteLength)
+		shared.Moods = append(shared.Moods, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	journals, err := s.retriever.Retrieve(ctx, userID, message, chatContextMaxJournals)
+	if err != nil {
+		return nil, err
+	}
+	shared.Journals = append(shared.Journals, journals...)
+
+	if len(shared.Moods) == 0 && len(shared.Journals) == 0 {
+		return nil, nil
+	}
+	shared.Content = formatChatContext(shared.Moods, shared.Journals, loc)
+	return shared, nil
+}
+
+// Record stores the context sent with the reply messageID, or with a
+// request that got no reply when messageID is 0. shared.Content should be
+// the text as the provider received it, after redaction.
+func (s *ChatContextService) Record(userID, conversationID, messageID int, shared *ChatContext) error {
+	moodIDs := make([]int64, len(shared.Moods))
+	for i, m := range shared.Moods {
+		moodIDs[i] = int64(m.MoodID)
+	}
+	journalIDs := make([]int64, len(shared.Journals))
+	for i, j := range shared.Journals {
+		journalIDs[i] = int64(j.JournalID)
+	}
+
+	query := `
+		INSERT INTO chat_context_shares (user_id, conversation_id, message_id, content, mood_ids, journal_ids)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
+	`
+	_, err := s.db.Exec(query, userID, conversationID, messageID, shared.Content, pq.Array(moodIDs), pq.Array(journalIDs))
+	if err != nil {
+		log.Printf("Error recording chat context: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListForConversation returns what was shared in a conversation, oldest
+// first
+func (s *ChatContextService) ListForConversation(conversationID int) ([]ChatContextShare, error) {
+	query := `
+		SELECT id, conversation_id, message_id, content, mood_ids, journal_ids, created_at
+		FROM chat_context_shares WHERE conversation_id = $1
+		ORDER BY id
+	`
+	rows, err := s.db.Query(query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []ChatContextShare{}
+	for rows.Next() {
+		var share ChatContextShare
+		if err := rows.Scan(&share.ID, &share.ConversationID, &share.MessageID, &share.Content, pq.Array(&share.MoodIDs), pq.Array(&share.JournalIDs), &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	return shares, rows.Err()
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestChatKeywords verifies common words are left out of journal searches
+func TestChatKeywords(t *testing.T) {
+	got := chatKeywords("I don't know why I feel so anxious about work and my Mum's visit, work is hard")
+	want := []string{"anxious", "work", "mum", "visit", "hard"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestChatContextExcerpt verifies excerpts are short and centred on a keyword
+func TestChatContextExcerpt(t *testing.T) {
+	if got := chatContextExcerpt("Short  entry\nabout work", []string{"work"}, 100); got != "Short entry about work" {
+		t.Errorf("Expected a short entry whole, got %q", got)
+	}
+
+	body := strings.Repeat("filler words here ", 20) + "the interview went badly " + strings.Repeat("more filler text ", 20)
+	got := chatContextExcerpt(body, []string{"interview"}, 80)
+	if !strings.Contains(got, "interview") || !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("Expected an excerpt around the keyword, got %q", got)
+	}
+	if len(got) > 80+2*len("…") {
+		t.Errorf("Expected at most 80 bytes of text, got %d", len(got))
+	}
+
+	if got := chatContextExcerpt(body, []string{"holiday"}, 40); !strings.HasPrefix(got, "filler words") {
+		t.Errorf("Expected the start of the entry without a keyword, got %q", got)
+	}
+}
+
+// TestFormatChatContext verifies the summary added to the system prompt
+func TestFormatChatContext(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	moods := []ChatContextMood{
+		{Mood: "anxious", Note: "big meeting", CreatedAt: time.Date(2025, 3, 9, 23, 0, 0, 0, time.UTC)},
+		{Mood: "calm", CreatedAt: time.Date(2025, 3, 8, 12, 0, 0, 0, time.UTC)},
+	}
+	journals := []JournalExcerpt{
+		{Title: "Work", Excerpt: "…the meeting went fine…", CreatedAt: time.Date(2025, 3, 7, 12, 0, 0, 0, time.UTC)},
+	}
+
+	want := chatContextHeader + "\n\nRecent moods:\n- Mon 10 Mar: anxious (big meeting)\n- Sat 8 Mar: calm" +
+		"\n\nRelated journal entries:\n- \"Work\", 7 Mar 2025: …the meeting went fine…"
+	if got := formatChatContext(moods, journals, loc); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestCosineSimilarity verifies embeddings are compared by direction
+func TestCosineSimilarity(t *testing.T) {
+	if got := cosineSimilarity([]float32{1, 0}, []float32{2, 0}); got < 0.999 {
+		t.Errorf("Expected parallel vectors to match, got %f", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, []float32{0, 1}); got != 0 {
+		t.Errorf("Expected orthogonal vectors not to match, got %f", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, nil); got != 0 {
+		t.Errorf("Expected a missing vector not to match, got %f", got)
+	}
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// maxChatMemoryLength is the longest fact the assistant can remember
+const maxChatMemoryLength = 300
+
+// chatMemoriesHeader introduces the user's memories in the system prompt
+const chatMemoriesHeader = "The user has asked you to remember:"
+
+// chatSummaryHeader introduces the summary of older turns in the system prompt
+const chatSummaryHeader = "Summary of the earlier conversation:"
+
+// ChatMemory is a fact the user approved for the assistant to remember
+// across conversations, such as a coping strategy that works for them
+type ChatMemory struct {
+	ID         int       `json:"id"`
+	Content    string    `json:"content"`
+	ToolCallID *int      `json:"tool_call_id"` // the call that proposed it
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ValidateMemory checks a fact to remember, returning an error message or ""
+func ValidateMemory(content string) string {
+	if content == "" {
+		return "Content is required"
+	}
+	if len(content) > maxChatMemoryLength {
+		return "Memories must be 300 characters or less"
+	}
+	return ""
+}
+
+// ChatMemoryService stores what the assistant remembers about each user
+type ChatMemoryService struct {
+	db *sql.DB
+}
+
+// NewChatMemoryService creates a new memory service
+func NewChatMemoryService(db *sql.DB) *ChatMemoryService {
+	return &ChatMemoryService{db: db}
+}
+
+// Add remembers content for userID. The content must have passed
+// ValidateMemory; toolCallID is the confirmed call that proposed it.
+func (s *ChatMemoryService) Add(userID int, content string, toolCallID int) (*ChatMemory, error) {
+	memory := ChatMemory{Content: content, ToolCallID: &toolCallID}
+	query := `INSERT INTO chat_memories (user_id, content, tool_call_id) VALUES ($1, $2, $3) RETURNING id, created_at`
+	if err := s.db.QueryRow(query, userID, content, toolCallID).Scan(&memory.ID, &memory.CreatedAt); err != nil {
+		log.Printf("Error storing chat memory: %v", err)
+		return nil, err
+	}
+	return &memory, nil
+}
+
+// List returns the user's memories, newest first
+func (s *ChatMemoryService) List(userID int) ([]ChatMemory, error) {
+	rows, err := s.db.Query(`SELECT id, content, tool_call_id, created_at FROM chat_memories WHERE user_id = $1 ORDER BY id DESC`, userID)
+	if err != nil {
+		log.Printf("Error listing chat memories: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	memories := []ChatMemory{}
+	for rows.Next() {
+		var m ChatMemory
+		if err := rows.Scan(&m.ID, &m.Content, &m.ToolCallID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		memories = append(memories, m)
+	}
+
+	return memories, rows.Err()
+}
+
+// Delete forgets one of the user's memories, reporting whether it existed
+func (s *ChatMemoryService) Delete(userID, memoryID int) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM chat_memories WHERE id = $1 AND user_id = $2`, memoryID, userID)
+	if err != nil {
+		log.Printf("Error deleting chat memory: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// Prompt loads what goes with a new message in conversationID: the user's
+// memories, the conversation's summary and its recent turns, fitted within
+// budget tokens
+func (s *ChatMemoryService) Prompt(userID, conversationID, budget int) (*ChatPrompt, error) {
+	memories, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := loadConversationSummary(s.db, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := NewConversationService(s.db).History(conversationID, budget)
+	if err != nil {
+		return nil, err
+	}
+	prompt := FitChatPrompt(budget, memories, summary, history)
+	return &prompt, nil
+}
+
+// ChatPrompt is what is sent along with a new message besides the system
+// prompt
+type ChatPrompt struct {
+	Memories []ChatMemory
+	Summary  string // of the turns before History
+	History  []models.Message
+}
+
+// FitChatPrompt fits memories, the conversation summary and the latest
+// turns within budget tokens. Memories and the summary may each use up to
+// a quarter of it, keeping the newest memories; turns the summary covers are
+// left out, and the newest of the rest fill what remains. memories must be
+// newest first and messages in chronological order.
+func FitChatPrompt(budget int, memories []ChatMemory, summary *ConversationSummary, messages []models.Message) ChatPrompt {
+	var prompt ChatPrompt
+	used := 0
+
+	for _, m := range memories {
+		cost := EstimateTokens(m.Content) + 1
+		if used+cost > budget/4 {
+			break
+		}
+		used += cost
+		prompt.Memories = append(prompt.Memories, m)
+	}
+
+	if summary != nil {
+		for len(messages) > 0 && messages[0].ID <= summary.ThroughMessageID {
+			messages = messages[1:]
+		}
+		prompt.Summary = summary.Content
+		if EstimateTokens(prompt.Summary) > budget/4 {
+			prompt.Summary = truncateText(prompt.Summary, budget) // about a quarter of budget in tokens
+		}
+		used += EstimateTokens(prompt.Summary)
+	}
+
+	prompt.History = fitHistory(messages, budget-used)
+	return prompt
+}
+
+// SystemContext formats the memories and summary for the system prompt,
+// or returns "" if there are neither
+func (p *ChatPrompt) SystemContext() string {
+	var sections []string
+	if len(p.Memories) > 0 {
+		lines := []string{chatMemoriesHeader}
+		for _, m := range p.Memories {
+			lines = append(lines, "- "+m.Content)
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+	if p.Summary != "" {
+		sections = append(sections, chatSummaryHeader+"\n"+p.Summary)
+	}
+	return strings.Join(sections, "\n\n")
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// TestValidateMemory verifies memories must be short and not empty
+func TestValidateMemory(t *testing.T) {
+	if msg := ValidateMemory("Walking helps"); msg != "" {
+		t.Errorf("Expected a valid memory, got %q", msg)
+	}
+	if msg := ValidateMemory(""); msg == "" {
+		t.Error("Expected an empty memory to be rejected")
+	}
+	if msg := ValidateMemory(strings.Repeat("a", maxChatMemoryLength+1)); msg == "" {
+		t.Error("Expected a long memory to be rejected")
+	}
+}
+
+// TestFitChatPrompt verifies memories, the summary and recent turns share
+// the budget
+func TestFitChatPrompt(t *testing.T) {
+	messages := []models.Message{
+		{ID: 1, Role: "user", Content: strings.Repeat("a", 40)},      // 10 tokens + overhead
+		{ID: 2, Role: "assistant", Content: strings.Repeat("b", 40)}, // summarized
+		{ID: 3, Role: "user", Content: strings.Repeat("c", 40)},
+		{ID: 4, Role: "assistant", Content: strings.Repeat("d", 40)},
+	}
+	memories := []ChatMemory{
+		{ID: 3, Content: strings.Repeat("m", 36)}, // 9 tokens + 1
+		{ID: 2, Content: strings.Repeat("n", 36)},
+		{ID: 1, Content: strings.Repeat("o", 36)}, // over a quarter of the budget
+	}
+	summary := &ConversationSummary{Content: strings.Repeat("s", 40), ThroughMessageID: 2}
+
+	prompt := FitChatPrompt(80, memories, summary, messages)
+	if len(prompt.Memories) != 2 || prompt.Memories[0].ID != 3 {
+		t.Errorf("Expected the two newest memories, got %+v", prompt.Memories)
+	}
+	if prompt.Summary != summary.Content {
+		t.Errorf("Expected the summary, got %q", prompt.Summary)
+	}
+	if len(prompt.History) != 2 || prompt.History[0].ID != 3 {
+		t.Errorf("Expected the unsummarized turns, got %+v", prompt.History)
+	}
+
+	// Without a summary the newest turns fill what the memories leave
+	prompt = FitChatPrompt(80, memories, nil, messages)
+	if len(prompt.History) != 4 {
+		t.Errorf("Expected every turn, got %+v", prompt.History)
+	}
+	prompt = FitChatPrompt(60, nil, nil, messages)
+	if len(prompt.History) != 4 || prompt.Memories != nil {
+		t.Errorf("Expected every turn and no memories, got %+v", prompt)
+	}
+	prompt = FitChatPrompt(40, nil, nil, messages)
+	if len(prompt.History) != 2 || prompt.History[0].ID != 3 {
+		t.Errorf("Expected the newest turns, got %+v", prompt.History)
+	}
+
+	// A summary longer than its share is shortened
+	long := &ConversationSummary{Content: strings.Repeat("word ", 100), ThroughMessageID: 4}
+	prompt = FitChatPrompt(80, nil, long, messages)
+	if EstimateTokens(prompt.Summary) > 21 || len(prompt.History) != 0 {
+		t.Errorf("Expected a shortened summary and no turns, got %+v", prompt)
+	}
+}
+
+// TestChatPromptSystemContext verifies how memories and the summary are
+// introduced
+func TestChatPromptSystemContext(t *testing.T) {
+	if got := (&ChatPrompt{}).SystemContext(); got != "" {
+		t.Errorf("Expected no context, got %q", got)
+	}
+
+	prompt := ChatPrompt{
+		Memories: []ChatMemory{{Content: "Walking helps"}, {Content: "Prefers short replies"}},
+		Summary:  "They talked about work stress.",
+	}
+	want := chatMemoriesHeader + "\n- Walking helps\n- Prefers short replies\n\n" + chatSummaryHeader + "\nThey talked about work stress."
+	if got := prompt.SystemContext(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPersonaNotFound is returned for personas that do not exist, or
+	// are inactive when picking one for a conversation
+	ErrPersonaNotFound = errors.New("persona not found")
+	// ErrPersonaSlugTaken is returned when creating a persona whose slug
+	// is in use
+	ErrPersonaSlugTaken = errors.New("persona slug already exists")
+)
+
+// personaSlugPattern is what a persona's slug may look like
+var personaSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ChatPersonaVersion is one revision of a persona's settings. Versions are
+// never changed, so each message can point at exactly what produced it.
+type ChatPersonaVersion struct {
+	ID           int       `json:"id"`
+	PersonaID    int       `json:"persona_id"`
+	Version      int       `json:"version"`
+	SystemPrompt string    `json:"system_prompt"`
+	Temperature  float32   `json:"temperature"`
+	MaxTokens    int       `json:"max_tokens"`
+	CreatedBy    *int      `json:"created_by"` // the admin who made it
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ChatPersona is a persona or mode of the assistant, such as a CBT coach,
+// with its newest version
+type ChatPersona struct {
+	ID          int                 `json:"id"`
+	Slug        string              `json:"slug"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Active      bool                `json:"active"`  // can be picked for conversations
+	IsDefault   bool                `json:"default"` // used when none is picked
+	Version     *ChatPersonaVersion `json:"version,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// ChatPersonaInput is what an admin sets on a persona
+type ChatPersonaInput struct {
+	Slug         string  `json:"slug"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Active       bool    `json:"active"`
+	IsDefault    bool    `json:"default"`
+	SystemPrompt string  `json:"system_prompt"`
+	Temperature  float32 `json:"temperature"`
+	MaxTokens    int     `json:"max_tokens"`
+}
+
+// ValidatePersona checks a persona's settings, returning an error message
+// or ""
+func ValidatePersona(input ChatPersonaInput) string {
+	switch {
+	case len(input.Slug) > 50 || !personaSlugPattern.MatchString(input.Slug):
+		return "Slug must be up to 50 lowercase letters, digits and single hyphens"
+	case input.Name == "" || len(input.Name) > 100:
+		return "Name is required and must be 100 characters or less"
+	case len(input.Description) > 500:
+		return "Description must be 500 characters or less"
+	case input.SystemPrompt == "" || len(input.SystemPrompt) > 8000:
+		return "System prompt is required and must be 8000 characters or less"
+	case input.Temperature <= 0 || input.Temperature > 2:
+		return "Temperature must be more than 0 and at most 2"
+	case input.MaxTokens < 1 || input.MaxTokens > 4000:
+		return "Max tokens must be between 1 and 4000"
+	case input.IsDefault && !input.Active:
+		return "The default persona must be active"
+	}
+	return ""
+}
+
+// Input returns the persona's current settings, to apply changes to
+func (p *ChatPersona) Input() ChatPersonaInput {
+	input := ChatPersonaInput{Slug: p.Slug, Name: p.Name, Description: p.Description, Active: p.Active, IsDefault: p.IsDefault}
+	if p.Version != nil {
+		input.SystemPrompt, input.Temperature, input.MaxTokens = p.Version.SystemPrompt, p.Version.Temperature, p.Version.MaxTokens
+	}
+	return input
+}
+
+// needsVersion reports whether input changes the versioned settings of p
+func (p *ChatPersona) needsVersion(input ChatPersonaInput) bool {
+	v := p.Version
+	return v == nil || v.SystemPrompt != input.SystemPrompt || v.Temperature != input.Temperature || v.MaxTokens != input.MaxTokens
+}
+
+// chatPersonaQuery selects personas with their newest version, read by
+// scanChatPersona
+const chatPersonaQuery = `
+	SELECT p.id, p.slug, p.name, p.description, p.active, p.is_default, p.created_at, p.updated_at,
+		v.id, v.version, v.system_prompt, v.temperature, v.max_tokens, v.created_by, v.created_at
+	FROM chat_personas p
+	JOIN LATERAL (
+		SELECT * FROM chat_persona_versions WHERE persona_id = p.id ORDER BY version DESC LIMIT 1
+	) v ON TRUE
+`
+
+// scanChatPersona reads a row of chatPersonaQuery
+func scanChatPersona(row interface{ Scan(...interface{}) error }) (*ChatPersona, error) {
+	var p ChatPersona
+	var v ChatPersonaVersion
+	err := row.Scan(&p.ID, &p.Slug, &p.Name, &p.Description, &p.Active, &p.IsDefault, &p.CreatedAt, &p.UpdatedAt,
+		&v.ID, &v.Version, &v.SystemPrompt, &v.Temperature, &v.MaxTokens, &v.CreatedBy, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	v.PersonaID = p.ID
+	p.Version = &v
+	return &p, nil
+}
+
+// ChatPersonaService manages the assistant's personas
+type ChatPersonaService struct {
+	db *sql.DB
+}
+
+// NewChatPersonaService creates a new persona service
+func NewChatPersonaService(db *sql.DB) *ChatPersonaService {
+	return &ChatPersonaService{db: db}
+}
+
+// List returns the personas, the default first, then by name. With
+// activeOnly only those users can pick are returned.
+func (s *ChatPersonaService) List(activeOnly bool) ([]ChatPersona, error) {
+	rows, err := s.db.Query(chatPersonaQuery+` WHERE p.active OR NOT $1 ORDER BY p.is_default DESC, p.name, p.id`, activeOnly)
+	if err != nil {
+		log.Printf("Error listing personas: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	personas := []ChatPersona{}
+	for rows.Next() {
+		p, err := scanChatPersona(rows)
+		if err != nil {
+			return nil, err
+		}
+		personas = append(personas, *p)
+	}
+
+	return personas, rows.Err()
+}
+
+// Get returns a persona, active or not
+func (s *ChatPersonaService) Get(personaID int) (*ChatPersona, error) {
+	p, err := scanChatPersona(s.db.QueryRow(chatPersonaQuery+` WHERE p.id = $1`, personaID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPersonaNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading persona: %v", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetActive returns a persona users can pick
+func (s *ChatPersonaService) GetActive(personaID int) (*ChatPersona, error) {
+	p, err := s.Get(personaID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Active {
+		return nil, ErrPersonaNotFound
+	}
+	return p, nil
+}
+
+// ForConversation returns the persona a conversation uses: the one it
+// picked while that is active, or else the default. It returns nil if
+// there is no active default.
+func (s *ChatPersonaService) ForConversation(personaID *int) (*ChatPersona, error) {
+	if personaID != nil {
+		p, err := s.GetActive(*personaID)
+		if err == nil {
+			return p, nil
+		}
+		if err != ErrPersonaNotFound {
+			return nil, err
+		}
+	}
+
+	p, err := scanChatPersona(s.db.QueryRow(chatPersonaQuery + ` WHERE p.is_default AND p.active`))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error loading default persona: %v", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// Create adds a persona with its first version, made by adminID. The input
+// must have passed ValidatePersona.
+func (s *ChatPersonaService) Create(input ChatPersonaInput, adminID int) (*ChatPersona, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if input.IsDefault {
+		if _, err := tx.Exec(`UPDATE chat_personas SET is_default = FALSE, updated_at = NOW() WHERE is_default`); err != nil {
+			log.Printf("Error clearing default persona: %v", err)
+			return nil, err
+		}
+	}
+
+	var personaID int
+	query := `INSERT INTO chat_personas (slug, name, description, active, is_default) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = tx.QueryRow(query, input.Slug, input.Name, input.Description, input.Active, input.IsDefault).Scan(&personaID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, ErrPersonaSlugTaken
+		}
+		log.Printf("Error creating persona: %v", err)
+		return nil, err
+	}
+
+	if err := addPersonaVersion(tx, personaID, 1, input, adminID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(personaID)
+}
+
+// Update applies input to a persona on behalf of adminID. A changed prompt,
+// temperature or max tokens adds a version; earlier versions are kept. The
+// slug cannot be changed. The input must have passed ValidatePersona.
+func (s *ChatPersonaService) Update(personaID int, input ChatPersonaInput, adminID int) (*ChatPersona, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the persona keeps concurrent edits from racing for a version
+	// number
+	current, err := scanChatPersona(tx.QueryRow(chatPersonaQuery+` WHERE p.id = $1 FOR UPDATE OF p`, personaID))
+	if err == sql.ErrNoRows {
+		return nil, ErrPersonaNotFound
+	}
+	if err != nil {
+		log.Printf("Error loading persona: %v", err)
+		return nil, err
+	}
+
+	if input.IsDefault && !current.IsDefault {
+		if _, err := tx.Exec(`UPDATE chat_personas SET is_default = FALSE, updated_at = NOW() WHERE is_default`); err != nil {
+			log.Printf("Error clearing default persona: %v", err)
+			return nil, err
+		}
+	}
+
+	query := `
+		UPDATE chat_personas SET name = $2, description = $3, active = $4, is_default = $5, updated_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, personaID, input.Name, input.Description, input.Active, input.IsDefault); err != nil {
+		log.Printf("Error updating persona: %v", err)
+		return nil, err
+	}
+
+	if current.needsVersion(input) {
+		if err := addPersonaVersion(tx, personaID, current.Version.Version+1, input, adminID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(personaID)
+}
+
+// addPersonaVersion stores a version of a persona's settings
+func addPersonaVersion(tx *sql.Tx, personaID, version int, input ChatPersonaInput, adminID int) error {
+	query := `
+		INSERT INTO chat_persona_versions (persona_id, version, system_prompt, temperature, max_tokens, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := tx.Exec(query, personaID, version, input.SystemPrompt, input.Temperature, input.MaxTokens, adminID); err != nil {
+		log.Printf("Error storing persona version: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Versions returns every version of a persona, newest first
+func (s *ChatPersonaService) Versions(personaID int) ([]ChatPersonaVersion, error) {
+	query := `
+		SELECT id, persona_id, version, system_prompt, temperature, max_tokens, created_by, created_at
+		FROM chat_persona_versions WHERE persona_id = $1
+		ORDER BY version DESC
+	`
+	rows, err := s.db.Query(query, personaID)
+	if err != nil {
+		log.Printf("Error listing persona versions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ChatPersonaVersion{}
+	for rows.Next() {
+		var v ChatPersonaVersion
+		if err := rows.Scan(&v.ID, &v.PersonaID, &v.Version, &v.SystemPrompt, &v.Temperature, &v.MaxTokens, &v.CreatedBy, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidatePersona verifies each persona setting is checked
+func TestValidatePersona(t *testing.T) {
+	valid := ChatPersonaInput{
+		Slug:         "cbt-coach",
+		Name:         "CBT coach",
+		Active:       true,
+		SystemPrompt: "You are a coach.",
+		Temperature:  0.5,
+		MaxTokens:    800,
+	}
+	if msg := ValidatePersona(valid); msg != "" {
+		t.Fatalf("Expected a valid persona, got %q", msg)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*ChatPersonaInput)
+	}{
+		{"uppercase slug", func(p *ChatPersonaInput) { p.Slug = "CBT" }},
+		{"hyphen at the end", func(p *ChatPersonaInput) { p.Slug = "cbt-" }},
+		{"long slug", func(p *ChatPersonaInput) { p.Slug = strings.Repeat("a", 51) }},
+		{"no name", func(p *ChatPersonaInput) { p.Name = "" }},
+		{"long description", func(p *ChatPersonaInput) { p.Description = strings.Repeat("a", 501) }},
+		{"no prompt", func(p *ChatPersonaInput) { p.SystemPrompt = "" }},
+		{"zero temperature", func(p *ChatPersonaInput) { p.Temperature = 0 }},
+		{"high temperature", func(p *ChatPersonaInput) { p.Temperature = 2.5 }},
+		{"no max tokens", func(p *ChatPersonaInput) { p.MaxTokens = 0 }},
+		{"inactive default", func(p *ChatPersonaInput) { p.IsDefault, p.Active = true, false }},
+	}
+
+	for _, tt := range tests {
+		input := valid
+		tt.change(&input)
+		if msg := ValidatePersona(input); msg == "" {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+// TestChatPersonaNeedsVersion verifies only the prompt, temperature and
+// max tokens are versioned
+func TestChatPersonaNeedsVersion(t *testing.T) {
+	persona := &ChatPersona{
+		Slug:    "cbt-coach",
+		Name:    "CBT coach",
+		Active:  true,
+		Version: &ChatPersonaVersion{Version: 2, SystemPrompt: "You are a coach.", Temperature: 0.5, MaxTokens: 800},
+	}
+
+	input := persona.Input()
+	if persona.needsVersion(input) {
+		t.Error("Expected no new version for unchanged settings")
+	}
+	input.Name, input.Active = "Thought coach", false
+	if persona.needsVersion(input) {
+		t.Error("Expected no new version for a new name")
+	}
+	input.Temperature = 0.7
+	if !persona.needsVersion(input) {
+		t.Error("Expected a new version for a new temperature")
+	}
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// MessageRoleTool is the role of a message carrying a tool's result
+const MessageRoleTool = "tool"
+
+// ChatMessage is one message of a prompt. Role is "system", "user",
+// "assistant" or "tool".
+type ChatMessage struct {
+	Role       string
+	Content    string
+	ToolCalls  []ChatToolCall // the tools an assistant message called
+	ToolCallID string         // the call a tool message answers
+}
+
+// ChatTool is a function the model may call
+type ChatTool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage // JSON Schema of the arguments
+}
+
+// ChatToolCall is the model asking for a tool to be run
+type ChatToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON
+}
+
+// ChatRequest is a prompt for a chat provider
+type ChatRequest struct {
+	Messages    []ChatMessage
+	MaxTokens   int
+	Temperature float32    // 0 leaves it to the provider
+	Tools       []ChatTool // may be ignored by providers without tool support
+}
+
+// ChatUsage is the token usage of one reply, when the provider reports it
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatReply is a provider's answer
+type ChatReply struct {
+	Content   string
+	ToolCalls []ChatToolCall // tools to run before the model can answer
+	Usage     *ChatUsage
+	Provider  string // name of the provider that answered
+}
+
+// ChatProvider generates assistant replies
+type ChatProvider interface {
+	// Name identifies the provider in logs and responses
+	Name() string
+	// Complete returns the whole reply at once
+	Complete(ctx context.Context, req ChatRequest) (*ChatReply, error)
+	// Stream calls onDelta with each piece of the reply as it arrives and
+	// returns the complete reply. An error from onDelta stops the stream. On
+	// error the reply, when not nil, holds what arrived before the failure.
+	Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error)
+}
+
+// lastUserMessage returns the content of the newest user message
+func lastUserMessage(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == MessageRoleUser {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// RuleBasedChatProvider answers with canned, keyword-matched replies. It
+// needs no network, so it serves as the fallback when the model is down.
+type RuleBasedChatProvider struct{}
+
+// Name implements ChatProvider
+func (RuleBasedChatProvider) Name() string { return "rules" }
+
+// Complete implements ChatProvider
+func (p RuleBasedChatProvider) Complete(ctx context.Context, req ChatRequest) (*ChatReply, error) {
+	return &ChatReply{Content: ruleBasedReply(lastUserMessage(req.Messages)), Provider: p.Name()}, nil
+}
+
+// Stream implements ChatProvider; the reply is sent as a single delta
+func (p RuleBasedChatProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	reply, _ := p.Complete(ctx, req)
+	if err := onDelta(reply.Content); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// ruleBasedReply picks a reply by the feelings mentioned in input
+func ruleBasedReply(input string) string {
+	lower := strings.ToLower(input)
+	if containsAny(lower, []string{"sad", "down", "bad", "low"}) {
+		return "I'm sorry you're feeling that way. Would you like to journal about it?"
+	} else if containsAny(lower, []string{"happy", "great", "good", "awesome"}) {
+		return "That's wonderful! I'm glad to hear it!"
+	}
+	return "Thanks for sharing. I'm here to listen anytime."
+}
+
+// containsAny checks if any of the substrings exist in s
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// FakeChatProvider returns scripted replies and records the requests it
+// receives. It is meant for tests.
+type FakeChatProvider struct {
+	mu        sync.Mutex
+	Replies   []string         // returned in order; the last one repeats
+	Errors    []error          // returned before any reply, one per call
+	ToolCalls [][]ChatToolCall // returned with the replies, one set per call
+	Requests  []ChatRequest
+}
+
+// Name implements ChatProvider
+func (p *FakeChatProvider) Name() string { return "fake" }
+
+// Complete implements ChatProvider
+func (p *FakeChatProvider) Complete(ctx context.Context, req ChatRequest) (*ChatReply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Requests = append(p.Requests, req)
+	if len(p.Errors) > 0 {
+		err := p.Errors[0]
+		p.Errors = p.Errors[1:]
+		return nil, err
+	}
+
+	content := "Fake reply"
+	if len(p.Replies) > 0 {
+		content = p.Replies[0]
+		if len(p.Replies) > 1 {
+			p.Replies = p.Replies[1:]
+		}
+	}
+	var toolCalls []ChatToolCall
+	if len(p.ToolCalls) > 0 {
+		toolCalls = p.ToolCalls[0]
+		p.ToolCalls = p.ToolCalls[1:]
+	}
+	return &ChatReply{
+		Content:   content,
+		ToolCalls: toolCalls,
+		Usage:     &ChatUsage{CompletionTokens: EstimateTokens(content), TotalTokens: EstimateTokens(content)},
+		Provider:  p.Name(),
+	}, nil
+}
+
+// Stream implements ChatProvider, sending the reply one word at a time
+func (p *FakeChatProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	reply, err := p.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for i, word := range strings.Fields(reply.Content) {
+		if i > 0 {
+			word = " " + word
+		}
+		if err := onDelta(word); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// ResilientChatProvider retries a provider on transient failures, bounds
+// each attempt with a timeout and, if the provider still fails, answers with
+// a fallback provider instead
+type ResilientChatProvider struct {
+	primary  ChatProvider
+	fallback ChatProvider // nil for no fallback
+	retries  int
+	timeout  time.Duration
+	backoff  time.Duration // doubled after each failed attempt
+}
+
+// NewResilientChatProvider wraps primary. timeout bounds a Complete call,
+// and the wait for the first piece of a streamed reply.
+func NewResilientChatProvider(primary, fallback ChatProvider, retries int, timeout time.Duration) *ResilientChatProvider {
+	return &ResilientChatProvider{
+		primary:  primary,
+		fallback: fallback,
+		retries:  retries,
+		timeout:  timeout,
+		backoff:  500 * time.Millisecond,
+	}
+}
+
+// Name implements ChatProvider
+func (p *ResilientChatProvider) Name() string { return p.primary.Name() }
+
+// Complete implements ChatProvider
+func (p *ResilientChatProvider) Complete(ctx context.Context, req ChatRequest) (*ChatReply, error) {
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 && !p.wait(ctx, attempt) {
+			break
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		var reply *ChatReply
+		reply, err = p.primary.Complete(attemptCtx, req)
+		cancel()
+		if err == nil {
+			return reply, nil
+		}
+		if ctx.Err() != nil || !isRetryableChatError(err) {
+			break
+		}
+	}
+
+	if p.fallback == nil || ctx.Err() != nil {
+		return nil, err
+	}
+	log.Printf("Chat provider %s failed, falling back to %s: %v", p.primary.Name(), p.fallback.Name(), err)
+	return p.fallback.Complete(ctx, req)
+}
+
+// Stream implements ChatProvider. A stream is only retried, or handed to
+// the fallback, if nothing has been sent to onDelta yet.
+func (p *ResilientChatProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 && !p.wait(ctx, attempt) {
+			break
+		}
+
+		attemptCtx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(p.timeout, cancel)
+		started := false
+
+		var reply *ChatReply
+		reply, err = p.primary.Stream(attemptCtx, req, func(delta string) error {
+			if !started {
+				started = true
+				timer.Stop()
+			}
+			return onDelta(delta)
+		})
+		timer.Stop()
+		cancel()
+
+		if err == nil || started {
+			return reply, err
+		}
+		if ctx.Err() != nil || !isRetryableChatError(err) {
+			break
+		}
+	}
+
+	if p.fallback == nil || ctx.Err() != nil {
+		return nil, err
+	}
+	log.Printf("Chat provider %s failed, falling back to %s: %v", p.primary.Name(), p.fallback.Name(), err)
+	return p.fallback.Stream(ctx, req, onDelta)
+}
+
+// wait sleeps before a retry, reporting false if ctx ends first
+func (p *ResilientChatProvider) wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(p.backoff << (attempt - 1))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// isRetryableChatError reports whether a failed request may succeed if sent
+// again: timeouts, network errors, rate limiting and server errors
+func isRetryableChatError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == 429 || apiErr.HTTPStatusCode >= 500
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == 429 || reqErr.HTTPStatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ChatProviderFromEnv builds the chat provider from CHAT_PROVIDER: "openai"
+// (the default when OPENAI_API_KEY or OPENAI_BASE_URL is set), "rules" or
+// "fake". The OpenAI provider uses OPENAI_MODEL and OPENAI_BASE_URL (any
+// OpenAI-compatible server), is retried CHAT_RETRIES times (default 2) with
+// a CHAT_TIMEOUT per attempt (default 30s), and falls back to the
+// rule-based provider unless CHAT_FALLBACK=none.
+func ChatProviderFromEnv() (ChatProvider, error) {
+	name := strings.ToLower(os.Getenv("CHAT_PROVIDER"))
+	if name == "" {
+		name = "rules"
+		if os.Getenv("OPENAI_API_KEY") != "" || os.Getenv("OPENAI_BASE_URL") != "" {
+			name = "openai"
+		}
+	}
+
+	switch name {
+	case "rules":
+		return RuleBasedChatProvider{}, nil
+	case "fake":
+		return &FakeChatProvider{}, nil
+	case "openai":
+	default:
+		return nil, fmt.Errorf("unknown CHAT_PROVIDER %q", name)
+	}
+
+	retries := 2
+	if n, err := strconv.Atoi(os.Getenv("CHAT_RETRIES")); err == nil && n >= 0 {
+		retries = n
+	}
+	timeout := 30 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("CHAT_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+
+	var fallback ChatProvider = RuleBasedChatProvider{}
+	switch strings.ToLower(os.Getenv("CHAT_FALLBACK")) {
+	case "", "rules":
+	case "none":
+		fallback = nil
+	default:
+		return nil, fmt.Errorf("unknown CHAT_FALLBACK %q", os.Getenv("CHAT_FALLBACK"))
+	}
+
+	primary := NewOpenAIChatProvider(OpenAIConfig{
+		APIKey:  os.Getenv("OPENAI_API_KEY"),
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+		Model:   os.Getenv("OPENAI_MODEL"),
+	})
+	return NewResilientChatProvider(primary, fallback, retries, timeout), nil
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// TestRuleBasedChatProvider verifies replies follow the last user message
+func TestRuleBasedChatProvider(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"I feel so SAD today", "I'm sorry you're feeling that way. Would you like to journal about it?"},
+		{"Had a great day", "That's wonderful! I'm glad to hear it!"},
+		{"Just checking in", "Thanks for sharing. I'm here to listen anytime."},
+	}
+
+	for _, tt := range tests {
+		req := ChatRequest{Messages: []ChatMessage{
+			{Role: "system", Content: "Be happy"},
+			{Role: MessageRoleUser, Content: tt.message},
+		}}
+		reply, err := RuleBasedChatProvider{}.Complete(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Content != tt.want || reply.Provider != "rules" {
+			t.Errorf("%q: expected %q, got %+v", tt.message, tt.want, reply)
+		}
+	}
+}
+
+// newTestResilientProvider wraps primary without waiting between retries
+func newTestResilientProvider(primary, fallback ChatProvider, retries int) *ResilientChatProvider {
+	p := NewResilientChatProvider(primary, fallback, retries, time.Second)
+	p.backoff = time.Millisecond
+	return p
+}
+
+// TestResilientChatProviderRetries verifies transient errors are retried
+func TestResilientChatProviderRetries(t *testing.T) {
+	primary := &FakeChatProvider{
+		Replies: []string{"Hello"},
+		Errors:  []error{&openai.APIError{HTTPStatusCode: 503}, context.DeadlineExceeded},
+	}
+	p := newTestResilientProvider(primary, RuleBasedChatProvider{}, 2)
+
+	reply, err := p.Complete(context.Background(), ChatRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Hello" || reply.Provider != "fake" {
+		t.Errorf("Expected the primary's reply, got %+v", reply)
+	}
+	if len(primary.Requests) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", len(primary.Requests))
+	}
+}
+
+// TestResilientChatProviderFallback verifies the fallback answers once the
+// primary gives up, and that client errors are not retried
+func TestResilientChatProviderFallback(t *testing.T) {
+	primary := &FakeChatProvider{Errors: []error{&openai.APIError{HTTPStatusCode: 401}}}
+	p := newTestResilientProvider(primary, RuleBasedChatProvider{}, 2)
+
+	reply, err := p.Complete(context.Background(), ChatRequest{Messages: []ChatMessage{{Role: MessageRoleUser, Content: "good news"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Provider != "rules" {
+		t.Errorf("Expected the fallback to answer, got %+v", reply)
+	}
+	if len(primary.Requests) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(primary.Requests))
+	}
+}
+
+// TestResilientChatProviderNoFallback verifies the error is returned when
+// there is no fallback
+func TestResilientChatProviderNoFallback(t *testing.T) {
+	upstreamErr := &openai.APIError{HTTPStatusCode: 500}
+	primary := &FakeChatProvider{Errors: []error{upstreamErr, upstreamErr}}
+	p := newTestResilientProvider(primary, nil, 1)
+
+	if _, err := p.Complete(context.Background(), ChatRequest{}); !errors.Is(err, upstreamErr) {
+		t.Errorf("Expected the upstream error, got %v", err)
+	}
+}
+
+// failingStreamProvider sends some text, then fails
+type failingStreamProvider struct{ FakeChatProvider }
+
+func (p *failingStreamProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	if err := onDelta("Partial"); err != nil {
+		return nil, err
+	}
+	return &ChatReply{Content: "Partial", Provider: "fake"}, &openai.APIError{HTTPStatusCode: 502}
+}
+
+// TestResilientChatProviderStreamStarted verifies a stream is not retried
+// or replaced once text has been sent
+func TestResilientChatProviderStreamStarted(t *testing.T) {
+	p := newTestResilientProvider(&failingStreamProvider{}, RuleBasedChatProvider{}, 2)
+
+	var deltas []string
+	reply, err := p.Stream(context.Background(), ChatRequest{}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected the stream error")
+	}
+	if reply == nil || reply.Content != "Partial" || len(deltas) != 1 {
+		t.Errorf("Expected only the partial reply, got %+v and %v", reply, deltas)
+	}
+}
+
+// TestResilientChatProviderStreamFallback verifies a stream that fails
+// before sending anything falls back
+func TestResilientChatProviderStreamFallback(t *testing.T) {
+	primary := &FakeChatProvider{Errors: []error{errors.New("connection refused")}}
+	p := newTestResilientProvider(primary, RuleBasedChatProvider{}, 2)
+
+	var text strings.Builder
+	reply, err := p.Stream(context.Background(), ChatRequest{}, func(delta string) error {
+		text.WriteString(delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Provider != "rules" || text.String() != reply.Content {
+		t.Errorf("Expected the fallback's reply to be streamed, got %+v and %q", reply, text.String())
+	}
+}
+
+// TestOpenAIChatProvider verifies requests go to the configured base URL
+// and model, and streamed replies are assembled
+func TestOpenAIChatProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"model":"local-model"`) {
+			http.Error(w, "wrong model", 400)
+			return
+		}
+
+		if strings.Contains(string(body), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, delta := range []string{"Hel", "lo"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	defer server.Close()
+
+	p := NewOpenAIChatProvider(OpenAIConfig{BaseURL: server.URL + "/v1/", Model: "local-model"})
+	req := ChatRequest{Messages: []ChatMessage{{Role: MessageRoleUser, Content: "Hi"}}}
+
+	reply, err := p.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Hello" || reply.Usage == nil || reply.Usage.TotalTokens != 6 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+
+	var deltas []string
+	reply, err = p.Stream(context.Background(), req, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Hello" || len(deltas) != 2 || reply.Usage == nil || reply.Usage.TotalTokens != 7 {
+		t.Errorf("Unexpected streamed reply %+v, deltas %v", reply, deltas)
+	}
+}
+
+// TestOpenAIChatProviderToolCalls verifies tools and tool results are sent
+// and streamed tool calls are assembled from their pieces
+func TestOpenAIChatProviderToolCalls(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"log_mood","arguments":""}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"mood\":"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"sad\"}"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	p := NewOpenAIChatProvider(OpenAIConfig{BaseURL: server.URL})
+	req := ChatRequest{
+		Messages: []ChatMessage{
+			{Role: MessageRoleUser, Content: "How was my week?"},
+			{Role: MessageRoleAssistant, ToolCalls: []ChatToolCall{{ID: "call_0", Name: "get_mood_summary", Arguments: "{}"}}},
+			{Role: MessageRoleTool, ToolCallID: "call_0", Content: `{"days":7}`},
+		},
+		Tools: chatTools,
+	}
+
+	reply, err := p.Stream(context.Background(), req, func(string) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ChatToolCall{{ID: "call_1", Name: "log_mood", Arguments: `{"mood":"sad"}`}}
+	if fmt.Sprint(reply.ToolCalls) != fmt.Sprint(want) {
+		t.Errorf("Expected tool calls %v, got %v", want, reply.ToolCalls)
+	}
+	for _, part := range []string{`"tools":[{"type":"function","function":{"name":"log_mood"`, `"tool_call_id":"call_0"`, `"tool_calls":[{"id":"call_0"`} {
+		if !strings.Contains(body, part) {
+			t.Errorf("Expected request to contain %s, got %s", part, body)
+		}
+	}
+}
+
+// TestChatProviderFromEnv verifies provider selection
+func TestChatProviderFromEnv(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_BASE_URL", "")
+	t.Setenv("CHAT_PROVIDER", "")
+
+	p, err := ChatProviderFromEnv()
+	if err != nil || p.Name() != "rules" {
+		t.Errorf("Expected the rule-based provider without configuration, got %v, %v", p, err)
+	}
+
+	t.Setenv("OPENAI_BASE_URL", "http://localhost:11434/v1")
+	p, err = ChatProviderFromEnv()
+	if err != nil || p.Name() != "openai" {
+		t.Errorf("Expected the OpenAI provider for a base URL, got %v, %v", p, err)
+	}
+
+	t.Setenv("CHAT_PROVIDER", "carrier-pigeon")
+	if _, err := ChatProviderFromEnv(); err == nil {
+		t.Error("Expected an unknown provider to be rejected")
+	}
+}
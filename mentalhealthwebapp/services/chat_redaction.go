@@ -0,0 +1,67 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// ChatRedactionService remembers the names redacted from each user's chat
+// requests. Names are only detected with context such as "my sister", so
+// without it a name in a summary or memory would reach the provider once
+// the turn that introduced it is no longer in the prompt. Other kinds are
+// found wherever they appear and are not kept.
+type ChatRedactionService struct {
+	db *sql.DB
+}
+
+// NewChatRedactionService creates a new chat redaction service
+func NewChatRedactionService(db *sql.DB) *ChatRedactionService {
+	return &ChatRedactionService{db: db}
+}
+
+// List returns the names redacted from userID's earlier requests, oldest
+// first
+func (s *ChatRedactionService) List(userID int) ([]RedactedValue, error) {
+	rows, err := s.db.Query(`SELECT kind, value FROM chat_redacted_values WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		log.Printf("Error loading redacted values: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []RedactedValue{}
+	for rows.Next() {
+		var v RedactedValue
+		if err := rows.Scan(&v.Kind, &v.Value); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Remember stores the names among values for userID's later requests
+func (s *ChatRedactionService) Remember(userID int, values []RedactedValue) error {
+	var names []string
+	for _, v := range values {
+		if v.Kind == PIIName {
+			names = append(names, v.Value)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO chat_redacted_values (user_id, kind, value)
+		SELECT $1, $2, unnest($3::TEXT[])
+		ON CONFLICT DO NOTHING
+	`
+	if _, err := s.db.Exec(query, userID, PIIName, pq.Array(names)); err != nil {
+		log.Printf("Error storing redacted values: %v", err)
+		return err
+	}
+	return nil
+}
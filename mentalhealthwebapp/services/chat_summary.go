@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// chatSummaryBatchTokens bounds how much of a conversation is summarized at
+// once, so a long conversation is caught up over several turns
+const chatSummaryBatchTokens = 6000
+
+// chatSummaryPrompt asks the model to fold new turns into a summary. %d is
+// the word limit.
+const chatSummaryPrompt = "You summarize conversations between a user and a mental health assistant " +
+	"so the assistant can continue them. Update the summary so far with the new messages. " +
+	"Keep what the user shared about their situation and feelings, what helped or did not, " +
+	"and anything agreed. Write in the third person, in at most %d words, without giving advice."
+
+// ConversationSummary stands in for the turns of a conversation up to
+// ThroughMessageID once they no longer fit in the prompt
+type ConversationSummary struct {
+	ConversationID   int       `json:"conversation_id"`
+	Content          string    `json:"content"`
+	ThroughMessageID int       `json:"through_message_id"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// loadConversationSummary returns a conversation's summary, or nil if it
+// has none yet
+func loadConversationSummary(db *sql.DB, conversationID int) (*ConversationSummary, error) {
+	summary := ConversationSummary{ConversationID: conversationID}
+	query := `SELECT content, through_message_id, updated_at FROM conversation_summaries WHERE conversation_id = $1`
+	err := db.QueryRow(query, conversationID).Scan(&summary.Content, &summary.ThroughMessageID, &summary.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error loading conversation summary: %v", err)
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// summaryBatch picks the messages to fold into the summary: once the
+// unsummarized messages outgrow half of budget, the oldest go, leaving the
+// newest that fit in a quarter of it. FitChatPrompt always leaves half of
+// budget for turns, so none are dropped before they are summarized.
+// messages must be in chronological order.
+func summaryBatch(messages []models.Message, budget int) []models.Message {
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.Content) + messageTokenOverhead
+	}
+	if total <= budget/2 || budget/4 == 0 {
+		return nil
+	}
+
+	batch := messages[:len(messages)-len(fitHistory(messages, budget/4))]
+	used := 0
+	for i, m := range batch {
+		used += EstimateTokens(m.Content) + messageTokenOverhead
+		if used > chatSummaryBatchTokens && i > 0 {
+			return batch[:i]
+		}
+	}
+	return batch
+}
+
+// summaryRequest asks for previous to be updated with messages in at most
+// maxTokens
+func summaryRequest(previous string, messages []models.Message, maxTokens int) ChatRequest {
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Summary so far:\n" + previous + "\n\n")
+	}
+	transcript.WriteString("New messages:\n")
+	for _, m := range messages {
+		speaker := "User"
+		if m.Role == MessageRoleAssistant {
+			speaker = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", speaker, m.Content)
+	}
+
+	return ChatRequest{
+		Messages: []ChatMessage{
+			{Role: "system", Content: fmt.Sprintf(chatSummaryPrompt, maxTokens*3/4)},
+			{Role: MessageRoleUser, Content: transcript.String()},
+		},
+		MaxTokens: maxTokens,
+	}
+}
+
+// ChatSummaryService keeps a rolling summary of each conversation's older
+// turns
+type ChatSummaryService struct {
+	db    *sql.DB
+	usage *ChatUsageService
+}
+
+// NewChatSummaryService creates a summary service charging the tokens it
+// uses to usage
+func NewChatSummaryService(db *sql.DB, usage *ChatUsageService) *ChatSummaryService {
+	return &ChatSummaryService{db: db, usage: usage}
+}
+
+// Get returns a conversation's summary, or nil if it has none yet
+func (s *ChatSummaryService) Get(conversationID int) (*ConversationSummary, error) {
+	return loadConversationSummary(s.db, conversationID)
+}
+
+// Update folds the oldest unsummarized turns of a conversation into its
+// summary once they outgrow the prompt budget, asking provider to write
+// it. The tokens used are added to the chat request usageID. It does
+// nothing while the turns still fit, or when only the rule-based provider
+// is available.
+func (s *ChatSummaryService) Update(ctx context.Context, provider ChatProvider, conversationID int, usageID int64, budget int) error {
+	rules := RuleBasedChatProvider{}.Name()
+	if provider.Name() == rules {
+		return nil
+	}
+
+	summary, err := s.Get(conversationID)
+	if err != nil {
+		return err
+	}
+	previous, throughID := "", 0
+	if summary != nil {
+		previous, throughID = summary.Content, summary.ThroughMessageID
+	}
+
+	query := `SELECT id, conversation_id, role, content, created_at FROM messages WHERE conversation_id = $1 AND id > $2 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, conversationID, throughID)
+	if err != nil {
+		log.Printf("Error loading messages to summarize: %v", err)
+		return err
+	}
+	defer rows.Close()
+	var messages []models.Message
+	for rows.Next() {
+		var m models.Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+			return err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	batch := summaryBatch(messages, budget)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	req := summaryRequest(previous, batch, budget/4)
+	reply, err := provider.Complete(ctx, req)
+	if reply != nil && s.usage != nil {
+		if reply.Usage != nil {
+			s.usage.Add(usageID, reply.Usage, false)
+		} else {
+			s.usage.Add(usageID, EstimateChatUsage(req, reply.Content), true)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	content := strings.TrimSpace(reply.Content)
+	// A fallback to the rule-based provider has no summary to give
+	if reply.Provider == rules || content == "" {
+		return nil
+	}
+
+	// Only replace the summary this one was built on, in case another
+	// reply summarized the conversation meanwhile
+	query = `
+		INSERT INTO conversation_summaries (conversation_id, content, through_message_id) VALUES ($1, $2, $3)
+		ON CONFLICT (conversation_id) DO UPDATE
+		SET content = EXCLUDED.content, through_message_id = EXCLUDED.through_message_id, updated_at = NOW()
+		WHERE conversation_summaries.through_message_id = $4
+	`
+	if _, err := s.db.ExecContext(ctx, query, conversationID, content, batch[len(batch)-1].ID, throughID); err != nil {
+		log.Printf("Error storing conversation summary: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// TestSummaryBatch verifies the oldest turns are summarized once the
+// unsummarized ones outgrow half the budget
+func TestSummaryBatch(t *testing.T) {
+	var messages []models.Message
+	for i := 1; i <= 6; i++ {
+		messages = append(messages, models.Message{ID: i, Content: strings.Repeat("x", 24)}) // 6 tokens + overhead
+	}
+
+	if batch := summaryBatch(messages, 120); batch != nil {
+		t.Errorf("Expected nothing to summarize within the budget, got %+v", batch)
+	}
+
+	// 60 tokens is over half of 100, so all but the newest 25 go
+	batch := summaryBatch(messages, 100)
+	if len(batch) != 4 || batch[3].ID != 4 {
+		t.Errorf("Expected the four oldest messages, got %+v", batch)
+	}
+
+	if batch := summaryBatch(messages, 2); batch != nil {
+		t.Errorf("Expected no summary without room for one, got %+v", batch)
+	}
+}
+
+// TestSummaryRequest verifies the previous summary and the new turns are
+// sent to be summarized
+func TestSummaryRequest(t *testing.T) {
+	messages := []models.Message{
+		{Role: MessageRoleUser, Content: "Work has been stressful"},
+		{Role: MessageRoleAssistant, Content: "That sounds hard."},
+	}
+	req := summaryRequest("They have trouble sleeping.", messages, 200)
+
+	if req.MaxTokens != 200 || len(req.Messages) != 2 || !strings.Contains(req.Messages[0].Content, "at most 150 words") {
+		t.Errorf("Unexpected request %+v", req)
+	}
+	want := "Summary so far:\nThey have trouble sleeping.\n\nNew messages:\nUser: Work has been stressful\nAssistant: That sounds hard.\n"
+	if req.Messages[1].Content != want {
+		t.Errorf("Expected %q, got %q", want, req.Messages[1].Content)
+	}
+}
+
+// TestChatSummaryServiceSkipsRules verifies the rule-based provider is not
+// asked for a summary
+func TestChatSummaryServiceSkipsRules(t *testing.T) {
+	if err := NewChatSummaryService(nil, nil).Update(context.Background(), RuleBasedChatProvider{}, 1, 1, 2000); err != nil {
+		t.Errorf("Expected no summary and no error, got %v", err)
+	}
+}
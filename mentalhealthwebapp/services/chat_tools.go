@@ -0,0 +1,470 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tools the chat model can call
+const (
+	ChatToolLogMood            = "log_mood"
+	ChatToolCreateJournalDraft = "create_journal_draft"
+	ChatToolGetMoodSummary     = "get_mood_summary"
+	ChatToolSaveMemory         = "save_memory"
+)
+
+// Statuses of a chat tool call
+const (
+	ToolCallCompleted = "completed" // a read that ran straight away
+	ToolCallFailed    = "failed"    // invalid arguments or an unknown tool
+	ToolCallPending   = "pending"   // a write waiting for the user
+	ToolCallConfirmed = "confirmed" // a write the user confirmed, now done
+	ToolCallRejected  = "rejected"  // a write the user turned down
+)
+
+// maxChatToolRounds bounds how many times the model may call tools before
+// it has to answer
+const maxChatToolRounds = 3
+
+var (
+	// ErrToolCallNotFound is returned for a tool call that does not exist or
+	// belongs to another user
+	ErrToolCallNotFound = errors.New("tool call not found")
+	// ErrToolCallResolved is returned when confirming or rejecting a tool
+	// call that is not pending
+	ErrToolCallResolved = errors.New("tool call already resolved")
+)
+
+// chatToolWrites are the tools that change the user's data, which only run
+// once the user confirms them
+var chatToolWrites = map[string]bool{
+	ChatToolLogMood:            true,
+	ChatToolCreateJournalDraft: true,
+	ChatToolSaveMemory:         true,
+}
+
+// chatTools describes the tools to the model
+var chatTools = []ChatTool{
+	{
+		Name: ChatToolLogMood,
+		Description: "Log a mood entry for the user, for example when they say how they feel today. " +
+			"The user is asked to confirm before it is saved.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"mood":{"type":"string","enum":["` + strings.Join(MoodNames, `","`) + `"]},` +
+			`"note":{"type":"string","maxLength":500,"description":"A short note in the user's words"}},` +
+			`"required":["mood"]}`),
+	},
+	{
+		Name: ChatToolCreateJournalDraft,
+		Description: "Draft a journal entry from what the user has shared. " +
+			"The user reviews and confirms it before it is saved.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"title":{"type":"string","maxLength":100},` +
+			`"body":{"type":"string","maxLength":5000}},` +
+			`"required":["title","body"]}`),
+	},
+	{
+		Name:        ChatToolGetMoodSummary,
+		Description: "Summarize the moods the user has logged over recent days.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"days":{"type":"integer","minimum":1,"maximum":90,"description":"How many days to look back; 7 if omitted"}}}`),
+	},
+	{
+		Name: ChatToolSaveMemory,
+		Description: "Remember a fact for future conversations, such as a coping strategy that works for the user. " +
+			"Only use it for things the user wants remembered. The user is asked to confirm before it is saved.",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"content":{"type":"string","maxLength":300,"description":"The fact, written about the user in the third person"}},` +
+			`"required":["content"]}`),
+	},
+}
+
+// ChatToolResult is a tool call made by the chat model and what came of it
+type ChatToolResult struct {
+	ID             int             `json:"id"`
+	ConversationID int             `json:"conversation_id"`
+	MessageID      *int            `json:"message_id"`
+	Name           string          `json:"name"`
+	Arguments      json.RawMessage `json:"arguments"`
+	Status         string          `json:"status"`
+	Result         json.RawMessage `json:"result"` // null while pending
+	CreatedAt      time.Time       `json:"created_at"`
+	ResolvedAt     *time.Time      `json:"resolved_at"`
+}
+
+// toolError is the result of a failed tool call
+func toolError(message string) json.RawMessage {
+	result, _ := json.Marshal(map[string]string{"error": message})
+	return result
+}
+
+// forModel is what the model is told about the call
+func (r *ChatToolResult) forModel() string {
+	if r.Status == ToolCallPending {
+		return `{"status":"awaiting_confirmation","note":"The user has been asked to confirm. It is not saved yet."}`
+	}
+	return string(r.Result)
+}
+
+// Outcome describes a resolved write in a sentence for the conversation
+func (r *ChatToolResult) Outcome() string {
+	if r.Status == ToolCallRejected {
+		return "Okay, I won't save that."
+	}
+	var args struct {
+		Mood  string `json:"mood"`
+		Title string `json:"title"`
+	}
+	json.Unmarshal(r.Arguments, &args)
+	switch r.Name {
+	case ChatToolLogMood:
+		return fmt.Sprintf("I've logged your mood as %s.", args.Mood)
+	case ChatToolCreateJournalDraft:
+		return fmt.Sprintf("I've saved your journal entry %q.", args.Title)
+	case ChatToolSaveMemory:
+		return "I'll remember that."
+	}
+	return "Done."
+}
+
+// moodArgs are the arguments of log_mood
+type moodArgs struct {
+	Mood string `json:"mood"`
+	Note string `json:"note"`
+}
+
+// journalArgs are the arguments of create_journal_draft
+type journalArgs struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// memoryArgs are the arguments of save_memory
+type memoryArgs struct {
+	Content string `json:"content"`
+}
+
+// parseToolArgs decodes and checks the arguments of a call, returning the
+// normalized arguments or an error message for the model
+func parseToolArgs(name, arguments string) (interface{}, string) {
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	switch name {
+	case ChatToolLogMood:
+		var args moodArgs
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, "Invalid arguments"
+		}
+		args.Mood = strings.ToLower(strings.TrimSpace(args.Mood))
+		args.Note = strings.TrimSpace(args.Note)
+		if msg := ValidateMood(args.Mood, args.Note); msg != "" {
+			return nil, msg
+		}
+		return args, ""
+	case ChatToolCreateJournalDraft:
+		var args journalArgs
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, "Invalid arguments"
+		}
+		args.Title = strings.TrimSpace(args.Title)
+		args.Body = strings.TrimSpace(args.Body)
+		if args.Title == "" || args.Body == "" {
+			return nil, "Title and body are required"
+		}
+		if msg := ValidateJournal(args.Title, args.Body); msg != "" {
+			return nil, msg
+		}
+		return args, ""
+	case ChatToolGetMoodSummary:
+		var args struct {
+			Days int `json:"days"`
+		}
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, "Invalid arguments"
+		}
+		if args.Days == 0 {
+			args.Days = 7
+		}
+		if args.Days < 1 || args.Days > 90 {
+			return nil, "days must be between 1 and 90"
+		}
+		return args.Days, ""
+	case ChatToolSaveMemory:
+		var args memoryArgs
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, "Invalid arguments"
+		}
+		args.Content = strings.TrimSpace(args.Content)
+		if msg := ValidateMemory(args.Content); msg != "" {
+			return nil, msg
+		}
+		return args, ""
+	}
+	return nil, "Unknown tool " + name
+}
+
+// ChatToolService lets the chat model read and, with the user's
+// confirmation, write the user's moods, journal and memories
+type ChatToolService struct {
+	db       *sql.DB
+	moods    *MoodService
+	journals *JournalService
+	memories *ChatMemoryService
+}
+
+// NewChatToolService creates a tool service
+func NewChatToolService(db *sql.DB) *ChatToolService {
+	return &ChatToolService{db: db, moods: NewMoodService(db), journals: NewJournalService(db), memories: NewChatMemoryService(db)}
+}
+
+// Run gets a reply from provider, running the tools the model calls and
+// sending it their results until it answers. Reads run straight away;
+// writes are stored pending until the user confirms them, and the model is
+// told so. With onDelta the reply is streamed. The reply's usage covers
+// every round when the provider reported it for all of them.
+func (s *ChatToolService) Run(ctx context.Context, provider ChatProvider, req ChatRequest, userID, conversationID int, onDelta func(string) error) (*ChatReply, []ChatToolResult, error) {
+	req.Tools = chatTools
+	req.Messages = append([]ChatMessage(nil), req.Messages...)
+
+	var results []ChatToolResult
+	var content strings.Builder
+	usage, usageKnown := &ChatUsage{}, true
+	for round := 0; ; round++ {
+		if round == maxChatToolRounds {
+			req.Tools = nil // answer with what it has
+		}
+
+		var reply *ChatReply
+		var err error
+		if onDelta == nil {
+			reply, err = provider.Complete(ctx, req)
+		} else {
+			reply, err = provider.Stream(ctx, req, onDelta)
+		}
+		if reply == nil {
+			return nil, results, err
+		}
+
+		if reply.Usage == nil {
+			usageKnown = false
+		} else {
+			usage.PromptTokens += reply.Usage.PromptTokens
+			usage.CompletionTokens += reply.Usage.CompletionTokens
+			usage.TotalTokens += reply.Usage.TotalTokens
+		}
+		content.WriteString(reply.Content)
+
+		if err != nil || len(reply.ToolCalls) == 0 || req.Tools == nil {
+			reply.Content = content.String()
+			reply.ToolCalls = nil
+			reply.Usage = nil
+			if usageKnown {
+				reply.Usage = usage
+			}
+			return reply, results, err
+		}
+
+		req.Messages = append(req.Messages, ChatMessage{Role: MessageRoleAssistant, Content: reply.Content, ToolCalls: reply.ToolCalls})
+		for _, call := range reply.ToolCalls {
+			result := s.call(userID, conversationID, call)
+			results = append(results, result)
+			req.Messages = append(req.Messages, ChatMessage{Role: MessageRoleTool, Content: result.forModel(), ToolCallID: call.ID})
+		}
+	}
+}
+
+// call runs a read, or stores a write for the user to confirm, and records
+// the call
+func (s *ChatToolService) call(userID, conversationID int, call ChatToolCall) ChatToolResult {
+	result := ChatToolResult{ConversationID: conversationID, Name: call.Name, Arguments: json.RawMessage(call.Arguments)}
+	if !json.Valid(result.Arguments) {
+		result.Arguments = json.RawMessage("{}")
+	}
+
+	args, message := parseToolArgs(call.Name, call.Arguments)
+	switch {
+	case message != "":
+		result.Status, result.Result = ToolCallFailed, toolError(message)
+	case chatToolWrites[call.Name]:
+		result.Status = ToolCallPending
+		result.Arguments, _ = json.Marshal(args)
+	case call.Name == ChatToolGetMoodSummary:
+		summary, err := s.moods.Summary(userID, args.(int))
+		if err != nil {
+			result.Status, result.Result = ToolCallFailed, toolError("Mood summary is unavailable")
+			break
+		}
+		result.Status = ToolCallCompleted
+		result.Result, _ = json.Marshal(summary)
+	}
+
+	var resultJSON interface{}
+	if result.Result != nil {
+		resultJSON = []byte(result.Result)
+	}
+	if result.Status != ToolCallPending {
+		now := time.Now()
+		result.ResolvedAt = &now
+	}
+	query := `
+		INSERT INTO chat_tool_calls (user_id, conversation_id, name, arguments, status, result, resolved_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+	err := s.db.QueryRow(query, userID, conversationID, result.Name, []byte(result.Arguments), result.Status, resultJSON, result.ResolvedAt).Scan(&result.ID, &result.CreatedAt)
+	if err != nil {
+		// An unrecorded write could never be confirmed
+		log.Printf("Error recording tool call: %v", err)
+		if result.Status == ToolCallPending {
+			result.Status, result.Result = ToolCallFailed, toolError("Could not be saved for confirmation")
+		}
+	}
+	return result
+}
+
+// AttachToMessage links tool calls to the reply they were made for
+func (s *ChatToolService) AttachToMessage(results []ChatToolResult, messageID int) error {
+	var ids []int64
+	for _, r := range results {
+		if r.ID != 0 {
+			ids = append(ids, int64(r.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.db.Exec(`UPDATE chat_tool_calls SET message_id = $1 WHERE id = ANY($2)`, messageID, pq.Array(ids))
+	if err != nil {
+		log.Printf("Error linking tool calls: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FailPending marks the writes among results that are still pending as
+// failed. It is for turns that end in an error: the user was never shown
+// the reply asking them to confirm, so the writes must not be confirmable.
+func (s *ChatToolService) FailPending(results []ChatToolResult) error {
+	var ids []int64
+	for _, r := range results {
+		if r.ID != 0 && r.Status == ToolCallPending {
+			ids = append(ids, int64(r.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	query := `UPDATE chat_tool_calls SET status = $2, result = $3, resolved_at = NOW() WHERE id = ANY($1) AND status = 'pending'`
+	_, err := s.db.Exec(query, pq.Array(ids), ToolCallFailed, []byte(toolError("The reply failed, so this was not offered to the user")))
+	if err != nil {
+		log.Printf("Error failing pending tool calls: %v", err)
+		return err
+	}
+	return nil
+}
+
+// chatToolColumns are the columns scanned by scanToolResult
+const chatToolColumns = `id, conversation_id, message_id, name, arguments, status, result, created_at, resolved_at`
+
+// scanToolResult reads a row of chatToolColumns
+func scanToolResult(row interface{ Scan(...interface{}) error }) (*ChatToolResult, error) {
+	var r ChatToolResult
+	var arguments, result []byte
+	if err := row.Scan(&r.ID, &r.ConversationID, &r.MessageID, &r.Name, &arguments, &r.Status, &result, &r.CreatedAt, &r.ResolvedAt); err != nil {
+		return nil, err
+	}
+	r.Arguments, r.Result = arguments, result
+	return &r, nil
+}
+
+// Resolve confirms or rejects userID's pending write. A confirmed write is
+// carried out through the same services as the mood and journal endpoints,
+// or remembered.
+func (s *ChatToolService) Resolve(userID, toolCallID int, confirm bool) (*ChatToolResult, error) {
+	status := ToolCallRejected
+	if confirm {
+		status = ToolCallConfirmed
+	}
+
+	// Claiming the call first means a double click cannot save it twice
+	query := `
+		UPDATE chat_tool_calls SET status = $3, resolved_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND status = 'pending'
+		RETURNING ` + chatToolColumns
+	r, err := scanToolResult(s.db.QueryRow(query, toolCallID, userID, status))
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM chat_tool_calls WHERE id = $1 AND user_id = $2)`, toolCallID, userID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrToolCallResolved
+		}
+		return nil, ErrToolCallNotFound
+	}
+	if err != nil {
+		log.Printf("Error resolving tool call: %v", err)
+		return nil, err
+	}
+	if !confirm {
+		return r, nil
+	}
+
+	args, message := parseToolArgs(r.Name, string(r.Arguments))
+	var created interface{}
+	switch {
+	case message != "":
+		err = errors.New(message)
+	case r.Name == ChatToolLogMood:
+		a := args.(moodArgs)
+		created, err = s.moods.Create(userID, a.Mood, a.Note)
+	case r.Name == ChatToolCreateJournalDraft:
+		a := args.(journalArgs)
+		created, err = s.journals.Create(userID, a.Title, a.Body)
+	case r.Name == ChatToolSaveMemory:
+		created, err = s.memories.Add(userID, args.(memoryArgs).Content, r.ID)
+	default:
+		err = fmt.Errorf("tool %s cannot be confirmed", r.Name)
+	}
+	if err != nil {
+		// Leave it pending so the user can try again
+		s.db.Exec(`UPDATE chat_tool_calls SET status = 'pending', resolved_at = NULL WHERE id = $1`, r.ID)
+		return nil, err
+	}
+
+	r.Result, _ = json.Marshal(created)
+	if _, err := s.db.Exec(`UPDATE chat_tool_calls SET result = $2 WHERE id = $1`, r.ID, []byte(r.Result)); err != nil {
+		log.Printf("Error storing tool call result: %v", err)
+	}
+	return r, nil
+}
+
+// ListForConversation returns the tool calls made in a conversation,
+// oldest first
+func (s *ChatToolService) ListForConversation(conversationID int) ([]ChatToolResult, error) {
+	rows, err := s.db.Query(`SELECT `+chatToolColumns+` FROM chat_tool_calls WHERE conversation_id = $1 ORDER BY id`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []ChatToolResult{}
+	for rows.Next() {
+		r, err := scanToolResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *r)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// TestChatToolSchemas verifies every tool describes its arguments as a JSON
+// Schema object
+func TestChatToolSchemas(t *testing.T) {
+	for _, tool := range chatTools {
+		var schema struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(tool.Parameters, &schema); err != nil || schema.Type != "object" {
+			t.Errorf("%s: invalid parameters %s", tool.Name, tool.Parameters)
+		}
+	}
+}
+
+// TestParseToolArgs verifies arguments are validated like the mood and
+// journal endpoints and memories
+func TestParseToolArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		want      interface{}
+		wantError bool
+	}{
+		{ChatToolLogMood, `{"mood":" Anxious ","note":"work"}`, moodArgs{Mood: "anxious", Note: "work"}, false},
+		{ChatToolLogMood, `{"mood":"elated"}`, nil, true},
+		{ChatToolLogMood, `not json`, nil, true},
+		{ChatToolCreateJournalDraft, `{"title":"Today","body":"A long day."}`, journalArgs{Title: "Today", Body: "A long day."}, false},
+		{ChatToolCreateJournalDraft, `{"title":"Today"}`, nil, true},
+		{ChatToolGetMoodSummary, ``, 7, false},
+		{ChatToolGetMoodSummary, `{"days":30}`, 30, false},
+		{ChatToolGetMoodSummary, `{"days":365}`, nil, true},
+		{ChatToolSaveMemory, `{"content":" Box breathing helps them calm down "}`, memoryArgs{Content: "Box breathing helps them calm down"}, false},
+		{ChatToolSaveMemory, `{"content":""}`, nil, true},
+		{"delete_account", `{}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, message := parseToolArgs(tt.name, tt.arguments)
+		if (message != "") != tt.wantError || got != tt.want {
+			t.Errorf("parseToolArgs(%s, %s) = %v, %q", tt.name, tt.arguments, got, message)
+		}
+	}
+}
+
+// TestChatToolResultOutcome verifies resolved writes are described for the
+// conversation
+func TestChatToolResultOutcome(t *testing.T) {
+	r := ChatToolResult{Name: ChatToolLogMood, Arguments: json.RawMessage(`{"mood":"calm"}`), Status: ToolCallConfirmed}
+	if got := r.Outcome(); got != "I've logged your mood as calm." {
+		t.Errorf("Unexpected outcome %q", got)
+	}
+	r.Status = ToolCallRejected
+	if got := r.Outcome(); got != "Okay, I won't save that." {
+		t.Errorf("Unexpected outcome %q", got)
+	}
+}
+
+// TestChatToolServiceRunWithoutCalls verifies a reply without tool calls is
+// returned as is, with the tools offered to the model
+func TestChatToolServiceRunWithoutCalls(t *testing.T) {
+	provider := &FakeChatProvider{Replies: []string{"Hello there"}}
+	reply, results, err := NewChatToolService(nil).Run(context.Background(), provider, ChatRequest{}, 1, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Hello there" || len(results) != 0 || reply.Usage == nil {
+		t.Errorf("Unexpected reply %+v, results %v", reply, results)
+	}
+	if len(provider.Requests[0].Tools) != len(chatTools) {
+		t.Errorf("Expected the tools to be offered, got %v", provider.Requests[0].Tools)
+	}
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// chatQuotaLockClass is the first key of the pg_advisory_xact_locks taken
+// while a quota is checked, so concurrent requests are counted one at a time
+const chatQuotaLockClass = 7203
+
+// chatQuotaGlobalLock is the second key of the lock shared by all users,
+// which user IDs never take
+const chatQuotaGlobalLock = 0
+
+// chatUsageHistoryDays is how many days GET /api/chat/usage reports
+const chatUsageHistoryDays = 30
+
+// Chat limits, as reported in quota errors and usage
+const (
+	ChatLimitRequestsPerMinute = "requests_per_minute"
+	ChatLimitTokensPerDay      = "tokens_per_day"
+)
+
+// ChatQuotaConfig bounds chat use per user and across all users. Zero
+// means unlimited.
+type ChatQuotaConfig struct {
+	UserRequestsPerMinute   int
+	UserTokensPerDay        int
+	GlobalRequestsPerMinute int
+	GlobalTokensPerDay      int
+}
+
+// DefaultChatQuotaConfig returns the default limits: per-user limits only
+func DefaultChatQuotaConfig() ChatQuotaConfig {
+	return ChatQuotaConfig{
+		UserRequestsPerMinute: 10,
+		UserTokensPerDay:      50000,
+	}
+}
+
+// ChatQuotaConfigFromEnv reads CHAT_USER_REQUESTS_PER_MINUTE,
+// CHAT_USER_TOKENS_PER_DAY, CHAT_GLOBAL_REQUESTS_PER_MINUTE and
+// CHAT_GLOBAL_TOKENS_PER_DAY, falling back to the defaults
+func ChatQuotaConfigFromEnv() ChatQuotaConfig {
+	cfg := DefaultChatQuotaConfig()
+	for env, field := range map[string]*int{
+		"CHAT_USER_REQUESTS_PER_MINUTE":   &cfg.UserRequestsPerMinute,
+		"CHAT_USER_TOKENS_PER_DAY":        &cfg.UserTokensPerDay,
+		"CHAT_GLOBAL_REQUESTS_PER_MINUTE": &cfg.GlobalRequestsPerMinute,
+		"CHAT_GLOBAL_TOKENS_PER_DAY":      &cfg.GlobalTokensPerDay,
+	} {
+		if n, err := strconv.Atoi(os.Getenv(env)); err == nil && n >= 0 {
+			*field = n
+		}
+	}
+	return cfg
+}
+
+// ChatQuotaError is returned when a chat request would exceed a limit
+type ChatQuotaError struct {
+	Scope   string    // "user" or "global"
+	Limit   string    // ChatLimitRequestsPerMinute or ChatLimitTokensPerDay
+	Max     int       // the configured limit
+	ResetAt time.Time // when the request can be made again
+}
+
+func (e *ChatQuotaError) Error() string {
+	return fmt.Sprintf("%s chat limit of %d %s reached until %s", e.Scope, e.Max, e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+// chatCounters is the chat use that counts against the limits
+type chatCounters struct {
+	userRequests   int        // in the last minute
+	userOldest     *time.Time // oldest of those requests
+	userTokens     int        // today
+	globalRequests int
+	globalOldest   *time.Time
+	globalTokens   int
+}
+
+// chatDayStart returns the start of the UTC day containing t, when daily
+// token limits reset
+func chatDayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// checkChatQuota returns the first limit the counters have reached, or nil.
+// Requests per minute are a sliding window, so a slot frees up a minute
+// after the oldest request in it; tokens per day reset at midnight UTC.
+func checkChatQuota(cfg ChatQuotaConfig, counters chatCounters, now time.Time) *ChatQuotaError {
+	minuteReset := func(oldest *time.Time) time.Time {
+		if oldest == nil {
+			return now.Add(time.Minute)
+		}
+		return oldest.Add(time.Minute)
+	}
+	dayReset := chatDayStart(now).Add(24 * time.Hour)
+
+	switch {
+	case cfg.UserRequestsPerMinute > 0 && counters.userRequests >= cfg.UserRequestsPerMinute:
+		return &ChatQuotaError{Scope: "user", Limit: ChatLimitRequestsPerMinute, Max: cfg.UserRequestsPerMinute, ResetAt: minuteReset(counters.userOldest)}
+	case cfg.UserTokensPerDay > 0 && counters.userTokens >= cfg.UserTokensPerDay:
+		return &ChatQuotaError{Scope: "user", Limit: ChatLimitTokensPerDay, Max: cfg.UserTokensPerDay, ResetAt: dayReset}
+	case cfg.GlobalRequestsPerMinute > 0 && counters.globalRequests >= cfg.GlobalRequestsPerMinute:
+		return &ChatQuotaError{Scope: "global", Limit: ChatLimitRequestsPerMinute, Max: cfg.GlobalRequestsPerMinute, ResetAt: minuteReset(counters.globalOldest)}
+	case cfg.GlobalTokensPerDay > 0 && counters.globalTokens >= cfg.GlobalTokensPerDay:
+		return &ChatQuotaError{Scope: "global", Limit: ChatLimitTokensPerDay, Max: cfg.GlobalTokensPerDay, ResetAt: dayReset}
+	}
+	return nil
+}
+
+// chatQuotaLocks returns the second keys of the advisory locks to take, in
+// order, before checking userID's quota. With a global limit, requests of
+// different users must also be counted one at a time, or each could see the
+// last free slot and all be admitted.
+func chatQuotaLocks(cfg ChatQuotaConfig, userID int) []int {
+	if cfg.GlobalRequestsPerMinute > 0 || cfg.GlobalTokensPerDay > 0 {
+		return []int{chatQuotaGlobalLock, userID}
+	}
+	return []int{userID}
+}
+
+// EstimateChatUsage approximates the usage of a reply whose provider did
+// not report it
+func EstimateChatUsage(req ChatRequest, reply string) *ChatUsage {
+	usage := &ChatUsage{CompletionTokens: EstimateTokens(reply)}
+	for _, m := range req.Messages {
+		usage.PromptTokens += EstimateTokens(m.Content) + messageTokenOverhead
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// ChatLimitStatus is how much of one limit a user has used
+type ChatLimitStatus struct {
+	Limit   int       `json:"limit"` // 0 if unlimited
+	Used    int       `json:"used"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// ChatUsageDay is a user's chat use on one UTC day
+type ChatUsageDay struct {
+	Date             string `json:"date"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+// ChatUsageSummary is a user's limits and recent use
+type ChatUsageSummary struct {
+	RequestsPerMinute ChatLimitStatus `json:"requests_per_minute"`
+	TokensPerDay      ChatLimitStatus `json:"tokens_per_day"`
+	Days              []ChatUsageDay  `json:"days"`
+}
+
+// ChatUsageService enforces chat quotas and records the tokens each reply used
+type ChatUsageService struct {
+	db     *sql.DB
+	config ChatQuotaConfig
+	now    func() time.Time
+}
+
+// NewChatUsageService creates a usage service enforcing config
+func NewChatUsageService(db *sql.DB, config ChatQuotaConfig) *ChatUsageService {
+	return &ChatUsageService{db: db, config: config, now: time.Now}
+}
+
+// Reserve checks userID's request against the limits and, if it is
+// allowed, records it, returning the ID to complete with Record. A request
+// over a limit gets a *ChatQuotaError.
+func (s *ChatUsageService) Reserve(ctx context.Context, userID, conversationID int) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, key := range chatQuotaLocks(s.config, userID) {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, chatQuotaLockClass, key); err != nil {
+			log.Printf("Error locking chat quota: %v", err)
+			return 0, err
+		}
+	}
+
+	now := s.now()
+	var counters chatCounters
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE user_id = $1 AND created_at > $2),
+			MIN(created_at) FILTER (WHERE user_id = $1 AND created_at > $2),
+			COALESCE(SUM(total_tokens) FILTER (WHERE user_id = $1 AND created_at >= $3), 0),
+			COUNT(*) FILTER (WHERE created_at > $2),
+			MIN(created_at) FILTER (WHERE created_at > $2),
+			COALESCE(SUM(total_tokens) FILTER (WHERE created_at >= $3), 0)
+		FROM chat_usage
+		WHERE created_at >= LEAST($2, $3)
+	`
+	err = tx.QueryRowContext(ctx, query, userID, now.Add(-time.Minute), chatDayStart(now)).Scan(
+		&counters.userRequests, &counters.userOldest, &counters.userTokens,
+		&counters.globalRequests, &counters.globalOldest, &counters.globalTokens,
+	)
+	if err != nil {
+		log.Printf("Error loading chat usage: %v", err)
+		return 0, err
+	}
+
+	if quotaErr := checkChatQuota(s.config, counters, now); quotaErr != nil {
+		return 0, quotaErr
+	}
+
+	var usageID int64
+	query = `INSERT INTO chat_usage (user_id, conversation_id, created_at) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, userID, conversationID, now).Scan(&usageID); err != nil {
+		log.Printf("Error recording chat request: %v", err)
+		return 0, err
+	}
+
+	return usageID, tx.Commit()
+}
+
+// Record stores the tokens a reserved request used. usage may be nil when
+// the request failed before the provider answered.
+func (s *ChatUsageService) Record(usageID int64, provider string, usage *ChatUsage, estimated bool) error {
+	if usage == nil {
+		usage = &ChatUsage{}
+	}
+	query := `
+		UPDATE chat_usage
+		SET provider = $2, prompt_tokens = $3, completion_tokens = $4, total_tokens = $5, estimated = $6
+		WHERE id = $1
+	`
+	_, err := s.db.Exec(query, usageID, provider, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, estimated)
+	if err != nil {
+		log.Printf("Error recording chat usage: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Add adds the tokens of a follow-up call, such as summarizing the
+// conversation, to a recorded request
+func (s *ChatUsageService) Add(usageID int64, usage *ChatUsage, estimated bool) error {
+	query := `
+		UPDATE chat_usage
+		SET prompt_tokens = prompt_tokens + $2, completion_tokens = completion_tokens + $3,
+			total_tokens = total_tokens + $4, estimated = estimated OR $5
+		WHERE id = $1
+	`
+	_, err := s.db.Exec(query, usageID, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, estimated)
+	if err != nil {
+		log.Printf("Error recording chat usage: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Summary returns userID's standing against the per-user limits and their
+// use over the last 30 days
+func (s *ChatUsageService) Summary(userID int) (*ChatUsageSummary, error) {
+	now := s.now()
+	dayStart := chatDayStart(now)
+
+	summary := &ChatUsageSummary{
+		RequestsPerMinute: ChatLimitStatus{Limit: s.config.UserRequestsPerMinute, ResetAt: now.Add(time.Minute)},
+		TokensPerDay:      ChatLimitStatus{Limit: s.config.UserTokensPerDay, ResetAt: dayStart.Add(24 * time.Hour)},
+		Days:              []ChatUsageDay{},
+	}
+
+	var oldest *time.Time
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at > $2),
+			MIN(created_at) FILTER (WHERE created_at > $2),
+			COALESCE(SUM(total_tokens) FILTER (WHERE created_at >= $3), 0)
+		FROM chat_usage
+		WHERE user_id = $1 AND created_at >= LEAST($2, $3)
+	`
+	err := s.db.QueryRow(query, userID, now.Add(-time.Minute), dayStart).Scan(&summary.RequestsPerMinute.Used, &oldest, &summary.TokensPerDay.Used)
+	if err != nil {
+		log.Printf("Error loading chat usage: %v", err)
+		return nil, err
+	}
+	if oldest != nil {
+		summary.RequestsPerMinute.ResetAt = oldest.Add(time.Minute)
+	}
+
+	query = `
+		SELECT TO_CHAR(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+			COUNT(*), SUM(prompt_tokens), SUM(completion_tokens), SUM(total_tokens)
+		FROM chat_usage
+		WHERE user_id = $1 AND created_at >= $2
+		GROUP BY day
+		ORDER BY day DESC
+	`
+	rows, err := s.db.Query(query, userID, dayStart.AddDate(0, 0, -(chatUsageHistoryDays-1)))
+	if err != nil {
+		log.Printf("Error loading chat usage history: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day ChatUsageDay
+		if err := rows.Scan(&day.Date, &day.Requests, &day.PromptTokens, &day.CompletionTokens, &day.TotalTokens); err != nil {
+			return nil, err
+		}
+		summary.Days = append(summary.Days, day)
+	}
+
+	return summary, rows.Err()
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCheckChatQuota verifies each limit and when it resets
+func TestCheckChatQuota(t *testing.T) {
+	cfg := ChatQuotaConfig{UserRequestsPerMinute: 3, UserTokensPerDay: 1000, GlobalRequestsPerMinute: 100, GlobalTokensPerDay: 50000}
+	now := time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC)
+	oldest := now.Add(-40 * time.Second)
+	midnight := time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		counters chatCounters
+		want     *ChatQuotaError
+	}{
+		{"under every limit", chatCounters{userRequests: 2, userTokens: 999, globalRequests: 99, globalTokens: 49999}, nil},
+		{"user requests", chatCounters{userRequests: 3, userOldest: &oldest}, &ChatQuotaError{Scope: "user", Limit: ChatLimitRequestsPerMinute, Max: 3, ResetAt: oldest.Add(time.Minute)}},
+		{"user tokens", chatCounters{userTokens: 1200}, &ChatQuotaError{Scope: "user", Limit: ChatLimitTokensPerDay, Max: 1000, ResetAt: midnight}},
+		{"global requests", chatCounters{globalRequests: 100, globalOldest: &oldest}, &ChatQuotaError{Scope: "global", Limit: ChatLimitRequestsPerMinute, Max: 100, ResetAt: oldest.Add(time.Minute)}},
+		{"global tokens", chatCounters{globalTokens: 50000}, &ChatQuotaError{Scope: "global", Limit: ChatLimitTokensPerDay, Max: 50000, ResetAt: midnight}},
+	}
+
+	for _, tt := range tests {
+		got := checkChatQuota(cfg, tt.counters, now)
+		if (got == nil) != (tt.want == nil) || got != nil && (*got != *tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+
+	// Zero turns a limit off
+	if got := checkChatQuota(ChatQuotaConfig{}, chatCounters{userRequests: 1000, userTokens: 1 << 30}, now); got != nil {
+		t.Errorf("Expected no limits, got %+v", got)
+	}
+}
+
+// TestChatQuotaLocks verifies requests of different users share a lock
+// only when a global limit could otherwise admit both for the last slot
+func TestChatQuotaLocks(t *testing.T) {
+	shared := func(cfg ChatQuotaConfig) bool {
+		for _, a := range chatQuotaLocks(cfg, 1) {
+			for _, b := range chatQuotaLocks(cfg, 2) {
+				if a == b {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if shared(ChatQuotaConfig{UserRequestsPerMinute: 10, UserTokensPerDay: 50000}) {
+		t.Errorf("Expected users to lock separately without global limits")
+	}
+	for _, cfg := range []ChatQuotaConfig{{GlobalRequestsPerMinute: 1}, {GlobalTokensPerDay: 1000}} {
+		if !shared(cfg) {
+			t.Errorf("Expected users to share a lock with %+v", cfg)
+		}
+	}
+
+	// The global lock comes first so concurrent requests cannot deadlock
+	got := chatQuotaLocks(ChatQuotaConfig{GlobalRequestsPerMinute: 1}, 7)
+	if len(got) != 2 || got[0] != chatQuotaGlobalLock || got[1] != 7 {
+		t.Errorf("Expected [%d 7], got %v", chatQuotaGlobalLock, got)
+	}
+}
+
+// TestChatDayStart verifies daily limits follow UTC days
+func TestChatDayStart(t *testing.T) {
+	local := time.FixedZone("UTC-5", -5*60*60)
+	got := chatDayStart(time.Date(2025, 3, 10, 21, 0, 0, 0, local))
+	if want := time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestEstimateChatUsage verifies estimates cover the prompt and the reply
+func TestEstimateChatUsage(t *testing.T) {
+	req := ChatRequest{Messages: []ChatMessage{
+		{Role: "system", Content: "12345678"},    // 2 tokens + overhead
+		{Role: MessageRoleUser, Content: "1234"}, // 1 token + overhead
+	}}
+
+	usage := EstimateChatUsage(req, "123456789012") // 3 tokens
+	want := ChatUsage{PromptTokens: 3 + 2*messageTokenOverhead, CompletionTokens: 3, TotalTokens: 6 + 2*messageTokenOverhead}
+	if *usage != want {
+		t.Errorf("Expected %+v, got %+v", want, *usage)
+	}
+}
+
+// TestChatQuotaConfigFromEnv verifies limits can be changed or turned off
+func TestChatQuotaConfigFromEnv(t *testing.T) {
+	t.Setenv("CHAT_USER_REQUESTS_PER_MINUTE", "0")
+	t.Setenv("CHAT_USER_TOKENS_PER_DAY", "")
+	t.Setenv("CHAT_GLOBAL_REQUESTS_PER_MINUTE", "120")
+	t.Setenv("CHAT_GLOBAL_TOKENS_PER_DAY", "-5")
+
+	cfg := ChatQuotaConfigFromEnv()
+	want := DefaultChatQuotaConfig()
+	want.UserRequestsPerMinute = 0
+	want.GlobalRequestsPerMinute = 120
+	if cfg != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg)
+	}
+}
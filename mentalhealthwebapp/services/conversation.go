@@ -0,0 +1,315 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// Message roles
+const (
+	MessageRoleUser      = "user"
+	MessageRoleAssistant = "assistant"
+)
+
+// messageTokenOverhead approximates the tokens each message costs beyond
+// its content (role and separators)
+const messageTokenOverhead = 4
+
+// conversationTitleLength is how much of the first message becomes the
+// title of an untitled conversation
+const conversationTitleLength = 60
+
+// ErrConversationNotFound is returned for conversations that do not exist
+// or belong to another user
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// EstimateTokens approximates how many model tokens text uses, at about
+// four characters per token. It is only used to budget history, so an
+// exact tokenizer is not needed.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// fitHistory returns the newest messages whose estimated tokens fit in
+// budget, in chronological order. messages must be in chronological order.
+func fitHistory(messages []models.Message, budget int) []models.Message {
+	used := 0
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		cost := EstimateTokens(messages[i].Content) + messageTokenOverhead
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = i
+	}
+	return messages[start:]
+}
+
+// ConversationService stores AI chat conversations
+type ConversationService struct {
+	db *sql.DB
+}
+
+// NewConversationService creates a new conversation service
+func NewConversationService(db *sql.DB) *ConversationService {
+	return &ConversationService{db: db}
+}
+
+// Create starts a conversation for userID with a persona, or nil for the
+// default one
+func (s *ConversationService) Create(userID int, title string, personaID *int) (*models.Conversation, error) {
+	conversation := models.Conversation{Title: title, PersonaID: personaID}
+	query := `INSERT INTO conversations (user_id, title, persona_id) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at`
+	err := s.db.QueryRow(query, userID, title, personaID).Scan(&conversation.ID, &conversation.CreatedAt, &conversation.UpdatedAt)
+	if err != nil {
+		log.Printf("Error creating conversation: %v", err)
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// Get returns one of the user's conversations
+func (s *ConversationService) Get(userID, conversationID int) (*models.Conversation, error) {
+	var conversation models.Conversation
+	query := `SELECT id, title, persona_id, created_at, updated_at FROM conversations WHERE id = $1 AND user_id = $2`
+	err := s.db.QueryRow(query, conversationID, userID).Scan(&conversation.ID, &conversation.Title, &conversation.PersonaID, &conversation.CreatedAt, &conversation.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrConversationNotFound
+		}
+		log.Printf("Error loading conversation: %v", err)
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// ListForUser returns the user's conversations, most recently active first
+func (s *ConversationService) ListForUser(userID int) ([]models.Conversation, error) {
+	query := `SELECT id, title, persona_id, created_at, updated_at FROM conversations WHERE user_id = $1 ORDER BY updated_at DESC, id DESC`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		log.Printf("Error listing conversations: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		var conversation models.Conversation
+		if err := rows.Scan(&conversation.ID, &conversation.Title, &conversation.PersonaID, &conversation.CreatedAt, &conversation.UpdatedAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	return conversations, rows.Err()
+}
+
+// SetPersona switches one of the user's conversations to a persona, or nil
+// for the default one, reporting whether the conversation exists
+func (s *ConversationService) SetPersona(userID, conversationID int, personaID *int) (bool, error) {
+	result, err := s.db.Exec(`UPDATE conversations SET persona_id = $3 WHERE id = $1 AND user_id = $2`, conversationID, userID, personaID)
+	if err != nil {
+		log.Printf("Error updating conversation persona: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// Delete removes one of the user's conversations and its messages,
+// reporting whether it existed
+func (s *ConversationService) Delete(userID, conversationID int) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM conversations WHERE id = $1 AND user_id = $2`, conversationID, userID)
+	if err != nil {
+		log.Printf("Error deleting conversation: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteEmpty deletes one of the user's conversations if it has no
+// messages, reporting whether it did
+func (s *ConversationService) DeleteEmpty(userID, conversationID int) (bool, error) {
+	query := `
+		DELETE FROM conversations c WHERE id = $1 AND user_id = $2
+		AND NOT EXISTS (SELECT 1 FROM messages WHERE conversation_id = c.id)
+	`
+	result, err := s.db.Exec(query, conversationID, userID)
+	if err != nil {
+		log.Printf("Error deleting empty conversation: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// Messages returns up to limit messages of a conversation in chronological
+// order. With beforeID > 0 only messages older than that message are
+// returned, for paging back through long conversations.
+func (s *ConversationService) Messages(conversationID, beforeID, limit int) ([]models.Message, error) {
+	query := `
+		SELECT id, conversation_id, role, content, persona_version_id, created_at FROM (
+			SELECT id, conversation_id, role, content, persona_version_id, created_at FROM messages
+			WHERE conversation_id = $1 AND ($2 = 0 OR id < $2)
+			ORDER BY id DESC
+			LIMIT $3
+		) latest
+		ORDER BY id
+	`
+	rows, err := s.db.Query(query, conversationID, beforeID, limit)
+	if err != nil {
+		log.Printf("Error listing messages: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var m models.Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.PersonaVersionID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// History returns the latest messages of a conversation that fit in a
+// budget of tokens, in chronological order, to send along with a new message
+func (s *ConversationService) History(conversationID, budget int) ([]models.Message, error) {
+	// Even the shortest messages cost messageTokenOverhead, which bounds
+	// how many can fit
+	messages, err := s.Messages(conversationID, 0, budget/messageTokenOverhead+1)
+	if err != nil {
+		return nil, err
+	}
+	return fitHistory(messages, budget), nil
+}
+
+// AddTurn stores a user message and the assistant's reply, both marked with
+// the persona version in use, if any. An untitled conversation is named
+// after its first message.
+func (s *ConversationService) AddTurn(conversationID int, userContent, reply string, personaVersionID *int) (userMessage, assistantMessage *models.Message, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	insert := func(role, content string) (*models.Message, error) {
+		m := models.Message{ConversationID: conversationID, Role: role, Content: content, PersonaVersionID: personaVersionID}
+		query := `INSERT INTO messages (conversation_id, role, content, token_count, persona_version_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+		if err := tx.QueryRow(query, conversationID, role, content, EstimateTokens(content), personaVersionID).Scan(&m.ID, &m.CreatedAt); err != nil {
+			log.Printf("Error storing message: %v", err)
+			return nil, err
+		}
+		return &m, nil
+	}
+
+	if userMessage, err = insert(MessageRoleUser, userContent); err != nil {
+		return nil, nil, err
+	}
+	if assistantMessage, err = insert(MessageRoleAssistant, reply); err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+		UPDATE conversations
+		SET updated_at = NOW(), title = CASE WHEN title = '' THEN $2 ELSE title END
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, conversationID, conversationTitle(userContent)); err != nil {
+		log.Printf("Error updating conversation: %v", err)
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return userMessage, assistantMessage, nil
+}
+
+// AddMessage stores a single assistant message, such as the outcome of a
+// confirmed tool call
+func (s *ConversationService) AddMessage(conversationID int, content string) (*models.Message, error) {
+	m := models.Message{ConversationID: conversationID, Role: MessageRoleAssistant, Content: content}
+	query := `INSERT INTO messages (conversation_id, role, content, token_count) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	if err := s.db.QueryRow(query, conversationID, m.Role, content, EstimateTokens(content)).Scan(&m.ID, &m.CreatedAt); err != nil {
+		log.Printf("Error storing message: %v", err)
+		return nil, err
+	}
+	if _, err := s.db.Exec(`UPDATE conversations SET updated_at = NOW() WHERE id = $1`, conversationID); err != nil {
+		log.Printf("Error updating conversation: %v", err)
+		return nil, err
+	}
+	return &m, nil
+}
+
+// AddRedactions adds to the conversation's count of redacted values by kind
+func (s *ConversationService) AddRedactions(conversationID int, counts map[string]int) error {
+	query := `
+		INSERT INTO conversation_redactions (conversation_id, kind, count) VALUES ($1, $2, $3)
+		ON CONFLICT (conversation_id, kind) DO UPDATE SET count = conversation_redactions.count + EXCLUDED.count
+	`
+	for kind, count := range counts {
+		if _, err := s.db.Exec(query, conversationID, kind, count); err != nil {
+			log.Printf("Error recording redactions: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Redactions returns how many values of each kind have been redacted from
+// a conversation
+func (s *ConversationService) Redactions(conversationID int) (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT kind, count FROM conversation_redactions WHERE conversation_id = $1`, conversationID)
+	if err != nil {
+		log.Printf("Error loading redactions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var kind string
+		var count int
+		if err := rows.Scan(&kind, &count); err != nil {
+			return nil, err
+		}
+		counts[kind] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// conversationTitle shortens a first message into a title
+func conversationTitle(message string) string {
+	title := strings.Join(strings.Fields(message), " ")
+	if utf8.RuneCountInString(title) <= conversationTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:conversationTitleLength-1])) + "…"
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// TestFitHistory verifies the newest messages are kept within the token budget
+func TestFitHistory(t *testing.T) {
+	messages := []models.Message{
+		{ID: 1, Content: strings.Repeat("a", 400)}, // 100 tokens + overhead
+		{ID: 2, Content: strings.Repeat("b", 40)},  // 10 tokens + overhead
+		{ID: 3, Content: strings.Repeat("c", 40)},  // 10 tokens + overhead
+	}
+
+	if got := fitHistory(messages, 1000); len(got) != 3 {
+		t.Errorf("Expected all messages to fit, got %d", len(got))
+	}
+
+	got := fitHistory(messages, 50)
+	if len(got) != 2 || got[0].ID != 2 || got[1].ID != 3 {
+		t.Errorf("Expected the two newest messages in order, got %+v", got)
+	}
+
+	// A message that does not fit stops the history even if older ones would
+	if got := fitHistory(messages, 10); len(got) != 0 {
+		t.Errorf("Expected no history, got %d messages", len(got))
+	}
+}
+
+// TestConversationTitle verifies first messages are shortened into titles
+func TestConversationTitle(t *testing.T) {
+	if title := conversationTitle("  I had a\nrough day  "); title != "I had a rough day" {
+		t.Errorf("Unexpected title %q", title)
+	}
+
+	title := conversationTitle(strings.Repeat("é", 100))
+	if !strings.HasSuffix(title, "…") || len([]rune(title)) != conversationTitleLength {
+		t.Errorf("Expected title truncated to %d characters, got %q", conversationTitleLength, title)
+	}
+}
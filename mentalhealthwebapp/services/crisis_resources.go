@@ -0,0 +1,139 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CrisisResource is a helpline or service to contact in a crisis
+type CrisisResource struct {
+	Name    string `json:"name"`
+	Contact string `json:"contact"`
+	URL     string `json:"url,omitempty"`
+}
+
+// regionResources lists vetted services for one country, by need
+type regionResources struct {
+	emergency []CrisisResource
+	crisis    []CrisisResource // suicide and self-harm support
+	abuse     []CrisisResource
+}
+
+// crisisResources is keyed by ISO 3166 country code. Numbers must be checked
+// against each service's own site before they are added or changed.
+var crisisResources = map[string]regionResources{
+	"US": {
+		emergency: []CrisisResource{{Name: "Emergency services", Contact: "Call 911"}},
+		crisis: []CrisisResource{
+			{Name: "988 Suicide & Crisis Lifeline", Contact: "Call or text 988", URL: "https://988lifeline.org"},
+			{Name: "Crisis Text Line", Contact: "Text HOME to 741741", URL: "https://www.crisistextline.org"},
+		},
+		abuse: []CrisisResource{
+			{Name: "National Domestic Violence Hotline", Contact: "Call 1-800-799-7233 or text START to 88788", URL: "https://www.thehotline.org"},
+		},
+	},
+	"CA": {
+		emergency: []CrisisResource{{Name: "Emergency services", Contact: "Call 911"}},
+		crisis: []CrisisResource{
+			{Name: "9-8-8 Suicide Crisis Helpline", Contact: "Call or text 988", URL: "https://988.ca"},
+		},
+	},
+	"GB": {
+		emergency: []CrisisResource{
+			{Name: "Emergency services", Contact: "Call 999"},
+			{Name: "NHS 111", Contact: "Call 111 for urgent medical help", URL: "https://111.nhs.uk"},
+		},
+		crisis: []CrisisResource{
+			{Name: "Samaritans", Contact: "Call 116 123", URL: "https://www.samaritans.org"},
+			{Name: "Shout", Contact: "Text SHOUT to 85258", URL: "https://giveusashout.org"},
+		},
+		abuse: []CrisisResource{
+			{Name: "National Domestic Abuse Helpline", Contact: "Call 0808 2000 247", URL: "https://www.nationaldahelpline.org.uk"},
+		},
+	},
+	"IE": {
+		emergency: []CrisisResource{{Name: "Emergency services", Contact: "Call 112 or 999"}},
+		crisis: []CrisisResource{
+			{Name: "Samaritans", Contact: "Call 116 123", URL: "https://www.samaritans.org/ireland"},
+		},
+		abuse: []CrisisResource{
+			{Name: "Women's Aid", Contact: "Call 1800 341 900", URL: "https://www.womensaid.ie"},
+		},
+	},
+	"AU": {
+		emergency: []CrisisResource{{Name: "Emergency services", Contact: "Call 000"}},
+		crisis: []CrisisResource{
+			{Name: "Lifeline", Contact: "Call 13 11 14", URL: "https://www.lifeline.org.au"},
+		},
+		abuse: []CrisisResource{
+			{Name: "1800RESPECT", Contact: "Call 1800 737 732", URL: "https://www.1800respect.org.au"},
+		},
+	},
+}
+
+// internationalResources is used when the user's country is unknown or has
+// no entry above
+var internationalResources = regionResources{
+	emergency: []CrisisResource{{Name: "Emergency services", Contact: "Call your local emergency number"}},
+	crisis: []CrisisResource{
+		{Name: "Find A Helpline", Contact: "Free, confidential helplines in your country", URL: "https://findahelpline.com"},
+	},
+}
+
+// localeCountry returns the region of a locale such as "en-GB", or "" for
+// a language-only locale
+func localeCountry(locale string) string {
+	_, region, found := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	if !found {
+		return ""
+	}
+	return strings.ToUpper(region)
+}
+
+// CrisisResources returns the services to offer for the flagged categories
+// in the user's locale, most urgent first
+func CrisisResources(locale string, categories []string) []CrisisResource {
+	region, ok := crisisResources[localeCountry(locale)]
+	if !ok {
+		region = internationalResources
+	}
+	verdict := SafetyVerdict{Categories: categories}
+
+	resources := append([]CrisisResource{}, region.emergency...)
+	if verdict.Has(SafetySelfHarm) {
+		resources = append(resources, region.crisis...)
+	}
+	if verdict.Has(SafetyAbuse) {
+		resources = append(resources, region.abuse...)
+		if len(region.abuse) == 0 {
+			resources = append(resources, internationalResources.crisis...)
+		}
+	}
+	return resources
+}
+
+// crisisReply is the message sent instead of a model reply when the user
+// may be in danger
+func crisisReply(categories []string, resources []CrisisResource) string {
+	verdict := SafetyVerdict{Categories: categories}
+
+	var b strings.Builder
+	switch {
+	case verdict.Has(SafetyMedicalEmergency):
+		b.WriteString("This sounds like it could be a medical emergency. Please contact emergency services now, or ask someone near you to call for you.")
+	case verdict.Has(SafetySelfHarm):
+		b.WriteString("I'm really sorry you're going through this, and I'm concerned about your safety. You don't have to face this alone. Please reach out to someone who can help right now. If you are in immediate danger, contact emergency services.")
+	default:
+		b.WriteString("I'm sorry this is happening to you. It isn't your fault, and you deserve to be safe. If you are in immediate danger, please contact emergency services.")
+	}
+
+	b.WriteString("\n")
+	for _, r := range resources {
+		fmt.Fprintf(&b, "\n- %s: %s", r.Name, r.Contact)
+		if r.URL != "" {
+			fmt.Fprintf(&b, " (%s)", r.URL)
+		}
+	}
+	b.WriteString("\n\nI'm an AI assistant and can't provide emergency help, but I'm here to keep talking with you.")
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseScheduleNext verifies next run times for common expressions
+func TestParseScheduleNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2025, 1, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/30 * * * *", time.Date(2025, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2025, 1, 2, 3, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 15th or any Friday
+		{"0 0 15 * 5", time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2025, 1, 1, 10, 9, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected next run %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+}
+
+// TestParseScheduleRejectsInvalid verifies malformed expressions are reported
+func TestParseScheduleRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every", "@every 10ms"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+// TestSchedulerRegister verifies jobs need a valid schedule and a unique name
+func TestSchedulerRegister(t *testing.T) {
+	scheduler := NewScheduler(nil)
+	if err := scheduler.Register("cleanup", "@hourly", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Register("cleanup", "@daily", nil); err == nil {
+		t.Error("Expected duplicate job name to be rejected")
+	}
+	if err := scheduler.Register("broken", "every hour", nil); err == nil {
+		t.Error("Expected invalid schedule to be rejected")
+	}
+
+	jobs := scheduler.Jobs()
+	if len(jobs) != 1 || jobs[0].Name != "cleanup" || jobs[0].NextRunAt != nil {
+		t.Errorf("Unexpected jobs %+v", jobs)
+	}
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+	"github.com/lib/pq"
+)
+
+// ExportSession is a signed-in device, including signed-out and expired
+// ones
+type ExportSession struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	Label      *string    `json:"label"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// ExportIdentity is a linked login provider account
+type ExportIdentity struct {
+	models.Identity
+	Subject string `json:"subject"` // the provider's ID for the user
+}
+
+// ExportPersonalAccessToken is the metadata of a personal access token,
+// including revoked ones. The token hash is left out.
+type ExportPersonalAccessToken struct {
+	models.PersonalAccessToken
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// ExportChatUsage is the tokens one chat request used
+type ExportChatUsage struct {
+	ID               int64     `json:"id"`
+	ConversationID   *int      `json:"conversation_id"`
+	Provider         string    `json:"provider"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Estimated        bool      `json:"estimated"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ExportConversation is a chat conversation with all its messages and the
+// summary of its older turns
+type ExportConversation struct {
+	models.Conversation
+	Messages []models.Message     `json:"messages"`
+	Summary  *ConversationSummary `json:"summary"`
+}
+
+// UserExport is everything stored about a user
+type UserExport struct {
+	ExportedAt    time.Time                   `json:"exported_at"`
+	Profile       models.User                 `json:"profile"`
+	Moods         []models.Mood               `json:"moods"`
+	Journals      []models.Journal            `json:"journals"`
+	Sessions      []ExportSession             `json:"sessions"`
+	Identities    []ExportIdentity            `json:"identities"`
+	AccessTokens  []ExportPersonalAccessToken `json:"personal_access_tokens"`
+	Conversations []ExportConversation        `json:"conversations"`
+	SafetyEvents  []SafetyEvent               `json:"safety_events"`
+	ChatContext   []ChatContextShare          `json:"chat_context"`
+	ChatToolCalls []ChatToolResult            `json:"chat_tool_calls"`
+	ChatMemories  []ChatMemory                `json:"chat_memories"`
+	ChatRedacted  []RedactedValue             `json:"chat_redacted_values"`
+	ChatUsage     []ExportChatUsage           `json:"chat_usage"`
+}
+
+// ExportService collects a user's data for download
+type ExportService struct {
+	db *sql.DB
+}
+
+// NewExportService creates a new export service
+func NewExportService(db *sql.DB) *ExportService {
+	return &ExportService{db: db}
+}
+
+// ExportUser gathers the profile and all user-owned records of userID
+func (s *ExportService) ExportUser(userID int) (*UserExport, error) {
+	export := &UserExport{
+		ExportedAt:    time.Now().UTC(),
+		Moods:         []models.Mood{},
+		Journals:      []models.Journal{},
+		Sessions:      []ExportSession{},
+		Identities:    []ExportIdentity{},
+		AccessTokens:  []ExportPersonalAccessToken{},
+		Conversations: []ExportConversation{},
+		SafetyEvents:  []SafetyEvent{},
+		ChatContext:   []ChatContextShare{},
+		ChatToolCalls: []ChatToolResult{},
+		ChatMemories:  []ChatMemory{},
+		ChatRedacted:  []RedactedValue{},
+		ChatUsage:     []ExportChatUsage{},
+	}
+
+	p := &export.Profile
+	query := `SELECT id, name, email, timezone, locale, avatar_url, email_verified_at, created_at FROM users WHERE id = $1`
+	err := s.db.QueryRow(query, userID).Scan(&p.ID, &p.Name, &p.Email, &p.Timezone, &p.Locale, &p.AvatarURL, &p.EmailVerifiedAt, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT id, user_id, mood, COALESCE(note, ''), created_at, updated_at FROM moods WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.Mood
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		export.Moods = append(export.Moods, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT id, user_id, title, body, created_at, updated_at FROM journals WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var j models.Journal
+		if err := rows.Scan(&j.ID, &j.UserID, &j.Title, &j.Body, &j.CreatedAt, &j.UpdatedAt); err != nil {
+			return nil, err
+		}
+		export.Journals = append(export.Journals, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), label, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions WHERE user_id = $1
+		ORDER BY created_at
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session ExportSession
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress, &session.Label,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+			return nil, err
+		}
+		export.Sessions = append(export.Sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identity ExportIdentity
+		if err := rows.Scan(&identity.ID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, err
+		}
+		export.Identities = append(export.Identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, name, token_prefix, scopes, created_at, last_used_at, expires_at, revoked_at
+		FROM personal_access_tokens WHERE user_id = $1
+		ORDER BY created_at
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var token ExportPersonalAccessToken
+		if err := rows.Scan(&token.ID, &token.Name, &token.TokenPrefix, pq.Array(&token.Scopes),
+			&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt); err != nil {
+			return nil, err
+		}
+		export.AccessTokens = append(export.AccessTokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT id, title, persona_id, created_at, updated_at FROM conversations WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := make(map[int]int) // conversation ID to index in export.Conversations
+	for rows.Next() {
+		var conversation ExportConversation
+		if err := rows.Scan(&conversation.ID, &conversation.Title, &conversation.PersonaID, &conversation.CreatedAt, &conversation.UpdatedAt); err != nil {
+			return nil, err
+		}
+		conversation.Messages = []models.Message{}
+		byID[conversation.ID] = len(export.Conversations)
+		export.Conversations = append(export.Conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT m.id, m.conversation_id, m.role, m.content, m.persona_version_id, m.created_at
+		FROM messages m JOIN conversations c ON c.id = m.conversation_id
+		WHERE c.user_id = $1
+		ORDER BY m.id
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.PersonaVersionID, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[m.ConversationID]; ok {
+			export.Conversations[i].Messages = append(export.Conversations[i].Messages, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT s.conversation_id, s.content, s.through_message_id, s.updated_at
+		FROM conversation_summaries s JOIN conversations c ON c.id = s.conversation_id
+		WHERE c.user_id = $1
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var summary ConversationSummary
+		if err := rows.Scan(&summary.ConversationID, &summary.Content, &summary.ThroughMessageID, &summary.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[summary.ConversationID]; ok {
+			export.Conversations[i].Summary = &summary
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, conversation_id, message_id, source, categories, action, created_at
+		FROM safety_events WHERE user_id = $1
+		ORDER BY created_at
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e SafetyEvent
+		if err := rows.Scan(&e.ID, &e.ConversationID, &e.MessageID, &e.Source, pq.Array(&e.Categories), &e.Action, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.SafetyEvents = append(export.SafetyEvents, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, conversation_id, message_id, content, mood_ids, journal_ids, created_at
+		FROM chat_context_shares WHERE user_id = $1
+		ORDER BY id
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share ChatContextShare
+		if err := rows.Scan(&share.ID, &share.ConversationID, &share.MessageID, &share.Content, pq.Array(&share.MoodIDs), pq.Array(&share.JournalIDs), &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.ChatContext = append(export.ChatContext, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`SELECT `+chatToolColumns+` FROM chat_tool_calls WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanToolResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		export.ChatToolCalls = append(export.ChatToolCalls, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if export.ChatMemories, err = NewChatMemoryService(s.db).List(userID); err != nil {
+		return nil, err
+	}
+	if export.ChatRedacted, err = NewChatRedactionService(s.db).List(userID); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT id, conversation_id, provider, prompt_tokens, completion_tokens, total_tokens, estimated, created_at
+		FROM chat_usage WHERE user_id = $1
+		ORDER BY id
+	`
+	rows, err = s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u ExportChatUsage
+		if err := rows.Scan(&u.ID, &u.ConversationID, &u.Provider, &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens, &u.Estimated, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.ChatUsage = append(export.ChatUsage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// WriteZip writes the export as a ZIP archive with one JSON file per section
+func (e *UserExport) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"manifest.json", map[string]time.Time{"exported_at": e.ExportedAt}},
+		{"profile.json", e.Profile},
+		{"moods.json", e.Moods},
+		{"journals.json", e.Journals},
+		{"sessions.json", e.Sessions},
+		{"identities.json", e.Identities},
+		{"personal_access_tokens.json", e.AccessTokens},
+		{"conversations.json", e.Conversations},
+		{"safety_events.json", e.SafetyEvents},
+		{"chat_context.json", e.ChatContext},
+		{"chat_tool_calls.json", e.ChatToolCalls},
+		{"chat_memories.json", e.ChatMemories},
+		{"chat_redacted_values.json", e.ChatRedacted},
+		{"chat_usage.json", e.ChatUsage},
+	}
+
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: e.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
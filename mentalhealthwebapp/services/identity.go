@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/utils"
+	"golang.org/x/oauth2"
+)
+
+// Purposes of an OIDC authorization request
+const (
+	OIDCPurposeLogin = "login"
+	OIDCPurposeLink  = "link"
+)
+
+// OIDCStateTTL is how long the user has to complete the provider's login
+const OIDCStateTTL = 10 * time.Minute
+
+// Identity errors
+var (
+	ErrInvalidOIDCState        = errors.New("invalid or expired OIDC state")
+	ErrOIDCEmailRequired       = errors.New("provider did not return a verified email address")
+	ErrIdentityEmailInUse      = errors.New("an account with this email already exists")
+	ErrIdentityLinkedElsewhere = errors.New("identity is linked to another account")
+	ErrProviderAlreadyLinked   = errors.New("another identity from this provider is already linked")
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrLastLoginMethod         = errors.New("cannot unlink the only way to sign in")
+)
+
+// OIDCState is a consumed authorization request
+type OIDCState struct {
+	Provider string
+	Purpose  string
+	UserID   int // set when linking
+	Nonce    string
+	Verifier string
+}
+
+// IdentityService links OpenID Connect identities to users and tracks
+// in-flight authorization requests
+type IdentityService struct {
+	db *sql.DB
+}
+
+// NewIdentityService creates a new identity service
+func NewIdentityService(db *sql.DB) *IdentityService {
+	return &IdentityService{db: db}
+}
+
+// CreateState records a new authorization request and returns its state,
+// nonce and PKCE verifier. Only the state's hash is stored.
+func (s *IdentityService) CreateState(provider, purpose string, userID int) (state, nonce, verifier string, err error) {
+	if state, err = utils.GenerateSecureToken(); err != nil {
+		return "", "", "", err
+	}
+	if nonce, err = utils.GenerateSecureToken(); err != nil {
+		return "", "", "", err
+	}
+	verifier = oauth2.GenerateVerifier()
+
+	query := `
+		INSERT INTO oidc_states (state_hash, provider, purpose, user_id, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6, $7)
+	`
+	_, err = s.db.Exec(query, utils.HashToken(state), provider, purpose, userID, nonce, verifier, time.Now().Add(OIDCStateTTL))
+	if err != nil {
+		log.Printf("Error storing OIDC state: %v", err)
+		return "", "", "", err
+	}
+
+	return state, nonce, verifier, nil
+}
+
+// ConsumeState returns and deletes the authorization request for state. A
+// state can only be used once, and only with the provider it was issued for.
+func (s *IdentityService) ConsumeState(provider, state string) (*OIDCState, error) {
+	var st OIDCState
+	var userID sql.NullInt64
+
+	query := `
+		DELETE FROM oidc_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING provider, purpose, user_id, nonce, code_verifier
+	`
+	err := s.db.QueryRow(query, utils.HashToken(state), provider).Scan(&st.Provider, &st.Purpose, &userID, &st.Nonce, &st.Verifier)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidOIDCState
+		}
+		log.Printf("Error consuming OIDC state: %v", err)
+		return nil, err
+	}
+
+	st.UserID = int(userID.Int64)
+	return &st, nil
+}
+
+// DeleteExpiredStates removes authorization requests that were abandoned
+func (s *IdentityService) DeleteExpiredStates(ctx context.Context) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM oidc_states WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Printf("Error deleting expired OIDC states: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// ResolveLogin returns the user an identity signs in as. Unknown identities
+// get a new account, unless their email already belongs to a user: that
+// user must sign in and link the identity first, so a provider cannot be
+// used to take over an existing account.
+func (s *IdentityService) ResolveLogin(identity *OIDCIdentity) (int, bool, error) {
+	var userID int
+	query := `
+		UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = COALESCE(NULLIF($3, ''), email)
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id
+	`
+	err := s.db.QueryRow(query, identity.Provider, identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		return userID, false, nil
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Error looking up identity: %v", err)
+		return 0, false, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, false, ErrOIDCEmailRequired
+	}
+
+	userID, err = s.createUser(identity)
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, true, nil
+}
+
+// createUser creates a password-less account for a new identity
+func (s *IdentityService) createUser(identity *OIDCIdentity) (int, error) {
+	name := strings.TrimSpace(identity.Name)
+	if name == "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `
+		INSERT INTO users (name, email, password_hash, email_verified_at)
+		VALUES ($1, $2, NULL, CURRENT_TIMESTAMP)
+		ON CONFLICT ((LOWER(email))) DO NOTHING
+		RETURNING id
+	`
+	err = tx.QueryRow(query, name, identity.Email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrIdentityEmailInUse
+	}
+	if err != nil {
+		log.Printf("Error creating user from identity: %v", err)
+		return 0, err
+	}
+
+	query = `INSERT INTO user_identities (user_id, provider, subject, email, last_login_at) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)`
+	if _, err := tx.Exec(query, userID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		log.Printf("Error storing identity: %v", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Link attaches an identity to userID. Linking the same identity again is a
+// no-op.
+func (s *IdentityService) Link(userID int, identity *OIDCIdentity) error {
+	var ownerID int
+	err := s.db.QueryRow(`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`, identity.Provider, identity.Subject).Scan(&ownerID)
+	if err == nil {
+		if ownerID != userID {
+			return ErrIdentityLinkedElsewhere
+		}
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Error looking up identity: %v", err)
+		return err
+	}
+
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT DO NOTHING
+	`
+	result, err := s.db.Exec(query, userID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Printf("Error linking identity: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrProviderAlreadyLinked
+	}
+
+	return nil
+}
+
+// ListForUser returns the identities linked to userID
+func (s *IdentityService) ListForUser(userID int) ([]models.Identity, error) {
+	query := `SELECT id, provider, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		log.Printf("Error listing identities: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(&identity.ID, &identity.Provider, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// Unlink removes an identity from userID, refusing to remove the last way
+// to sign in to an account without a password
+func (s *IdentityService) Unlink(userID, identityID int) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent unlinks cannot both pass the check
+	var hasPassword bool
+	var identityCount int
+	query := `SELECT password_hash IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, userID).Scan(&hasPassword); err != nil {
+		log.Printf("Error loading user for unlink: %v", err)
+		return "", err
+	}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM user_identities WHERE user_id = $1`, userID).Scan(&identityCount); err != nil {
+		log.Printf("Error counting identities: %v", err)
+		return "", err
+	}
+
+	var provider string
+	query = `DELETE FROM user_identities WHERE id = $1 AND user_id = $2 RETURNING provider`
+	if err := tx.QueryRow(query, identityID, userID).Scan(&provider); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrIdentityNotFound
+		}
+		log.Printf("Error unlinking identity: %v", err)
+		return "", err
+	}
+
+	if !hasPassword && identityCount <= 1 {
+		return "", ErrLastLoginMethod
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return provider, nil
+}
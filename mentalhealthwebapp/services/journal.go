@@ -0,0 +1,42 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// ValidateJournal checks journal field lengths, returning an error message or ""
+func ValidateJournal(title, body string) string {
+	if len(title) > 100 {
+		return "Title must be 100 characters or less"
+	}
+	if len(body) > 5000 {
+		return "Body must be 5000 characters or less"
+	}
+	return ""
+}
+
+// JournalService writes journal entries
+type JournalService struct {
+	db *sql.DB
+}
+
+// NewJournalService creates a new journal service
+func NewJournalService(db *sql.DB) *JournalService {
+	return &JournalService{db: db}
+}
+
+// Create adds a journal entry for userID. The values must have passed
+// ValidateJournal.
+func (s *JournalService) Create(userID int, title, body string) (*models.Journal, error) {
+	j := models.Journal{UserID: userID, Title: title, Body: body}
+	query := `INSERT INTO journals (user_id, title, body, created_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	if err := s.db.QueryRow(query, userID, title, body, time.Now()).Scan(&j.ID, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		log.Printf("Insert error: %v", err)
+		return nil, err
+	}
+	return &j, nil
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginAttemptStore persists failed login counters per key (an account or
+// a client IP)
+type LoginAttemptStore interface {
+	// RecordFailure increments the failure count for key, starting over if
+	// the previous failure is older than window, and returns the new count
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	// Lock blocks key until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil returns when the lock on key expires (zero if never locked)
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset forgets all failures and locks for key
+	Reset(ctx context.Context, key string) error
+	// DeleteStale forgets keys whose last failure is before the given time
+	// and that are not locked, returning how many were removed
+	DeleteStale(ctx context.Context, before time.Time) (int, error)
+}
+
+// LoginLimiterConfig controls when and for how long logins are locked out
+type LoginLimiterConfig struct {
+	AccountThreshold int           // failures per account before it is locked
+	IPThreshold      int           // failures per client IP before it is locked
+	BaseLockout      time.Duration // first lockout, doubled on every further failure
+	MaxLockout       time.Duration // upper bound of a single lockout
+	Window           time.Duration // failures older than this are forgotten
+}
+
+// DefaultLoginLimiterConfig returns the default thresholds
+func DefaultLoginLimiterConfig() LoginLimiterConfig {
+	return LoginLimiterConfig{
+		AccountThreshold: 5,
+		IPThreshold:      20,
+		BaseLockout:      time.Minute,
+		MaxLockout:       time.Hour,
+		Window:           24 * time.Hour,
+	}
+}
+
+// LoginLimiterConfigFromEnv reads LOGIN_ACCOUNT_THRESHOLD, LOGIN_IP_THRESHOLD,
+// LOGIN_BASE_LOCKOUT and LOGIN_MAX_LOCKOUT, falling back to the defaults
+func LoginLimiterConfigFromEnv() LoginLimiterConfig {
+	cfg := DefaultLoginLimiterConfig()
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_ACCOUNT_THRESHOLD")); err == nil && n > 0 {
+		cfg.AccountThreshold = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LOGIN_IP_THRESHOLD")); err == nil && n > 0 {
+		cfg.IPThreshold = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_BASE_LOCKOUT")); err == nil && d > 0 {
+		cfg.BaseLockout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_MAX_LOCKOUT")); err == nil && d > 0 {
+		cfg.MaxLockout = d
+	}
+	return cfg
+}
+
+// LoginLimiter applies exponential backoff to failed logins per account and
+// per client IP
+type LoginLimiter struct {
+	store  LoginAttemptStore
+	config LoginLimiterConfig
+	now    func() time.Time
+}
+
+// NewLoginLimiter creates a limiter backed by store
+func NewLoginLimiter(store LoginAttemptStore, config LoginLimiterConfig) *LoginLimiter {
+	return &LoginLimiter{store: store, config: config, now: time.Now}
+}
+
+// accountKey and ipKey namespace the two kinds of counters
+func accountKey(email string) string { return "account:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+// Check returns how long the caller must wait before trying to log in
+// again, or zero if neither the account nor the IP is locked
+func (l *LoginLimiter) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := l.now()
+	var wait time.Duration
+
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		until, err := l.store.LockedUntil(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining := until.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, nil
+}
+
+// RecordFailure counts a failed login and locks the account and/or IP once
+// their threshold is reached. It reports whether the account was locked by
+// this failure.
+func (l *LoginLimiter) RecordFailure(ctx context.Context, email, ip string) (bool, error) {
+	now := l.now()
+
+	accountLocked, err := l.recordFailure(ctx, accountKey(email), l.config.AccountThreshold, now)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := l.recordFailure(ctx, ipKey(ip), l.config.IPThreshold, now); err != nil {
+		return accountLocked, err
+	}
+
+	return accountLocked, nil
+}
+
+// recordFailure increments one counter and applies its lockout
+func (l *LoginLimiter) recordFailure(ctx context.Context, key string, threshold int, now time.Time) (bool, error) {
+	failures, err := l.store.RecordFailure(ctx, key, now, l.config.Window)
+	if err != nil {
+		return false, err
+	}
+
+	if failures < threshold {
+		return false, nil
+	}
+
+	if err := l.store.Lock(ctx, key, now.Add(l.lockoutFor(failures-threshold))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lockoutFor returns BaseLockout doubled for every failure past the threshold
+func (l *LoginLimiter) lockoutFor(extraFailures int) time.Duration {
+	lockout := l.config.BaseLockout
+	for i := 0; i < extraFailures && lockout < l.config.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > l.config.MaxLockout {
+		lockout = l.config.MaxLockout
+	}
+	return lockout
+}
+
+// Prune forgets failures older than the counting window
+func (l *LoginLimiter) Prune(ctx context.Context) (int, error) {
+	return l.store.DeleteStale(ctx, l.now().Add(-l.config.Window))
+}
+
+// RecordSuccess clears the account's failures. The IP counter is kept so a
+// valid login cannot be used to reset guessing against other accounts.
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, email string) error {
+	return l.store.Reset(ctx, accountKey(email))
+}
+
+// UnlockAccount clears failures and any lockout for an account
+func (l *LoginLimiter) UnlockAccount(ctx context.Context, email string) error {
+	return l.store.Reset(ctx, accountKey(email))
+}
+
+// UnlockIP clears failures and any lockout for a client IP
+func (l *LoginLimiter) UnlockIP(ctx context.Context, ip string) error {
+	return l.store.Reset(ctx, ipKey(ip))
+}
+
+// PostgresLoginAttemptStore keeps counters in the login_attempts table so
+// they are shared by all replicas
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+// NewPostgresLoginAttemptStore creates a Postgres-backed store
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+// RecordFailure increments the counter for key
+func (s *PostgresLoginAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = $2
+		RETURNING failures
+	`
+	err := s.db.QueryRowContext(ctx, query, key, now, now.Add(-window)).Scan(&failures)
+	if err != nil {
+		log.Printf("Error recording login failure: %v", err)
+		return 0, err
+	}
+	return failures, nil
+}
+
+// Lock blocks key until the given time
+func (s *PostgresLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`, key, until)
+	if err != nil {
+		log.Printf("Error locking login: %v", err)
+	}
+	return err
+}
+
+// LockedUntil returns when the lock on key expires
+func (s *PostgresLoginAttemptStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var until sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT locked_until FROM login_attempts WHERE key = $1`, key).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		log.Printf("Error checking login lockout: %v", err)
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+// Reset forgets key
+func (s *PostgresLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	if err != nil {
+		log.Printf("Error resetting login attempts: %v", err)
+	}
+	return err
+}
+
+// DeleteStale forgets keys that no longer affect logins
+func (s *PostgresLoginAttemptStore) DeleteStale(ctx context.Context, before time.Time) (int, error) {
+	query := `DELETE FROM login_attempts WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`
+	result, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		log.Printf("Error deleting stale login attempts: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// MemoryLoginAttemptStore keeps counters in process memory. Suitable for a
+// single instance or for tests.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryLoginAttempt
+}
+
+type memoryLoginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+// NewMemoryLoginAttemptStore creates an in-memory store
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: make(map[string]*memoryLoginAttempt)}
+}
+
+// RecordFailure increments the counter for key
+func (s *MemoryLoginAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &memoryLoginAttempt{}
+		s.attempts[key] = attempt
+	}
+	if attempt.lastFailureAt.Before(now.Add(-window)) {
+		attempt.failures = 0
+	}
+	attempt.failures++
+	attempt.lastFailureAt = now
+
+	return attempt.failures, nil
+}
+
+// Lock blocks key until the given time
+func (s *MemoryLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.lockedUntil = until
+	}
+	return nil
+}
+
+// LockedUntil returns when the lock on key expires
+func (s *MemoryLoginAttemptStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		return attempt.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// Reset forgets key
+func (s *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// DeleteStale forgets keys that no longer affect logins
+func (s *MemoryLoginAttemptStore) DeleteStale(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for key, attempt := range s.attempts {
+		if attempt.lastFailureAt.Before(before) && !attempt.lockedUntil.After(time.Now()) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
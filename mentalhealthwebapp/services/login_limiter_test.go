@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with an in-memory store and a clock the test controls
+func newTestLimiter() (*LoginLimiter, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLoginLimiter(NewMemoryLoginAttemptStore(), LoginLimiterConfig{
+		AccountThreshold: 3,
+		IPThreshold:      10,
+		BaseLockout:      time.Minute,
+		MaxLockout:       10 * time.Minute,
+		Window:           time.Hour,
+	})
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+// TestLoginLimiterLocksAccountWithBackoff verifies the lockout starts at the threshold and doubles
+func TestLoginLimiterLocksAccountWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	limiter, now := newTestLimiter()
+
+	for i := 1; i <= 2; i++ {
+		locked, err := limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1")
+		if err != nil || locked {
+			t.Fatalf("Failure %d: expected no lock, got locked=%v err=%v", i, locked, err)
+		}
+	}
+
+	locked, _ := limiter.RecordFailure(ctx, "Jane@Example.com", "10.0.0.2")
+	if !locked {
+		t.Fatal("Expected account to be locked on the third failure")
+	}
+
+	if wait, _ := limiter.Check(ctx, "jane@example.com", "10.0.0.3"); wait != time.Minute {
+		t.Errorf("Expected 1m lockout, got %v", wait)
+	}
+
+	*now = now.Add(time.Minute)
+	if wait, _ := limiter.Check(ctx, "jane@example.com", "10.0.0.3"); wait != 0 {
+		t.Errorf("Expected lockout to expire, got %v", wait)
+	}
+
+	limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.3")
+	if wait, _ := limiter.Check(ctx, "jane@example.com", "10.0.0.3"); wait != 2*time.Minute {
+		t.Errorf("Expected doubled 2m lockout, got %v", wait)
+	}
+}
+
+// TestLoginLimiterCapsLockout verifies the lockout never exceeds MaxLockout
+func TestLoginLimiterCapsLockout(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+
+	for i := 0; i < 20; i++ {
+		limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1")
+	}
+
+	if wait, _ := limiter.Check(ctx, "jane@example.com", "10.0.0.9"); wait != 10*time.Minute {
+		t.Errorf("Expected lockout capped at 10m, got %v", wait)
+	}
+}
+
+// TestLoginLimiterLocksIPAcrossAccounts verifies guessing many accounts from one IP is locked
+func TestLoginLimiterLocksIPAcrossAccounts(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+
+	for i := 0; i < 10; i++ {
+		limiter.RecordFailure(ctx, string(rune('a'+i))+"@example.com", "10.0.0.1")
+	}
+
+	if wait, _ := limiter.Check(ctx, "new@example.com", "10.0.0.1"); wait == 0 {
+		t.Error("Expected IP to be locked")
+	}
+	if wait, _ := limiter.Check(ctx, "new@example.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected other IPs to be unaffected, got %v", wait)
+	}
+}
+
+// TestLoginLimiterSuccessAndUnlockReset verifies successful logins and admin unlocks clear the account
+func TestLoginLimiterSuccessAndUnlockReset(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestLimiter()
+
+	limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1")
+	limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1")
+	limiter.RecordSuccess(ctx, "jane@example.com")
+
+	if locked, _ := limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1"); locked {
+		t.Error("Expected success to reset the failure count")
+	}
+
+	for i := 0; i < 3; i++ {
+		limiter.RecordFailure(ctx, "jane@example.com", "10.0.0.1")
+	}
+	limiter.UnlockAccount(ctx, "jane@example.com")
+
+	if wait, _ := limiter.Check(ctx, "jane@example.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected unlock to clear the lockout, got %v", wait)
+	}
+}
+
+// TestLoginLimiterPrune verifies failures outside the window are forgotten
+func TestLoginLimiterPrune(t *testing.T) {
+	ctx := context.Background()
+	limiter, now := newTestLimiter()
+
+	limiter.RecordFailure(ctx, "old@example.com", "10.0.0.1")
+	*now = now.Add(2 * time.Hour)
+	limiter.RecordFailure(ctx, "new@example.com", "10.0.0.2")
+
+	// The old account and IP are forgotten; the new ones are kept
+	if n, err := limiter.Prune(ctx); err != nil || n != 2 {
+		t.Fatalf("Expected 2 stale keys to be pruned, got %d (err=%v)", n, err)
+	}
+	if n, _ := limiter.Prune(ctx); n != 0 {
+		t.Errorf("Expected nothing left to prune, got %d", n)
+	}
+}
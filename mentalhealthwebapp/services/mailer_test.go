@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFileMailerWritesMessage verifies that messages land in the mail directory
+func TestFileMailerWritesMessage(t *testing.T) {
+	dir := t.TempDir()
+
+	mailer, err := NewFileMailer(dir, "App <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), Message{
+		To:      "jane@example.com",
+		Subject: "Verify your email address",
+		Body:    "Open this link:\nhttp://localhost:3000/verify-email?token=abc",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one message file, got %d (%v)", len(entries), err)
+	}
+
+	data, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), "To: jane@example.com\r\n") {
+		t.Errorf("Expected To header, got:\n%s", data)
+	}
+	if !strings.Contains(string(data), "verify-email?token=abc") {
+		t.Errorf("Expected link in body, got:\n%s", data)
+	}
+}
+
+// TestFormatMessageStripsHeaderInjection verifies that line breaks cannot add headers
+func TestFormatMessageStripsHeaderInjection(t *testing.T) {
+	msg := formatMessage("app@example.com", Message{
+		To:      "jane@example.com",
+		Subject: "Hello\r\nBcc: attacker@example.com",
+	})
+
+	if strings.Contains(string(msg), "\r\nBcc:") {
+		t.Errorf("Header injection was not stripped:\n%s", msg)
+	}
+}
+
+// TestSMTPMailerStopsWithContext verifies a server that never answers cannot
+// hold a request past its context's deadline
+func TestSMTPMailerStopsWithContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept connections and never send the greeting
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	mailer := NewSMTPMailer(host, port, "", "", "app@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = mailer.Send(ctx, Message{To: "jane@example.com", Subject: "Hi", Body: "Hello"})
+	if err == nil {
+		t.Fatal("Expected an error from a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected Send to give up with the context, took %v", elapsed)
+	}
+}
+
+// TestNewMailerFromEnvRequiresMailer verifies the server will not start
+// without a mailer chosen
+func TestNewMailerFromEnvRequiresMailer(t *testing.T) {
+	t.Setenv("MAILER", "")
+	if _, err := NewMailerFromEnv(); err == nil {
+		t.Error("Expected an error when MAILER is not set")
+	}
+
+	t.Setenv("MAILER", "log")
+	if _, err := NewMailerFromEnv(); err != nil {
+		t.Errorf("Expected the log mailer, got %v", err)
+	}
+}
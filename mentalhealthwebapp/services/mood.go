@@ -0,0 +1,92 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// MoodNames lists the moods a user can log
+var MoodNames = []string{"happy", "sad", "anxious", "calm", "angry", "excited", "tired", "neutral"}
+
+// ValidMoods is MoodNames as a set
+var ValidMoods = func() map[string]bool {
+	valid := make(map[string]bool, len(MoodNames))
+	for _, mood := range MoodNames {
+		valid[mood] = true
+	}
+	return valid
+}()
+
+// ValidateMood checks a mood value and note, returning an error message or ""
+func ValidateMood(mood, note string) string {
+	if !ValidMoods[mood] {
+		return "Invalid mood. Valid options: " + strings.Join(MoodNames, ", ")
+	}
+	if len(note) > 500 {
+		return "Note must be 500 characters or less"
+	}
+	return ""
+}
+
+// MoodSummary is how a user's moods went over recent days
+type MoodSummary struct {
+	Days   int            `json:"days"`
+	Counts map[string]int `json:"counts"`
+	Recent []models.Mood  `json:"recent"` // newest first
+}
+
+// MoodService logs and summarizes moods
+type MoodService struct {
+	db *sql.DB
+}
+
+// NewMoodService creates a new mood service
+func NewMoodService(db *sql.DB) *MoodService {
+	return &MoodService{db: db}
+}
+
+// Create logs a mood for userID. The values must have passed ValidateMood.
+func (s *MoodService) Create(userID int, mood, note string) (*models.Mood, error) {
+	m := models.Mood{UserID: userID, Mood: mood, Note: note}
+	query := `INSERT INTO moods (user_id, mood, note, created_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	if err := s.db.QueryRow(query, userID, mood, note, time.Now()).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		log.Printf("Insert error: %v", err)
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Summary counts userID's moods over the last days days and returns the
+// latest few
+func (s *MoodService) Summary(userID, days int) (*MoodSummary, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	summary := &MoodSummary{Days: days, Counts: map[string]int{}, Recent: []models.Mood{}}
+
+	query := `
+		SELECT id, user_id, COALESCE(mood, ''), COALESCE(note, ''), created_at, updated_at
+		FROM moods WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at DESC
+	`
+	rows, err := s.db.Query(query, userID, since)
+	if err != nil {
+		log.Printf("Error loading mood summary: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m models.Mood
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Mood, &m.Note, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		summary.Counts[m.Mood]++
+		if len(summary.Recent) < 5 {
+			summary.Recent = append(summary.Recent, m)
+		}
+	}
+
+	return summary, rows.Err()
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrUnknownOIDCProvider is returned for provider names that are not configured
+var ErrUnknownOIDCProvider = errors.New("unknown OIDC provider")
+
+// oidcHTTPTimeout bounds each request to a provider's discovery and key
+// endpoints, so an unresponsive provider cannot hang logins
+const oidcHTTPTimeout = 10 * time.Second
+
+// OIDCProviderConfig describes one OpenID Connect provider
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCIdentity is the verified identity returned by a provider
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against one
+// provider
+type OIDCProvider struct {
+	config   OIDCProviderConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider fetches the provider's discovery document
+func NewOIDCProvider(ctx context.Context, config OIDCProviderConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover OIDC provider %s: %w", config.Name, err)
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	return &OIDCProvider{
+		config: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// AuthCodeURL returns the URL to send the user to. state and nonce tie the
+// callback and ID token to this request; verifier is the PKCE secret.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems an authorization code and verifies the ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCIdentity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("decode ID token claims: %w", err)
+	}
+
+	return &OIDCIdentity{
+		Provider:      p.config.Name,
+		Subject:       idToken.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// oidcRegistryEntry is a configured provider, discovered on first use. mu
+// is held during discovery, so it only holds up logins with that provider.
+type oidcRegistryEntry struct {
+	config   OIDCProviderConfig
+	mu       sync.Mutex
+	provider *OIDCProvider
+}
+
+// OIDCRegistry holds the configured providers. Discovery happens on first
+// use so an unreachable provider does not prevent startup.
+type OIDCRegistry struct {
+	entries map[string]*oidcRegistryEntry
+	client  *http.Client
+}
+
+// NewOIDCRegistry creates a registry for configs
+func NewOIDCRegistry(configs []OIDCProviderConfig) *OIDCRegistry {
+	r := &OIDCRegistry{
+		entries: make(map[string]*oidcRegistryEntry),
+		client:  &http.Client{Timeout: oidcHTTPTimeout},
+	}
+	for _, config := range configs {
+		r.entries[config.Name] = &oidcRegistryEntry{config: config}
+	}
+	return r
+}
+
+// Names returns the configured provider names in alphabetical order
+func (r *OIDCRegistry) Names() []string {
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Provider returns the named provider, discovering it if needed. A failed
+// discovery is retried on the next call.
+func (r *OIDCRegistry) Provider(name string) (*OIDCProvider, error) {
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.provider != nil {
+		return entry.provider, nil
+	}
+
+	// Signing keys are fetched later with this context, so it must outlive
+	// the request that triggered discovery; the client's timeout bounds
+	// each fetch instead
+	ctx := oidc.ClientContext(context.Background(), r.client)
+	provider, err := NewOIDCProvider(ctx, entry.config)
+	if err != nil {
+		return nil, err
+	}
+	entry.provider = provider
+	return provider, nil
+}
+
+// OIDCConfigsFromEnv reads OIDC_PROVIDERS, a comma-separated list of names,
+// and for each name OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optionally _SCOPES (space-separated) and _REDIRECT_URL. The redirect URL
+// defaults to API_BASE_URL + /api/auth/oidc/<name>/callback.
+func OIDCConfigsFromEnv() ([]OIDCProviderConfig, error) {
+	var configs []OIDCProviderConfig
+
+	apiBaseURL := strings.TrimRight(os.Getenv("API_BASE_URL"), "/")
+	if apiBaseURL == "" {
+		apiBaseURL = "http://localhost:8080"
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID must be set", prefix, prefix)
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = apiBaseURL + "/api/auth/oidc/" + name + "/callback"
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
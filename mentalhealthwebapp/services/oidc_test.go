@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/leketech/mental-health-app/utils"
+	"golang.org/x/oauth2"
+)
+
+// standInOIDCProvider is a minimal OpenID Connect provider for tests. It
+// issues one code per authorization request and enforces PKCE.
+type standInOIDCProvider struct {
+	server *httptest.Server
+	keys   *utils.KeySet
+
+	mu       sync.Mutex
+	requests map[string]url.Values // authorization request parameters by code
+	subject  string
+	email    string
+}
+
+func newStandInOIDCProvider(t *testing.T) *standInOIDCProvider {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := utils.ParseKeySet(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &standInOIDCProvider{
+		keys:     keys,
+		requests: make(map[string]url.Values),
+		subject:  "user-123",
+		email:    "Jane@Example.com",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.keys.JWKS())
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize simulates the user signing in at authURL and returns the code
+// the provider would redirect back with
+func (p *standInOIDCProvider) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		t.Fatalf("Expected a PKCE S256 challenge, got %s", u.RawQuery)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := "code-" + params.Get("state")
+	p.requests[code] = params
+	return code
+}
+
+// token redeems a code after checking the PKCE verifier
+func (p *standInOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	p.mu.Lock()
+	params, ok := p.requests[r.PostForm.Get("code")]
+	delete(p.requests, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != params.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, _ := p.keys.Sign(jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            params.Get("client_id"),
+		"sub":            p.subject,
+		"email":          p.email,
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"nonce":          params.Get("nonce"),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCProvider(t *testing.T, standIn *standInOIDCProvider) *OIDCProvider {
+	t.Helper()
+
+	provider, err := NewOIDCProvider(context.Background(), OIDCProviderConfig{
+		Name:         "local",
+		Issuer:       standIn.server.URL,
+		ClientID:     "mental-health-app",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/local/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// TestOIDCProviderAuthorizationCodeFlow verifies a full login against the
+// stand-in provider
+func TestOIDCProviderAuthorizationCodeFlow(t *testing.T) {
+	standIn := newStandInOIDCProvider(t)
+	provider := newTestOIDCProvider(t, standIn)
+
+	verifier := oauth2.GenerateVerifier()
+	code := standIn.authorize(t, provider.AuthCodeURL("state-1", "nonce-1", verifier))
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	if identity.Provider != "local" || identity.Subject != "user-123" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	if identity.Email != "jane@example.com" || !identity.EmailVerified || identity.Name != "Jane Doe" {
+		t.Errorf("Unexpected profile %+v", identity)
+	}
+}
+
+// TestOIDCProviderRejectsWrongVerifierOrNonce verifies PKCE and nonce checks
+func TestOIDCProviderRejectsWrongVerifierOrNonce(t *testing.T) {
+	standIn := newStandInOIDCProvider(t)
+	provider := newTestOIDCProvider(t, standIn)
+
+	verifier := oauth2.GenerateVerifier()
+	code := standIn.authorize(t, provider.AuthCodeURL("state-1", "nonce-1", verifier))
+	if _, err := provider.Exchange(context.Background(), code, oauth2.GenerateVerifier(), "nonce-1"); err == nil {
+		t.Error("Expected exchange with the wrong PKCE verifier to fail")
+	}
+
+	code = standIn.authorize(t, provider.AuthCodeURL("state-2", "nonce-2", verifier))
+	if _, err := provider.Exchange(context.Background(), code, verifier, "another-nonce"); err == nil {
+		t.Error("Expected ID token with the wrong nonce to be rejected")
+	}
+}
+
+// TestOIDCRegistryDiscoversProvidersIndependently verifies a provider that
+// hangs during discovery does not hold up logins with another
+func TestOIDCRegistryDiscoversProvidersIndependently(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(func() { close(release) }) // runs first, so Close can finish
+
+	standIn := newStandInOIDCProvider(t)
+	registry := NewOIDCRegistry([]OIDCProviderConfig{
+		{Name: "hanging", Issuer: hanging.URL, ClientID: "mental-health-app"},
+		{Name: "local", Issuer: standIn.server.URL, ClientID: "mental-health-app"},
+	})
+
+	go registry.Provider("hanging")
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := registry.Provider("local")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected discovery to succeed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected discovery not to wait for the hanging provider")
+	}
+
+	if _, err := registry.Provider("unknown"); err != ErrUnknownOIDCProvider {
+		t.Errorf("Expected ErrUnknownOIDCProvider, got %v", err)
+	}
+}
+
+// TestOIDCConfigsFromEnv verifies provider configuration and redirect defaults
+func TestOIDCConfigsFromEnv(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "google, local-dev")
+	t.Setenv("API_BASE_URL", "https://api.example.com/")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_LOCAL_DEV_ISSUER", "http://localhost:5556")
+	t.Setenv("OIDC_LOCAL_DEV_CLIENT_ID", "local-client")
+	t.Setenv("OIDC_LOCAL_DEV_SCOPES", "email")
+
+	configs, err := OIDCConfigsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 providers, got %d", len(configs))
+	}
+	if configs[0].RedirectURL != "https://api.example.com/api/auth/oidc/google/callback" {
+		t.Errorf("Unexpected redirect URL %s", configs[0].RedirectURL)
+	}
+	if configs[1].Name != "local-dev" || len(configs[1].Scopes) != 1 {
+		t.Errorf("Unexpected config %+v", configs[1])
+	}
+
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "")
+	if _, err := OIDCConfigsFromEnv(); err == nil {
+		t.Error("Expected error for a provider without a client ID")
+	}
+}
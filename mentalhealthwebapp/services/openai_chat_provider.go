@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrEmptyChatReply is returned when a model answers without any choices
+var ErrEmptyChatReply = errors.New("chat provider returned no reply")
+
+// OpenAIConfig configures an OpenAIChatProvider
+type OpenAIConfig struct {
+	APIKey  string
+	BaseURL string // empty for api.openai.com; set for OpenAI-compatible servers
+	Model   string // empty for gpt-3.5-turbo
+}
+
+// OpenAIChatProvider sends prompts to the OpenAI chat completions API, or
+// any server implementing it
+type OpenAIChatProvider struct {
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIChatProvider creates a provider. The client is shared by all
+// requests.
+func NewOpenAIChatProvider(cfg OpenAIConfig) *OpenAIChatProvider {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	}
+	model := cfg.Model
+	if model == "" {
+		model = openai.GPT3Dot5Turbo
+	}
+	return &OpenAIChatProvider{client: openai.NewClientWithConfig(clientConfig), model: model}
+}
+
+// Name implements ChatProvider
+func (p *OpenAIChatProvider) Name() string { return "openai" }
+
+// request converts a ChatRequest to the API's form
+func (p *OpenAIChatProvider) request(req ChatRequest) openai.ChatCompletionRequest {
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		message := openai.ChatCompletionMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		messages = append(messages, message)
+	}
+
+	var tools []openai.Tool
+	for _, tool := range req.Tools {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	return openai.ChatCompletionRequest{
+		Model:       p.model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Tools:       tools,
+	}
+}
+
+// chatToolCalls converts the tool calls of a reply
+func chatToolCalls(calls []openai.ToolCall) []ChatToolCall {
+	var toolCalls []ChatToolCall
+	for _, call := range calls {
+		toolCalls = append(toolCalls, ChatToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return toolCalls
+}
+
+// toolCallDeltas assembles tool calls streamed in pieces: the ID and name
+// arrive first, then the arguments a chunk at a time. Pieces are matched by
+// index, or by servers that send none, to the call last started.
+type toolCallDeltas []openai.ToolCall
+
+// add merges a chunk's tool call pieces
+func (d *toolCallDeltas) add(pieces []openai.ToolCall) {
+	for _, piece := range pieces {
+		i := len(*d) - 1
+		if piece.Index != nil {
+			i = *piece.Index
+		} else if piece.ID != "" || i < 0 {
+			i = len(*d)
+		}
+		if i < 0 {
+			continue
+		}
+		for i >= len(*d) {
+			*d = append(*d, openai.ToolCall{})
+		}
+		call := &(*d)[i]
+		if piece.ID != "" {
+			call.ID = piece.ID
+		}
+		if piece.Function.Name != "" {
+			call.Function.Name = piece.Function.Name
+		}
+		call.Function.Arguments += piece.Function.Arguments
+	}
+}
+
+// Complete implements ChatProvider
+func (p *OpenAIChatProvider) Complete(ctx context.Context, req ChatRequest) (*ChatReply, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, p.request(req))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, ErrEmptyChatReply
+	}
+	return &ChatReply{
+		Content:   resp.Choices[0].Message.Content,
+		ToolCalls: chatToolCalls(resp.Choices[0].Message.ToolCalls),
+		Usage:     chatUsage(&resp.Usage),
+		Provider:  p.Name(),
+	}, nil
+}
+
+// Stream implements ChatProvider
+func (p *OpenAIChatProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	request := p.request(req)
+	request.Stream = true
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	reply := ChatReply{Provider: p.Name()}
+	var content strings.Builder
+	var toolCalls toolCallDeltas
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Keep what arrived so the caller can still store it
+			reply.Content = content.String()
+			return &reply, err
+		}
+		if resp.Usage != nil {
+			reply.Usage = chatUsage(resp.Usage)
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		toolCalls.add(resp.Choices[0].Delta.ToolCalls)
+		if resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		delta := resp.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			reply.Content = content.String()
+			return &reply, err
+		}
+	}
+
+	reply.Content = content.String()
+	reply.ToolCalls = chatToolCalls(toolCalls)
+	return &reply, nil
+}
+
+// chatUsage converts the API's usage, which servers may leave out
+func chatUsage(usage *openai.Usage) *ChatUsage {
+	if usage == nil || usage.TotalTokens == 0 {
+		return nil
+	}
+	return &ChatUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+// OpenAIEmbedder creates embeddings with the OpenAI embeddings API, or any
+// server implementing it
+type OpenAIEmbedder struct {
+	client *openai.Client
+	model  openai.EmbeddingModel
+}
+
+// NewOpenAIEmbedder creates an embedder; cfg.Model defaults to
+// text-embedding-3-small
+func NewOpenAIEmbedder(cfg OpenAIConfig) *OpenAIEmbedder {
+	clientConfig := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	}
+	model := openai.EmbeddingModel(cfg.Model)
+	if model == "" {
+		model = openai.SmallEmbedding3
+	}
+	return &OpenAIEmbedder{client: openai.NewClientWithConfig(clientConfig), model: model}
+}
+
+// Embed implements Embedder
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Input: texts, Model: e.model})
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	return vectors, nil
+}
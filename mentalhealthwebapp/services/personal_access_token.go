@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+	"github.com/leketech/mental-health-app/utils"
+	"github.com/lib/pq"
+)
+
+// Scopes a personal access token can be granted
+const (
+	ScopeMoodsRead     = "moods:read"
+	ScopeMoodsWrite    = "moods:write"
+	ScopeJournalsRead  = "journals:read"
+	ScopeJournalsWrite = "journals:write"
+	ScopeProfileRead   = "profile:read"
+	ScopeAccountExport = "account:export"
+)
+
+// PersonalAccessTokenScopes lists every valid scope
+var PersonalAccessTokenScopes = []string{
+	ScopeMoodsRead, ScopeMoodsWrite, ScopeJournalsRead, ScopeJournalsWrite, ScopeProfileRead, ScopeAccountExport,
+}
+
+// PersonalAccessTokenPrefix starts every personal access token so it can be
+// told apart from a JWT (and found by secret scanners)
+const PersonalAccessTokenPrefix = "mhp_"
+
+// patRetention is how long revoked and expired tokens stay listed in the
+// database before cleanup removes them
+const patRetention = 30 * 24 * time.Hour
+
+// patLastUsedResolution limits how often last_used_at is written for a
+// token that is used in quick succession
+const patLastUsedResolution = time.Minute
+
+// ErrInvalidPersonalAccessToken is returned for unknown, revoked or expired tokens
+var ErrInvalidPersonalAccessToken = errors.New("invalid or expired personal access token")
+
+// AuthenticatedToken is a valid personal access token presented with a request
+type AuthenticatedToken struct {
+	ID     int
+	UserID int
+	Scopes []string
+}
+
+// HasScope reports whether the token was granted scope
+func (t *AuthenticatedToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PersonalAccessTokenService issues long-lived, scoped tokens for scripts
+// and integrations. Like refresh tokens, only their hash is stored.
+type PersonalAccessTokenService struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewPersonalAccessTokenService creates a new personal access token service
+func NewPersonalAccessTokenService(db *sql.DB) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{db: db, now: time.Now}
+}
+
+// IsPersonalAccessToken reports whether token has the personal access token format
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// ValidateScopes checks that scopes is non-empty and only has known scopes,
+// returning them de-duplicated
+func ValidateScopes(scopes []string) ([]string, error) {
+	valid := make(map[string]bool, len(PersonalAccessTokenScopes))
+	for _, scope := range PersonalAccessTokenScopes {
+		valid[scope] = true
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !valid[scope] {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return result, nil
+}
+
+// Create issues a token for userID. The plain token is only returned here.
+func (s *PersonalAccessTokenService) Create(userID int, name string, scopes []string, ttl time.Duration) (string, *models.PersonalAccessToken, error) {
+	secret, err := utils.GenerateSecureToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := PersonalAccessTokenPrefix + secret
+
+	pat := models.PersonalAccessToken{
+		Name:        name,
+		TokenPrefix: token[:len(PersonalAccessTokenPrefix)+6],
+		Scopes:      scopes,
+	}
+	expiresAt := s.now().Add(ttl)
+	pat.ExpiresAt = &expiresAt
+
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	err = s.db.QueryRow(query, userID, name, utils.HashToken(token), pat.TokenPrefix, pq.Array(scopes), expiresAt).Scan(&pat.ID, &pat.CreatedAt)
+	if err != nil {
+		log.Printf("Error storing personal access token: %v", err)
+		return "", nil, err
+	}
+
+	return token, &pat, nil
+}
+
+// Authenticate returns the token's owner and scopes and records its use
+func (s *PersonalAccessTokenService) Authenticate(token string) (*AuthenticatedToken, error) {
+	var t AuthenticatedToken
+	var lastUsedAt sql.NullTime
+
+	query := `
+		SELECT id, user_id, scopes, last_used_at FROM personal_access_tokens
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > $2
+	`
+	err := s.db.QueryRow(query, utils.HashToken(token), s.now()).Scan(&t.ID, &t.UserID, pq.Array(&t.Scopes), &lastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		log.Printf("Error authenticating personal access token: %v", err)
+		return nil, err
+	}
+
+	if !lastUsedAt.Valid || s.now().Sub(lastUsedAt.Time) >= patLastUsedResolution {
+		if _, err := s.db.Exec(`UPDATE personal_access_tokens SET last_used_at = $2 WHERE id = $1`, t.ID, s.now()); err != nil {
+			log.Printf("Error recording personal access token use: %v", err)
+		}
+	}
+
+	return &t, nil
+}
+
+// ListForUser returns the user's tokens that have not been revoked, newest first
+func (s *PersonalAccessTokenService) ListForUser(userID int) ([]models.PersonalAccessToken, error) {
+	query := `
+		SELECT id, name, token_prefix, scopes, created_at, last_used_at, expires_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		log.Printf("Error listing personal access tokens: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var pat models.PersonalAccessToken
+		if err := rows.Scan(&pat.ID, &pat.Name, &pat.TokenPrefix, pq.Array(&pat.Scopes), &pat.CreatedAt, &pat.LastUsedAt, &pat.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, pat)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Revoke disables one of the user's tokens, reporting whether it existed
+func (s *PersonalAccessTokenService) Revoke(userID, tokenID int) (bool, error) {
+	query := `UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := s.db.Exec(query, tokenID, userID)
+	if err != nil {
+		log.Printf("Error revoking personal access token: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteStale removes tokens revoked or expired more than patRetention ago
+func (s *PersonalAccessTokenService) DeleteStale(ctx context.Context) (int, error) {
+	before := s.now().Add(-patRetention)
+	result, err := s.db.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE revoked_at < $1 OR expires_at < $1`, before)
+	if err != nil {
+		log.Printf("Error deleting stale personal access tokens: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// RevokeAllForUser disables every token the user has
+func (s *PersonalAccessTokenService) RevokeAllForUser(userID int) error {
+	query := `UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := s.db.Exec(query, userID); err != nil {
+		log.Printf("Error revoking personal access tokens: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import "testing"
+
+// TestValidateScopes verifies unknown scopes are rejected and duplicates removed
+func TestValidateScopes(t *testing.T) {
+	scopes, err := ValidateScopes([]string{"moods:read", " journals:write", "moods:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes) != 2 || scopes[0] != ScopeMoodsRead || scopes[1] != ScopeJournalsWrite {
+		t.Errorf("Unexpected scopes %v", scopes)
+	}
+
+	if _, err := ValidateScopes([]string{"moods:read", "admin"}); err == nil {
+		t.Error("Expected error for an unknown scope")
+	}
+	if _, err := ValidateScopes(nil); err == nil {
+		t.Error("Expected error for an empty scope list")
+	}
+}
+
+// TestPersonalAccessTokenFormat verifies tokens are told apart from JWTs
+func TestPersonalAccessTokenFormat(t *testing.T) {
+	if !IsPersonalAccessToken("mhp_abc123") {
+		t.Error("Expected mhp_ token to be recognised")
+	}
+	if IsPersonalAccessToken("eyJhbGciOiJFZERTQSJ9.e30.sig") {
+		t.Error("Expected JWT not to be recognised as a personal access token")
+	}
+
+	token := &AuthenticatedToken{Scopes: []string{ScopeMoodsRead}}
+	if !token.HasScope(ScopeMoodsRead) || token.HasScope(ScopeMoodsWrite) {
+		t.Errorf("Unexpected HasScope result for %v", token.Scopes)
+	}
+}
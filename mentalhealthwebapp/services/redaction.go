@@ -0,0 +1,418 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of personal information the redactor detects
+const (
+	PIIEmail   = "EMAIL"
+	PIIPhone   = "PHONE"
+	PIIAddress = "ADDRESS"
+	PIIName    = "NAME"
+	PIICard    = "CARD"
+)
+
+// maxPlaceholderLength bounds placeholders such as "[ADDRESS_12]", so a
+// streamed "[" only has to be held back that long
+const maxPlaceholderLength = 24
+
+// PIIDetector finds one kind of personal information in text
+type PIIDetector interface {
+	// Kind names what is found; it is used in placeholders and counts
+	Kind() string
+	// Find returns the [start, end) byte offsets of each match
+	Find(text string) [][]int
+}
+
+// regexpDetector matches a pattern, redacting the first capture group when
+// the pattern has one so context words like "my name is" are kept
+type regexpDetector struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(match string) bool // optional extra check
+}
+
+// Kind implements PIIDetector
+func (d regexpDetector) Kind() string { return d.kind }
+
+// Find implements PIIDetector
+func (d regexpDetector) Find(text string) [][]int {
+	var spans [][]int
+	for _, m := range d.re.FindAllStringSubmatchIndex(text, -1) {
+		span := m[:2]
+		if len(m) >= 4 && m[2] >= 0 {
+			span = m[2:4]
+		}
+		if d.valid != nil && !d.valid(text[span[0]:span[1]]) {
+			continue
+		}
+		spans = append(spans, []int{span[0], span[1]})
+	}
+	return spans
+}
+
+// digitCount counts the digits in s
+func digitCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// datePattern matches dates, which the phone pattern would otherwise take
+var datePattern = regexp.MustCompile(`^\d{4}[-./]\d{1,2}[-./]\d{1,2}$|^\d{1,2}[-./]\d{1,2}[-./]\d{4}$`)
+
+// luhnValid reports whether the digits of s pass the Luhn checksum used by
+// payment cards
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// piiDetectors are the built-in detectors by lower-case kind
+var piiDetectors = map[string]PIIDetector{
+	"email": regexpDetector{
+		kind: PIIEmail,
+		re:   regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`),
+	},
+	"phone": regexpDetector{
+		kind: PIIPhone,
+		re:   regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{1,4}\)[\s.-]?)?\d{2,4}(?:[\s.-]?\d{2,4}){1,4}\b`),
+		valid: func(m string) bool {
+			n := digitCount(m)
+			return n >= 7 && n <= 15 && !datePattern.MatchString(m)
+		},
+	},
+	"address": regexpDetector{
+		kind: PIIAddress,
+		re: regexp.MustCompile(`\b\d{1,5}[A-Za-z]?\s+(?:[A-Z][\p{L}'-]*\s+){1,4}(?i:street|st|avenue|ave|road|rd|lane|ln|drive|dr|boulevard|blvd|court|ct|way|place|pl|close|crescent|terrace)\b\.?` +
+			`|\b[A-Z]{1,2}\d[A-Z\d]?\s?\d[A-Z]{2}\b`), // UK postcode
+	},
+	"name": regexpDetector{
+		kind: PIIName,
+		re: regexp.MustCompile(`(?i:\bmy name is|\bi'm called|\bi am called|\bcall me|\bnamed|\bmy (?:friend|best friend|boss|manager|partner|husband|wife|boyfriend|girlfriend|sister|brother|mom|mum|dad|mother|father|son|daughter|therapist|doctor|colleague|coworker|roommate|flatmate|ex|teacher|neighbou?r))` +
+			`,?\s+([A-Z][\p{L}'-]+(?:\s+[A-Z][\p{L}'-]+)?)`),
+	},
+	"card": regexpDetector{
+		kind:  PIICard,
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid: luhnValid,
+	},
+}
+
+// Redactor replaces personal information in chat content with placeholders
+// before it is sent to a chat provider
+type Redactor struct {
+	detectors []PIIDetector
+}
+
+// NewRedactor creates a redactor. With no detectors nothing is redacted.
+func NewRedactor(detectors ...PIIDetector) *Redactor {
+	return &Redactor{detectors: detectors}
+}
+
+// RedactorFromEnv builds a redactor from CHAT_REDACT, a comma-separated list
+// of detectors (email, phone, address, name, card). It defaults to all of
+// them; "none" turns redaction off.
+func RedactorFromEnv() (*Redactor, error) {
+	spec := strings.ToLower(strings.TrimSpace(os.Getenv("CHAT_REDACT")))
+	if spec == "none" {
+		return NewRedactor(), nil
+	}
+	if spec == "" {
+		spec = "card,email,phone,address,name"
+	}
+
+	var detectors []PIIDetector
+	for _, name := range strings.Split(spec, ",") {
+		detector, ok := piiDetectors[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown CHAT_REDACT detector %q", name)
+		}
+		detectors = append(detectors, detector)
+	}
+	return NewRedactor(detectors...), nil
+}
+
+// NewRedaction starts redacting one prompt. Placeholders are shared by all
+// messages redacted with it, so a value is replaced the same way wherever
+// it appears.
+func (r *Redactor) NewRedaction() *Redaction {
+	return &Redaction{
+		redactor:     r,
+		placeholders: make(map[string]string),
+		next:         make(map[string]int),
+		kinds:        make(map[string]string),
+	}
+}
+
+// RedactedValue is a personal detail redacted from a prompt
+type RedactedValue struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Redaction holds the placeholders of one prompt and the originals they
+// stand for
+type Redaction struct {
+	redactor     *Redactor
+	placeholders map[string]string // kind and value to placeholder
+	next         map[string]int    // last number used per kind
+	restorer     *strings.Replacer // built on first use
+	pairs        []string          // placeholder, original, ...
+	kinds        map[string]string // lower-case original to kind
+	known        *regexp.Regexp    // matches any original; built on first use
+}
+
+// Redact replaces personal information in text, returning the redacted
+// text and how many values of each kind were replaced. Values found earlier
+// are replaced wherever they appear, even without the context a detector
+// needs, such as a name on its own.
+func (s *Redaction) Redact(text string) (string, map[string]int) {
+	type match struct {
+		start, end int
+		kind       string
+	}
+
+	var matches []match
+	for _, d := range s.redactor.detectors {
+		for _, span := range d.Find(text) {
+			matches = append(matches, match{span[0], span[1], d.Kind()})
+		}
+	}
+	if known := s.knownPattern(); known != nil {
+		for _, span := range known.FindAllStringIndex(text, -1) {
+			matches = append(matches, match{span[0], span[1], s.kinds[strings.ToLower(text[span[0]:span[1]])]})
+		}
+	}
+	if len(matches) == 0 {
+		return text, nil
+	}
+
+	// Where detectors overlap, the earliest and then longest match wins
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+
+	counts := make(map[string]int)
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.start < last {
+			continue
+		}
+		b.WriteString(text[last:m.start])
+		b.WriteString(s.placeholder(m.kind, text[m.start:m.end]))
+		counts[m.kind]++
+		last = m.end
+	}
+	b.WriteString(text[last:])
+	return b.String(), counts
+}
+
+// Seed adds values redacted from earlier prompts, so they are replaced
+// wherever they appear even without the context a detector needs. It does
+// nothing when redaction is off.
+func (s *Redaction) Seed(values []RedactedValue) {
+	if len(s.redactor.detectors) == 0 {
+		return
+	}
+	for _, v := range values {
+		s.placeholder(v.Kind, v.Value)
+	}
+}
+
+// Values returns the values redacted so far, including seeded ones, in
+// the order they were found
+func (s *Redaction) Values() []RedactedValue {
+	values := make([]RedactedValue, 0, len(s.pairs)/2)
+	for i := 1; i < len(s.pairs); i += 2 {
+		values = append(values, RedactedValue{Kind: s.kinds[strings.ToLower(s.pairs[i])], Value: s.pairs[i]})
+	}
+	return values
+}
+
+// placeholder returns the placeholder for value, assigning the next one
+// for its kind if it is new
+func (s *Redaction) placeholder(kind, value string) string {
+	key := kind + "\x00" + strings.ToLower(value)
+	if p, ok := s.placeholders[key]; ok {
+		return p
+	}
+	s.next[kind]++
+	p := fmt.Sprintf("[%s_%d]", kind, s.next[kind])
+	s.placeholders[key] = p
+	s.pairs = append(s.pairs, p, value)
+	s.kinds[strings.ToLower(value)] = kind
+	s.restorer, s.known = nil, nil
+	return p
+}
+
+// knownPattern matches the values redacted so far as whole words, longest
+// first, or is nil if there are none
+func (s *Redaction) knownPattern() *regexp.Regexp {
+	if s.known != nil || len(s.kinds) == 0 {
+		return s.known
+	}
+	values := make([]string, 0, len(s.kinds))
+	for value := range s.kinds {
+		values = append(values, regexp.QuoteMeta(value))
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	s.known = regexp.MustCompile(`(?i)(?:^|\b)(?:` + strings.Join(values, "|") + `)(?:\b|$)`)
+	return s.known
+}
+
+// Restore puts the original values back in place of placeholders
+func (s *Redaction) Restore(text string) string {
+	if len(s.pairs) == 0 {
+		return text
+	}
+	if s.restorer == nil {
+		s.restorer = strings.NewReplacer(s.pairs...)
+	}
+	return s.restorer.Replace(text)
+}
+
+// RedactingChatProvider redacts prompts before they reach a provider and
+// restores the placeholders in its replies. It is made for one request.
+type RedactingChatProvider struct {
+	provider  ChatProvider
+	redaction *Redaction
+	counts    map[string]int
+}
+
+// NewRedactingChatProvider wraps provider for one request
+func (r *Redactor) NewRedactingChatProvider(provider ChatProvider) *RedactingChatProvider {
+	return &RedactingChatProvider{provider: provider, redaction: r.NewRedaction()}
+}
+
+// Name implements ChatProvider
+func (p *RedactingChatProvider) Name() string { return p.provider.Name() }
+
+// Counts returns how many values of each kind were redacted from the
+// newest user message, which earlier requests have not counted yet
+func (p *RedactingChatProvider) Counts() map[string]int {
+	return p.counts
+}
+
+// Seed adds values redacted from earlier requests; see Redaction.Seed
+func (p *RedactingChatProvider) Seed(values []RedactedValue) {
+	p.redaction.Seed(values)
+}
+
+// Values returns the values redacted for the request
+func (p *RedactingChatProvider) Values() []RedactedValue {
+	return p.redaction.Values()
+}
+
+// Redacted returns text as it would have been sent to the provider, with
+// the placeholders used for the request
+func (p *RedactingChatProvider) Redacted(text string) string {
+	redacted, _ := p.redaction.Redact(text)
+	return redacted
+}
+
+// redact redacts every message of req
+func (p *RedactingChatProvider) redact(req ChatRequest) ChatRequest {
+	// A first pass finds every value, so a name detected in a later message
+	// is also replaced in earlier ones
+	for _, m := range req.Messages {
+		p.redaction.Redact(m.Content)
+	}
+
+	newest := -1
+	for i, m := range req.Messages {
+		if m.Role == MessageRoleUser {
+			newest = i
+		}
+	}
+
+	messages := make([]ChatMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		var counts map[string]int
+		m.Content, counts = p.redaction.Redact(m.Content)
+		if i == newest {
+			p.counts = counts
+		}
+		if len(m.ToolCalls) > 0 {
+			calls := make([]ChatToolCall, len(m.ToolCalls))
+			for j, call := range m.ToolCalls {
+				call.Arguments, _ = p.redaction.Redact(call.Arguments)
+				calls[j] = call
+			}
+			m.ToolCalls = calls
+		}
+		messages[i] = m
+	}
+	req.Messages = messages
+	return req
+}
+
+// restore puts the original values back in a reply, including the
+// arguments of the tools it calls
+func (p *RedactingChatProvider) restore(reply *ChatReply) {
+	if reply == nil {
+		return
+	}
+	reply.Content = p.redaction.Restore(reply.Content)
+	for i := range reply.ToolCalls {
+		reply.ToolCalls[i].Arguments = p.redaction.Restore(reply.ToolCalls[i].Arguments)
+	}
+}
+
+// Complete implements ChatProvider
+func (p *RedactingChatProvider) Complete(ctx context.Context, req ChatRequest) (*ChatReply, error) {
+	reply, err := p.provider.Complete(ctx, p.redact(req))
+	p.restore(reply)
+	return reply, err
+}
+
+// Stream implements ChatProvider. Text that may be the start of a
+// placeholder is held back until the placeholder is complete.
+func (p *RedactingChatProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	var pending string
+	reply, err := p.provider.Stream(ctx, p.redact(req), func(delta string) error {
+		pending += delta
+		ready := pending
+		if i := strings.LastIndexByte(pending, '['); i >= 0 && !strings.Contains(pending[i:], "]") && len(pending)-i < maxPlaceholderLength {
+			ready, pending = pending[:i], pending[i:]
+		} else {
+			pending = ""
+		}
+		if ready == "" {
+			return nil
+		}
+		return onDelta(p.redaction.Restore(ready))
+	})
+	if err == nil && pending != "" {
+		err = onDelta(p.redaction.Restore(pending))
+	}
+	p.restore(reply)
+	return reply, err
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestRedactor returns a redactor with every built-in detector
+func newTestRedactor(t *testing.T) *Redactor {
+	t.Setenv("CHAT_REDACT", "")
+	r, err := RedactorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestRedact verifies each kind of personal detail is replaced
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Email me at Jane.Doe+chat@example.co.uk please", "Email me at [EMAIL_1] please"},
+		{"My number is +44 7700 900123.", "My number is [PHONE_1]."},
+		{"Call (555) 123-4567 tomorrow", "Call [PHONE_1] tomorrow"},
+		{"I live at 221B Baker Street, NW1 6XE", "I live at [ADDRESS_1], [ADDRESS_2]"},
+		{"My name is Sam and my boss Alex Morgan yells", "My name is [NAME_1] and my boss [NAME_2] yells"},
+		{"My card 4111 1111 1111 1111 was stolen", "My card [CARD_1] was stolen"},
+		{"On 2024-05-12 I slept 8 hours, and my sister was kind", "On 2024-05-12 I slept 8 hours, and my sister was kind"},
+		{"Order 1234 5678 9012 3456 arrived", "Order 1234 5678 9012 3456 arrived"}, // fails the card checksum, too long for a phone
+	}
+
+	r := newTestRedactor(t)
+	for _, tt := range tests {
+		got, _ := r.NewRedaction().Redact(tt.text)
+		if got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestRedactionStablePlaceholders verifies a value keeps its placeholder
+// across messages and is restored in replies
+func TestRedactionStablePlaceholders(t *testing.T) {
+	redaction := newTestRedactor(t).NewRedaction()
+
+	first, _ := redaction.Redact("My friend Priya emailed priya@example.com")
+	second, counts := redaction.Redact("I told my friend Priya, and Tom at TOM@example.com")
+	if first != "My friend [NAME_1] emailed [EMAIL_1]" {
+		t.Errorf("Unexpected first redaction %q", first)
+	}
+	if second != "I told my friend [NAME_1], and Tom at [EMAIL_2]" {
+		t.Errorf("Unexpected second redaction %q", second)
+	}
+	if !reflect.DeepEqual(counts, map[string]int{PIIName: 1, PIIEmail: 1}) {
+		t.Errorf("Unexpected counts %v", counts)
+	}
+
+	if got := redaction.Restore("Tell [NAME_1] to reply to [EMAIL_2]."); got != "Tell Priya to reply to TOM@example.com." {
+		t.Errorf("Unexpected restore %q", got)
+	}
+}
+
+// TestRedactionSeed verifies values redacted from earlier prompts are
+// replaced without the context that found them, and only when redaction is on
+func TestRedactionSeed(t *testing.T) {
+	earlier := newTestRedactor(t).NewRedaction()
+	earlier.Redact("My sister Anna called")
+	values := earlier.Values()
+	if !reflect.DeepEqual(values, []RedactedValue{{Kind: PIIName, Value: "Anna"}}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	redaction := newTestRedactor(t).NewRedaction()
+	redaction.Seed(values)
+	if got, _ := redaction.Redact("Summary: anna has been supportive"); got != "Summary: [NAME_1] has been supportive" {
+		t.Errorf("Unexpected seeded redaction %q", got)
+	}
+
+	off := NewRedactor().NewRedaction()
+	off.Seed(values)
+	if got, _ := off.Redact("Anna has been supportive"); got != "Anna has been supportive" {
+		t.Errorf("Expected nothing redacted with redaction off, got %q", got)
+	}
+}
+
+// TestRedactorFromEnv verifies detectors can be chosen or turned off
+func TestRedactorFromEnv(t *testing.T) {
+	t.Setenv("CHAT_REDACT", "email")
+	r, err := RedactorFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.NewRedaction().Redact("a@b.com or 555 123 4567"); got != "[EMAIL_1] or 555 123 4567" {
+		t.Errorf("Expected only emails redacted, got %q", got)
+	}
+
+	t.Setenv("CHAT_REDACT", "none")
+	if r, _ = RedactorFromEnv(); len(r.detectors) != 0 {
+		t.Error("Expected redaction to be off")
+	}
+
+	t.Setenv("CHAT_REDACT", "email,shoe-size")
+	if _, err := RedactorFromEnv(); err == nil {
+		t.Error("Expected an unknown detector to be rejected")
+	}
+}
+
+// chunkedProvider streams a fixed reply in the given pieces
+type chunkedProvider struct {
+	FakeChatProvider
+	chunks []string
+}
+
+func (p *chunkedProvider) Stream(ctx context.Context, req ChatRequest, onDelta func(string) error) (*ChatReply, error) {
+	p.Requests = append(p.Requests, req)
+	for _, chunk := range p.chunks {
+		if err := onDelta(chunk); err != nil {
+			return nil, err
+		}
+	}
+	return &ChatReply{Content: strings.Join(p.chunks, ""), Provider: "fake"}, nil
+}
+
+// TestRedactingChatProvider verifies prompts are redacted and streamed
+// replies restored, even when a placeholder is split across pieces
+func TestRedactingChatProvider(t *testing.T) {
+	inner := &chunkedProvider{chunks: []string{"Hi [NA", "ME_1], say hi to [", "NAME_2] [sic"}}
+	p := newTestRedactor(t).NewRedactingChatProvider(inner)
+
+	req := ChatRequest{Messages: []ChatMessage{
+		{Role: "system", Content: "Be kind"},
+		{Role: MessageRoleUser, Content: "My name is Ana"},
+		{Role: MessageRoleAssistant, Content: "Hello Ana"},
+		{Role: MessageRoleUser, Content: "My name is Ana and my mum Rosa is visiting"},
+	}}
+
+	var deltas []string
+	reply, err := p.Stream(context.Background(), req, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := inner.Requests[0].Messages
+	if sent[1].Content != "My name is [NAME_1]" || sent[2].Content != "Hello [NAME_1]" || sent[3].Content != "My name is [NAME_1] and my mum [NAME_2] is visiting" {
+		t.Errorf("Unexpected redacted prompt %+v", sent)
+	}
+	if got := strings.Join(deltas, ""); got != "Hi Ana, say hi to Rosa [sic" || got != reply.Content {
+		t.Errorf("Unexpected restored reply %q (deltas %q, reply %q)", got, deltas, reply.Content)
+	}
+	if deltas[0] != "Hi " {
+		t.Errorf("Expected the partial placeholder to be held back, got %q", deltas[0])
+	}
+	if !reflect.DeepEqual(p.Counts(), map[string]int{PIIName: 2}) {
+		t.Errorf("Expected only the newest message counted, got %v", p.Counts())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 // RefreshTokenService handles refresh token operations
@@ -19,30 +20,84 @@ func NewRefreshTokenService(db *sql.DB) *RefreshTokenService {
 	return &RefreshTokenService{db: db}
 }
 
-// StoreRefreshToken stores a refresh token in the database
-func (s *RefreshTokenService) StoreRefreshToken(userID int, refreshToken string, expiresAt time.Time) error {
-	tokenHash := utils.HashToken(refreshToken)
+// refreshTokenTTL is how long a refresh token stays valid
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// TokenReuseError is returned when an already-rotated refresh token is
+// presented again. The whole token family has been revoked by the time it
+// is returned.
+type TokenReuseError struct {
+	UserID   int
+	FamilyID string
+}
+
+func (e *TokenReuseError) Error() string {
+	return "refresh token reuse detected"
+}
+
+// StartSession issues a token pair for a new login. The refresh token is
+// the first member of a new token family, which doubles as the session
+// shown in the user's device list; every rotation adds to the family, so
+// reuse of any old member can revoke the whole chain. Returns the pair and
+// the session (family) ID.
+func (s *RefreshTokenService) StartSession(userID int, meta SessionMeta) (*utils.TokenPair, string, error) {
+	sessionID := uuid.NewString()
+	
+	pair, err := utils.GenerateTokenPair(userID, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+	
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	
+	query := `INSERT INTO sessions (id, user_id, user_agent, ip_address, expires_at) VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)`
+	if _, err := tx.Exec(query, sessionID, userID, truncate(meta.UserAgent, 512), meta.IPAddress, expiresAt); err != nil {
+		log.Printf("Error creating session: %v", err)
+		return nil, "", err
+	}
 	
-	query := `INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
-	_, err := s.db.Exec(query, userID, tokenHash, expiresAt)
+	query = `
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, access_token_id, access_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = tx.Exec(query, userID, utils.HashToken(pair.RefreshToken), expiresAt,
+		sessionID, pair.AccessTokenID, accessTokenExpiry(pair))
 	if err != nil {
 		log.Printf("Error storing refresh token: %v", err)
-		return err
+		return nil, "", err
 	}
 	
-	return nil
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	
+	return pair, sessionID, nil
+}
+
+// accessTokenExpiry returns when the access token of pair expires
+func accessTokenExpiry(pair *utils.TokenPair) time.Time {
+	return time.Now().Add(time.Duration(pair.ExpiresIn) * time.Second)
 }
 
-// ValidateRefreshToken checks if a refresh token is valid and not revoked
+// ValidateRefreshToken checks if a refresh token is valid and not revoked.
+// Presenting a token that was already rotated revokes its whole family.
 func (s *RefreshTokenService) ValidateRefreshToken(refreshToken string) (int, error) {
 	tokenHash := utils.HashToken(refreshToken)
 	
 	var userID int
+	var familyID string
 	var expiresAt time.Time
 	var revoked bool
+	var replacedBy sql.NullInt64
 	
-	query := `SELECT user_id, expires_at, revoked FROM refresh_tokens WHERE token_hash = $1`
-	err := s.db.QueryRow(query, tokenHash).Scan(&userID, &expiresAt, &revoked)
+	query := `SELECT user_id, family_id, expires_at, revoked, replaced_by FROM refresh_tokens WHERE token_hash = $1`
+	err := s.db.QueryRow(query, tokenHash).Scan(&userID, &familyID, &expiresAt, &revoked, &replacedBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, jwt.NewValidationError("invalid refresh token", jwt.ValidationErrorMalformed)
@@ -51,9 +106,8 @@ func (s *RefreshTokenService) ValidateRefreshToken(refreshToken string) (int, er
 		return 0, err
 	}
 	
-	// Check if token is revoked
 	if revoked {
-		return 0, jwt.NewValidationError("refresh token revoked", jwt.ValidationErrorMalformed)
+		return 0, s.revokedTokenError(userID, familyID, replacedBy)
 	}
 	
 	// Check if token is expired
@@ -64,6 +118,108 @@ func (s *RefreshTokenService) ValidateRefreshToken(refreshToken string) (int, er
 	return userID, nil
 }
 
+// revokedTokenError returns the error for presenting a revoked token. A
+// token that was rotated being presented again means it was stolen or
+// replayed, so its family is revoked and a *TokenReuseError returned;
+// tokens revoked by logout or a password change are simply invalid.
+func (s *RefreshTokenService) revokedTokenError(userID int, familyID string, replacedBy sql.NullInt64) error {
+	if !replacedBy.Valid {
+		return jwt.NewValidationError("refresh token revoked", jwt.ValidationErrorClaimsInvalid)
+	}
+	if err := s.RevokeTokenFamily(familyID); err != nil {
+		return err
+	}
+	return &TokenReuseError{UserID: userID, FamilyID: familyID}
+}
+
+// RevokeTokenFamily revokes every refresh token in a family and blacklists
+// the family's access tokens that have not expired yet
+func (s *RefreshTokenService) RevokeTokenFamily(familyID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	query := `UPDATE sessions SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1`
+	if _, err := tx.Exec(query, familyID); err != nil {
+		log.Printf("Error revoking session: %v", err)
+		return err
+	}
+	
+	blacklisted, err := revokeFamilyTokens(tx, familyID)
+	if err != nil {
+		return err
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	cacheBlacklistedTokens(blacklisted)
+	return nil
+}
+
+// revokeFamilyTokens revokes a family's refresh tokens and blacklists its
+// unexpired access tokens inside tx. The caller must already have marked
+// the session revoked in tx: that locks the session row, which rotation
+// locks first too, so a rotation racing the revocation either finishes
+// before it (and its new token is revoked here) or sees the session revoked.
+func revokeFamilyTokens(tx *sql.Tx, familyID string) (map[string]time.Time, error) {
+	query := `UPDATE refresh_tokens SET revoked = TRUE, revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked = FALSE`
+	if _, err := tx.Exec(query, familyID); err != nil {
+		log.Printf("Error revoking token family: %v", err)
+		return nil, err
+	}
+	
+	blacklisted, err := blacklistIssuedAccessTokens(tx, "family_id", familyID)
+	if err != nil {
+		log.Printf("Error blacklisting token family: %v", err)
+		return nil, err
+	}
+	return blacklisted, nil
+}
+
+// blacklistIssuedAccessTokens blacklists the unexpired access tokens issued
+// with the refresh tokens whose column (family_id or user_id) is value, and
+// returns them for cacheBlacklistedTokens once tx commits
+func blacklistIssuedAccessTokens(tx *sql.Tx, column string, value interface{}) (map[string]time.Time, error) {
+	query := `
+		INSERT INTO blacklisted_tokens (token_id, expires_at)
+		SELECT access_token_id, access_expires_at FROM refresh_tokens
+		WHERE ` + column + ` = $1 AND access_token_id IS NOT NULL AND access_expires_at > CURRENT_TIMESTAMP
+		ON CONFLICT (token_id) DO NOTHING
+		RETURNING token_id, expires_at
+	`
+	rows, err := tx.Query(query, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	blacklisted := make(map[string]time.Time)
+	for rows.Next() {
+		var tokenID string
+		var expiresAt time.Time
+		if err := rows.Scan(&tokenID, &expiresAt); err != nil {
+			return nil, err
+		}
+		blacklisted[tokenID] = expiresAt
+	}
+	return blacklisted, rows.Err()
+}
+
+// cacheBlacklistedTokens adds tokens to the in-memory blacklist, if one is
+// installed
+func cacheBlacklistedTokens(blacklisted map[string]time.Time) {
+	if tokenBlacklist == nil {
+		return
+	}
+	for tokenID, expiresAt := range blacklisted {
+		tokenBlacklist.Add(tokenID, expiresAt)
+	}
+}
+
 // RevokeRefreshToken marks a refresh token as revoked
 func (s *RefreshTokenService) RevokeRefreshToken(refreshToken string) error {
 	tokenHash := utils.HashToken(refreshToken)
@@ -87,39 +243,70 @@ func (s *RefreshTokenService) RevokeRefreshToken(refreshToken string) error {
 	return nil
 }
 
-// RevokeAllUserTokens revokes all refresh tokens for a specific user
+// RevokeAllUserTokens revokes all refresh tokens and sessions for a specific
+// user and blacklists the access tokens of every session that have not
+// expired yet
 func (s *RefreshTokenService) RevokeAllUserTokens(userID int) error {
-	query := `UPDATE refresh_tokens SET revoked = TRUE, revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked = FALSE`
-	_, err := s.db.Exec(query, userID)
+	tx, err := s.db.Begin()
 	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	// Sessions first, in the same lock order as rotation
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.Exec(query, userID); err != nil {
+		log.Printf("Error revoking all user sessions: %v", err)
+		return err
+	}
+	
+	query = `UPDATE refresh_tokens SET revoked = TRUE, revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked = FALSE`
+	if _, err := tx.Exec(query, userID); err != nil {
 		log.Printf("Error revoking all user tokens: %v", err)
 		return err
 	}
 	
+	blacklisted, err := blacklistIssuedAccessTokens(tx, "user_id", userID)
+	if err != nil {
+		log.Printf("Error blacklisting user access tokens: %v", err)
+		return err
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	cacheBlacklistedTokens(blacklisted)
 	return nil
 }
 
-// BlacklistAccessToken adds an access token to the blacklist
-func (s *RefreshTokenService) BlacklistAccessToken(accessToken string, expiresAt time.Time) error {
-	tokenHash := utils.HashToken(accessToken)
-	
-	query := `INSERT INTO blacklisted_tokens (token_hash, expires_at) VALUES ($1, $2) ON CONFLICT (token_hash) DO NOTHING`
-	_, err := s.db.Exec(query, tokenHash, expiresAt)
+// BlacklistAccessToken adds an access token, identified by utils.TokenID,
+// to the blacklist
+func (s *RefreshTokenService) BlacklistAccessToken(tokenID string, expiresAt time.Time) error {
+	query := `INSERT INTO blacklisted_tokens (token_id, expires_at) VALUES ($1, $2) ON CONFLICT (token_id) DO NOTHING`
+	_, err := s.db.Exec(query, tokenID, expiresAt)
 	if err != nil {
 		log.Printf("Error blacklisting access token: %v", err)
 		return err
 	}
 	
+	if tokenBlacklist != nil {
+		tokenBlacklist.Add(tokenID, expiresAt)
+	}
+	
 	return nil
 }
 
-// IsTokenBlacklisted checks if an access token is blacklisted
-func (s *RefreshTokenService) IsTokenBlacklisted(accessToken string) bool {
-	tokenHash := utils.HashToken(accessToken)
+// IsTokenBlacklisted checks if an access token, identified by utils.TokenID,
+// is blacklisted. The in-memory cache is used when one is installed.
+func (s *RefreshTokenService) IsTokenBlacklisted(tokenID string) bool {
+	if tokenBlacklist != nil {
+		return tokenBlacklist.Contains(tokenID)
+	}
 	
 	var count int
-	query := `SELECT COUNT(*) FROM blacklisted_tokens WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP`
-	err := s.db.QueryRow(query, tokenHash).Scan(&count)
+	query := `SELECT COUNT(*) FROM blacklisted_tokens WHERE token_id = $1 AND expires_at > CURRENT_TIMESTAMP`
+	err := s.db.QueryRow(query, tokenID).Scan(&count)
 	if err != nil {
 		log.Printf("Error checking blacklisted token: %v", err)
 		return false
@@ -128,10 +315,17 @@ func (s *RefreshTokenService) IsTokenBlacklisted(accessToken string) bool {
 	return count > 0
 }
 
-// CleanupExpiredTokens removes expired tokens from the database
+// CleanupExpiredTokens removes expired sessions and tokens from the database
 func (s *RefreshTokenService) CleanupExpiredTokens() error {
+	// Expired sessions take their refresh tokens with them
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Printf("Error cleaning up expired sessions: %v", err)
+		return err
+	}
+	
 	// Clean up expired refresh tokens
-	_, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	_, err = s.db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
 	if err != nil {
 		log.Printf("Error cleaning up expired refresh tokens: %v", err)
 		return err
@@ -147,24 +341,95 @@ func (s *RefreshTokenService) CleanupExpiredTokens() error {
 	return nil
 }
 
-// RotateRefreshToken revokes the old token and creates a new one
-func (s *RefreshTokenService) RotateRefreshToken(oldRefreshToken string, userID int) (string, error) {
-	// Revoke the old token
-	if err := s.RevokeRefreshToken(oldRefreshToken); err != nil {
-		return "", err
+// RotateRefreshToken exchanges a valid refresh token for a new token pair
+// in the same family (session), recording meta as the session's latest
+// use. The old token is revoked and the new one stored in a single
+// transaction, so a crash can never leave both usable. Presenting a token
+// that was already rotated returns a *TokenReuseError after revoking the
+// whole family, and tokens of a revoked session are rejected.
+func (s *RefreshTokenService) RotateRefreshToken(oldRefreshToken string, meta SessionMeta) (*utils.TokenPair, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 	
-	// Generate new refresh token
-	newRefreshToken, err := utils.GenerateRefreshToken(userID)
+	var tokenID, userID int
+	var familyID string
+	var expiresAt time.Time
+	var revoked bool
+	var replacedBy sql.NullInt64
+	var sessionRevoked bool
+	
+	// Lock the session, then the token, in the same order as revocation.
+	// The token lock serializes concurrent rotations of the same token.
+	tokenHash := utils.HashToken(oldRefreshToken)
+	query := `
+		SELECT s.revoked_at IS NOT NULL FROM sessions s
+		JOIN refresh_tokens rt ON rt.family_id = s.id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF s
+	`
+	err = tx.QueryRow(query, tokenHash).Scan(&sessionRevoked)
+	if err == nil {
+		query = `SELECT id, user_id, family_id, expires_at, revoked, replaced_by FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+		err = tx.QueryRow(query, tokenHash).Scan(&tokenID, &userID, &familyID, &expiresAt, &revoked, &replacedBy)
+	}
 	if err != nil {
-		return "", err
+		if err == sql.ErrNoRows {
+			return nil, jwt.NewValidationError("invalid refresh token", jwt.ValidationErrorMalformed)
+		}
+		log.Printf("Error validating refresh token: %v", err)
+		return nil, err
+	}
+	
+	if revoked {
+		tx.Rollback()
+		return nil, s.revokedTokenError(userID, familyID, replacedBy)
+	}
+	
+	if sessionRevoked {
+		return nil, jwt.NewValidationError("session revoked", jwt.ValidationErrorClaimsInvalid)
+	}
+	
+	if time.Now().After(expiresAt) {
+		return nil, jwt.NewValidationError("refresh token expired", jwt.ValidationErrorExpired)
+	}
+	
+	pair, err := utils.GenerateTokenPair(userID, familyID)
+	if err != nil {
+		return nil, err
+	}
+	
+	newExpiresAt := time.Now().Add(refreshTokenTTL)
+	
+	var newTokenID int
+	query = `
+		INSERT INTO refresh_tokens (user_id, token_hash, expires_at, family_id, access_token_id, access_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err = tx.QueryRow(query, userID, utils.HashToken(pair.RefreshToken), newExpiresAt,
+		familyID, pair.AccessTokenID, accessTokenExpiry(pair)).Scan(&newTokenID)
+	if err != nil {
+		log.Printf("Error storing refresh token: %v", err)
+		return nil, err
+	}
+	
+	query = `UPDATE refresh_tokens SET revoked = TRUE, revoked_at = CURRENT_TIMESTAMP, replaced_by = $2 WHERE id = $1`
+	if _, err := tx.Exec(query, tokenID, newTokenID); err != nil {
+		log.Printf("Error revoking rotated refresh token: %v", err)
+		return nil, err
+	}
+	
+	if err := touchSession(tx, familyID, meta, newExpiresAt); err != nil {
+		log.Printf("Error updating session: %v", err)
+		return nil, err
 	}
 	
-	// Store the new token
-	expiresAt := time.Now().Add(7 * 24 * time.Hour) // 7 days
-	if err := s.StoreRefreshToken(userID, newRefreshToken, expiresAt); err != nil {
-		return "", err
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 	
-	return newRefreshToken, nil
+	return pair, nil
 }
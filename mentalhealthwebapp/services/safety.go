@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Safety categories
+const (
+	SafetySelfHarm         = "self_harm"
+	SafetyAbuse            = "abuse"
+	SafetyMedicalEmergency = "medical_emergency"
+	SafetyMedicalAdvice    = "medical_advice"
+)
+
+// SafetyVerdict is what a classifier found in a piece of text
+type SafetyVerdict struct {
+	Categories []string
+}
+
+// Flagged reports whether any category was found
+func (v SafetyVerdict) Flagged() bool {
+	return len(v.Categories) > 0
+}
+
+// Has reports whether category was found
+func (v SafetyVerdict) Has(category string) bool {
+	for _, c := range v.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// SafetyClassifier flags unsafe content in chat messages. role is the
+// author of text: MessageRoleUser for incoming messages, MessageRoleAssistant
+// for model replies.
+type SafetyClassifier interface {
+	Classify(ctx context.Context, role, text string) (SafetyVerdict, error)
+}
+
+// lexiconRule flags a category when any of its patterns matches
+type lexiconRule struct {
+	category string
+	role     string
+	patterns []*regexp.Regexp
+}
+
+// compilePatterns compiles word-bounded, case-insensitive patterns
+func compilePatterns(patterns ...string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		compiled[i] = regexp.MustCompile(`(?i)\b(?:` + p + `)\b`)
+	}
+	return compiled
+}
+
+// defaultLexicon errs towards flagging: showing crisis resources to someone
+// who did not need them costs far less than missing someone who did
+var defaultLexicon = []lexiconRule{
+	{
+		category: SafetySelfHarm,
+		role:     MessageRoleUser,
+		patterns: compilePatterns(
+			`suicid(?:e|al)`,
+			`kill(?:ing)? myself`,
+			`end(?:ing)? (?:my|it) (?:life|all)`,
+			`take my (?:own )?life`,
+			`(?:want|wanna|going) to die`,
+			`better off dead`,
+			`no reason to (?:live|go on)`,
+			`(?:don'?t|do not) want to (?:live|be alive|wake up)`,
+			`(?:hurt|hurting|harm|harming|cut|cutting|burn|burning) myself`,
+			`self[- ]?harm(?:ing)?`,
+			`overdos(?:e|ing)`,
+		),
+	},
+	{
+		category: SafetyAbuse,
+		role:     MessageRoleUser,
+		patterns: compilePatterns(
+			`(?:he|she|they|partner|husband|wife|boyfriend|girlfriend|dad|father|mom|mum|mother|parents?|step\w+) (?:\w+ )?(?:hits|hit|beats|beat|chokes|choked|kicks|kicked|punches|punched|slaps|slapped|strangles|strangled) me`,
+			`threaten(?:s|ed|ing)? to (?:kill|hurt) me`,
+			`abus(?:es|ed|ing) me`,
+			`being abused`,
+			`abusive (?:partner|relationship|husband|wife|boyfriend|girlfriend|parent|father|mother|home)`,
+			`domestic (?:violence|abuse)`,
+			`sexual(?:ly)? (?:assault(?:ed)?|abused?)`,
+			`rap(?:e|ed)`,
+			`afraid to go home`,
+		),
+	},
+	{
+		category: SafetyMedicalEmergency,
+		role:     MessageRoleUser,
+		patterns: compilePatterns(
+			`overdosed`,
+			`took (?:too many|a lot of|all (?:my|the)) (?:pills|tablets|meds)`,
+			`(?:swallowed|drank) (?:bleach|poison|pills)`,
+			`(?:can'?t|cannot) breathe`,
+			`chest pains?`,
+			`heart attack`,
+			`having a (?:stroke|seizure)`,
+			`(?:is|was|went) unconscious`,
+			`bleeding (?:heavily|badly|a lot|won'?t stop)`,
+		),
+	},
+	{
+		category: SafetyMedicalAdvice,
+		role:     MessageRoleAssistant,
+		patterns: compilePatterns(
+			`\d+(?:\.\d+)? ?(?:mg|milligrams?|mcg|ml)`,
+			`(?:take|taking|increase|decrease|double|reduce|stop taking|start taking|switch to) (?:\w+ ){0,4}(?:medications?|meds|pills|tablets|doses?|dosage|antidepressants?|ssris?|benzodiazepines?|sleeping pills)`,
+			`(?:sertraline|fluoxetine|escitalopram|citalopram|paroxetine|venlafaxine|bupropion|mirtazapine|lithium|quetiapine|diazepam|lorazepam|alprazolam|zoloft|prozac|lexapro|xanax|valium|wellbutrin)`,
+			`you (?:have|probably have|likely have|may have|might have|are suffering from|suffer from) (?:clinical |severe |mild )?(?:depression|bipolar|ptsd|adhd|ocd|schizophrenia|an? (?:\w+ )?(?:disorder|illness))`,
+			`(?:i|i'd|i would) diagnose`,
+			`my diagnosis`,
+		),
+	},
+}
+
+// LexiconClassifier flags text by matching a fixed list of phrases. It runs
+// locally with no network, so every message can be checked cheaply.
+type LexiconClassifier struct {
+	rules []lexiconRule
+}
+
+// NewLexiconClassifier creates a classifier with the built-in lexicon
+func NewLexiconClassifier() *LexiconClassifier {
+	return &LexiconClassifier{rules: defaultLexicon}
+}
+
+// Classify implements SafetyClassifier
+func (l *LexiconClassifier) Classify(ctx context.Context, role, text string) (SafetyVerdict, error) {
+	// Curly apostrophes are common from phone keyboards
+	text = strings.NewReplacer("’", "'", "‘", "'").Replace(text)
+
+	var verdict SafetyVerdict
+	for _, rule := range l.rules {
+		if rule.role != role || verdict.Has(rule.category) {
+			continue
+		}
+		for _, p := range rule.patterns {
+			if p.MatchString(text) {
+				verdict.Categories = append(verdict.Categories, rule.category)
+				break
+			}
+		}
+	}
+	return verdict, nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Safety actions taken on a flagged chat message
+const (
+	// SafetyActionCrisisResponse answers with crisis resources instead of
+	// asking the model
+	SafetyActionCrisisResponse = "crisis_response"
+	// SafetyActionReplyReplaced withholds a model reply
+	SafetyActionReplyReplaced = "reply_replaced"
+)
+
+// medicalAdviceReply replaces model replies that diagnose or advise on medication
+const medicalAdviceReply = "I'm not able to give medical advice, such as a diagnosis or guidance on medication. " +
+	"A doctor, pharmacist or other qualified professional is the right person to ask about that. " +
+	"I'm happy to keep talking about how you're feeling."
+
+// SafetyIntervention is the reply sent in place of the model's
+type SafetyIntervention struct {
+	Categories []string         `json:"categories"`
+	Action     string           `json:"action"`
+	Content    string           `json:"content"`
+	Resources  []CrisisResource `json:"resources,omitempty"`
+}
+
+// CheckChatMessage classifies a user's message. If the user may be in
+// danger it returns a crisis response to send instead of a model reply;
+// otherwise nil.
+func CheckChatMessage(ctx context.Context, classifier SafetyClassifier, message, locale string) (*SafetyIntervention, error) {
+	verdict, err := classifier.Classify(ctx, MessageRoleUser, message)
+	if err != nil || !verdict.Flagged() {
+		return nil, err
+	}
+
+	resources := CrisisResources(locale, verdict.Categories)
+	return &SafetyIntervention{
+		Categories: verdict.Categories,
+		Action:     SafetyActionCrisisResponse,
+		Content:    crisisReply(verdict.Categories, resources),
+		Resources:  resources,
+	}, nil
+}
+
+// CheckChatReply classifies a model reply. If it must not be shown it
+// returns the replacement; otherwise nil.
+func CheckChatReply(ctx context.Context, classifier SafetyClassifier, reply string) (*SafetyIntervention, error) {
+	verdict, err := classifier.Classify(ctx, MessageRoleAssistant, reply)
+	if err != nil || !verdict.Flagged() {
+		return nil, err
+	}
+
+	return &SafetyIntervention{
+		Categories: verdict.Categories,
+		Action:     SafetyActionReplyReplaced,
+		Content:    medicalAdviceReply,
+	}, nil
+}
+
+// SafetyEvent is a flagged chat message and what was done about it
+type SafetyEvent struct {
+	ID             int       `json:"id"`
+	ConversationID *int      `json:"conversation_id"`
+	MessageID      *int      `json:"message_id"`
+	Source         string    `json:"source"` // who wrote the flagged text: "user" or "assistant"
+	Categories     []string  `json:"categories"`
+	Action         string    `json:"action"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SafetyEventService records flagged chat messages that users can review
+type SafetyEventService struct {
+	db *sql.DB
+}
+
+// NewSafetyEventService creates a new safety event service
+func NewSafetyEventService(db *sql.DB) *SafetyEventService {
+	return &SafetyEventService{db: db}
+}
+
+// Record stores an intervention on a message of userID. messageID is the
+// stored message that was flagged, or the reply that replaced it.
+func (s *SafetyEventService) Record(userID, conversationID, messageID int, source string, intervention *SafetyIntervention) error {
+	query := `
+		INSERT INTO safety_events (user_id, conversation_id, message_id, source, categories, action)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6)
+	`
+	_, err := s.db.Exec(query, userID, conversationID, messageID, source, pq.Array(intervention.Categories), intervention.Action)
+	if err != nil {
+		log.Printf("Error recording safety event: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListForUser returns the most recent events for userID
+func (s *SafetyEventService) ListForUser(userID, limit int) ([]SafetyEvent, error) {
+	query := `
+		SELECT id, conversation_id, message_id, source, categories, action, created_at
+		FROM safety_events WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2
+	`
+	rows, err := s.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SafetyEvent{}
+	for rows.Next() {
+		var e SafetyEvent
+		if err := rows.Scan(&e.ID, &e.ConversationID, &e.MessageID, &e.Source, pq.Array(&e.Categories), &e.Action, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
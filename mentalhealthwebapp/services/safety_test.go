@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLexiconClassifier verifies messages and replies are flagged by category
+func TestLexiconClassifier(t *testing.T) {
+	tests := []struct {
+		role string
+		text string
+		want []string
+	}{
+		{MessageRoleUser, "I don’t want to be alive anymore", []string{SafetySelfHarm}},
+		{MessageRoleUser, "I've been thinking about suicide", []string{SafetySelfHarm}},
+		{MessageRoleUser, "My boyfriend hit me again last night", []string{SafetyAbuse}},
+		{MessageRoleUser, "I took too many pills and I can't breathe", []string{SafetyMedicalEmergency}},
+		{MessageRoleUser, "I want to overdose", []string{SafetySelfHarm}},
+		{MessageRoleUser, "Work was stressful and I feel low", nil},
+		{MessageRoleUser, "It beats me why I'm so tired", nil},
+		{MessageRoleUser, "I ate some grapes", nil},
+		{MessageRoleAssistant, "You could try taking 50mg of sertraline.", []string{SafetyMedicalAdvice}},
+		{MessageRoleAssistant, "It sounds like you have clinical depression.", []string{SafetyMedicalAdvice}},
+		{MessageRoleAssistant, "Maybe increase your dose a little.", []string{SafetyMedicalAdvice}},
+		{MessageRoleAssistant, "If you're thinking about suicide, please call a crisis line.", nil},
+		{MessageRoleAssistant, "A short walk might help you feel better.", nil},
+	}
+
+	classifier := NewLexiconClassifier()
+	for _, tt := range tests {
+		verdict, err := classifier.Classify(context.Background(), tt.role, tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(verdict.Categories, tt.want) {
+			t.Errorf("%s %q: expected %v, got %v", tt.role, tt.text, tt.want, verdict.Categories)
+		}
+	}
+}
+
+// TestCrisisResources verifies resources follow the user's country
+func TestCrisisResources(t *testing.T) {
+	hasContact := func(resources []CrisisResource, contact string) bool {
+		for _, r := range resources {
+			if strings.Contains(r.Contact, contact) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if r := CrisisResources("en-US", []string{SafetySelfHarm}); !hasContact(r, "911") || !hasContact(r, "988") {
+		t.Errorf("Expected US emergency and crisis lines, got %+v", r)
+	}
+	if r := CrisisResources("en_GB", []string{SafetyAbuse}); !hasContact(r, "999") || !hasContact(r, "0808 2000 247") || hasContact(r, "116 123") {
+		t.Errorf("Expected UK emergency and abuse lines only, got %+v", r)
+	}
+	if r := CrisisResources("en", []string{SafetySelfHarm}); !hasContact(r, "local emergency number") || r[len(r)-1].URL != "https://findahelpline.com" {
+		t.Errorf("Expected international resources without a country, got %+v", r)
+	}
+	if r := CrisisResources("fr-CA", []string{SafetyAbuse}); !hasContact(r, "911") || r[len(r)-1].URL != "https://findahelpline.com" {
+		t.Errorf("Expected a helpline directory where no abuse line is listed, got %+v", r)
+	}
+}
+
+// TestCheckChatMessage verifies crisis responses carry the resources
+func TestCheckChatMessage(t *testing.T) {
+	classifier := NewLexiconClassifier()
+
+	intervention, err := CheckChatMessage(context.Background(), classifier, "I want to kill myself", "en-AU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intervention == nil || intervention.Action != SafetyActionCrisisResponse {
+		t.Fatalf("Expected a crisis response, got %+v", intervention)
+	}
+	if !strings.Contains(intervention.Content, "13 11 14") || len(intervention.Resources) == 0 {
+		t.Errorf("Expected the response to list Australian resources, got %q", intervention.Content)
+	}
+
+	if intervention, _ := CheckChatMessage(context.Background(), classifier, "I had a good day", "en-AU"); intervention != nil {
+		t.Errorf("Expected no intervention, got %+v", intervention)
+	}
+}
+
+// TestCheckChatReply verifies medical advice is replaced
+func TestCheckChatReply(t *testing.T) {
+	classifier := NewLexiconClassifier()
+
+	intervention, err := CheckChatReply(context.Background(), classifier, "You should stop taking your antidepressants.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intervention == nil || intervention.Action != SafetyActionReplyReplaced || intervention.Content != medicalAdviceReply {
+		t.Errorf("Expected the reply to be replaced, got %+v", intervention)
+	}
+}
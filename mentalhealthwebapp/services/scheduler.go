@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// schedulerLockKey is the pg_advisory_lock key held by the one replica that
+// runs scheduled jobs
+const schedulerLockKey int64 = 7203114581
+
+// schedulerTick is how often the scheduler looks for due jobs and, when
+// another replica is the leader, tries to take over
+const schedulerTick = 15 * time.Second
+
+// Job run statuses
+const (
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobFunc is the work a scheduled job does
+type JobFunc func(ctx context.Context) error
+
+// scheduledJob is a registered job and its place in the schedule
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      JobFunc
+
+	next    time.Time
+	running bool
+}
+
+// Scheduler runs registered jobs on cron-like schedules. Every replica runs
+// a scheduler, but only the one holding a Postgres advisory lock (the
+// leader) runs jobs; if it goes away its lock is released and another
+// replica takes over. Each run is recorded in job_runs.
+type Scheduler struct {
+	db  *sql.DB
+	now func() time.Time
+
+	mu   sync.Mutex
+	jobs []*scheduledJob
+
+	// Set while this replica is the leader
+	conn         *sql.Conn
+	leaderCtx    context.Context
+	leaderCancel context.CancelFunc
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	wg     sync.WaitGroup // running jobs
+}
+
+// NewScheduler creates a scheduler with no jobs
+func NewScheduler(db *sql.DB) *Scheduler {
+	return &Scheduler{db: db, now: time.Now}
+}
+
+// Register adds a job. spec is parsed with ParseSchedule. Jobs must be
+// registered before Start.
+func (s *Scheduler) Register(name, spec string, run JobFunc) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.name == name {
+			return fmt.Errorf("job %s is already registered", name)
+		}
+	}
+	s.jobs = append(s.jobs, &scheduledJob{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// Start runs the scheduler in the background until ctx is done or Stop is called
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.loop(ctx)
+}
+
+// Stop stops scheduling, waits for running jobs and gives up leadership
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// IsLeader reports whether this replica is running jobs
+func (s *Scheduler) IsLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// Jobs returns the registered jobs. Next run times are only known on the leader.
+func (s *Scheduler) Jobs() []models.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]models.Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		j := models.Job{Name: job.name, Schedule: job.spec, Running: job.running}
+		if s.conn != nil && !job.next.IsZero() {
+			next := job.next
+			j.NextRunAt = &next
+		}
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Name < jobs[k].Name })
+	return jobs
+}
+
+// RecentRuns returns the latest job runs across all replicas, newest first
+func (s *Scheduler) RecentRuns(limit int) ([]models.JobRun, error) {
+	query := `
+		SELECT id, job_name, status, started_at, finished_at, error
+		FROM job_runs
+		ORDER BY started_at DESC, id DESC
+		LIMIT $1
+	`
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		log.Printf("Error listing job runs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.JobRun{}
+	for rows.Next() {
+		var run models.JobRun
+		if err := rows.Scan(&run.ID, &run.JobName, &run.Status, &run.StartedAt, &run.FinishedAt, &run.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// PruneRuns deletes job history older than before
+func (s *Scheduler) PruneRuns(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM job_runs WHERE started_at < $1 AND status <> $2`, before, JobRunRunning)
+	if err != nil {
+		log.Printf("Error pruning job runs: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// loop ticks until ctx is done, then waits for running jobs
+func (s *Scheduler) loop(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			s.releaseLeadership()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick makes sure this replica still is (or tries to become) the leader
+// and, if it is, starts the jobs that are due
+func (s *Scheduler) tick(ctx context.Context) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		if !s.acquireLeadership(ctx) {
+			return
+		}
+	} else if err := conn.PingContext(ctx); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Scheduler lost its database connection, giving up leadership: %v", err)
+			s.releaseLeadership()
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+
+	now := s.now()
+	for _, job := range s.jobs {
+		if job.running || job.next.IsZero() || now.Before(job.next) {
+			continue
+		}
+		job.running = true
+		job.next = job.schedule.Next(now)
+		s.wg.Add(1)
+		go s.runJob(job)
+	}
+}
+
+// acquireLeadership takes the scheduler lock if no other replica holds it
+func (s *Scheduler) acquireLeadership(ctx context.Context) bool {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, schedulerLockKey).Scan(&acquired); err != nil || !acquired {
+		conn.Close()
+		return false
+	}
+
+	// Runs still marked running were interrupted when the previous leader stopped
+	query := `UPDATE job_runs SET status = $1, finished_at = NOW(), error = 'interrupted' WHERE status = $2`
+	if _, err := conn.ExecContext(ctx, query, JobRunFailed, JobRunRunning); err != nil {
+		log.Printf("Error closing interrupted job runs: %v", err)
+	}
+
+	// Resume each schedule from the job's last run so a handover neither
+	// skips a run nor repeats one
+	lastRuns := make(map[string]time.Time)
+	rows, err := conn.QueryContext(ctx, `SELECT job_name, MAX(started_at) FROM job_runs GROUP BY job_name`)
+	if err == nil {
+		for rows.Next() {
+			var name string
+			var startedAt time.Time
+			if rows.Scan(&name, &startedAt) == nil {
+				lastRuns[name] = startedAt
+			}
+		}
+		rows.Close()
+	} else {
+		log.Printf("Error loading last job runs: %v", err)
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+
+	s.mu.Lock()
+	s.conn = conn
+	s.leaderCtx = leaderCtx
+	s.leaderCancel = cancel
+	now := s.now()
+	for _, job := range s.jobs {
+		if last, ok := lastRuns[job.name]; ok {
+			job.next = job.schedule.Next(last.In(now.Location()))
+		} else {
+			job.next = job.schedule.Next(now)
+		}
+	}
+	s.mu.Unlock()
+
+	log.Printf("🗓️ This instance is now running scheduled jobs")
+	return true
+}
+
+// releaseLeadership cancels running jobs and gives up the scheduler lock
+func (s *Scheduler) releaseLeadership() {
+	s.mu.Lock()
+	conn, cancel := s.conn, s.leaderCancel
+	s.conn, s.leaderCtx, s.leaderCancel = nil, nil, nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	cancel()
+
+	// The lock belongs to the session, so a connection that cannot unlock
+	// must not go back to the pool
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, schedulerLockKey); err != nil {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// runJob runs one job and records the run
+func (s *Scheduler) runJob(job *scheduledJob) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		job.running = false
+		s.mu.Unlock()
+	}()
+
+	s.mu.Lock()
+	ctx := s.leaderCtx
+	s.mu.Unlock()
+	if ctx == nil {
+		return
+	}
+
+	var runID int64
+	query := `INSERT INTO job_runs (job_name, status) VALUES ($1, $2) RETURNING id`
+	if err := s.db.QueryRowContext(ctx, query, job.name, JobRunRunning).Scan(&runID); err != nil {
+		log.Printf("Error recording run of job %s, skipping it: %v", job.name, err)
+		return
+	}
+
+	err := runRecovered(ctx, job.run)
+
+	status, errorText := JobRunSucceeded, ""
+	if err != nil {
+		status, errorText = JobRunFailed, err.Error()
+		log.Printf("Job %s failed: %v", job.name, err)
+	}
+
+	query = `UPDATE job_runs SET status = $2, finished_at = NOW(), error = NULLIF($3, '') WHERE id = $1`
+	if _, err := s.db.Exec(query, runID, status, errorText); err != nil {
+		log.Printf("Error recording result of job %s: %v", job.name, err)
+	}
+}
+
+// runRecovered runs fn, turning a panic into an error
+func runRecovered(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Security event types
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventTwoFactorEnabled  = "two_factor_enabled"
+	SecurityEventTwoFactorDisabled = "two_factor_disabled"
+	SecurityEventRecoveryCodeUsed  = "recovery_code_used"
+	SecurityEventIdentityLinked    = "identity_linked"
+	SecurityEventIdentityUnlinked  = "identity_unlinked"
+	SecurityEventTokenCreated      = "personal_access_token_created"
+	SecurityEventTokenRevoked      = "personal_access_token_revoked"
+)
+
+// SecurityEvent is a security-relevant occurrence on a user's account
+type SecurityEvent struct {
+	ID        int             `json:"id"`
+	EventType string          `json:"event_type"`
+	Details   json.RawMessage `json:"details"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SecurityEventService records security events that users can review
+type SecurityEventService struct {
+	db *sql.DB
+}
+
+// NewSecurityEventService creates a new security event service
+func NewSecurityEventService(db *sql.DB) *SecurityEventService {
+	return &SecurityEventService{db: db}
+}
+
+// Record stores an event for userID
+func (s *SecurityEventService) Record(userID int, eventType string, details map[string]interface{}, ip, userAgent string) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO security_events (user_id, event_type, details, ip_address, user_agent) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := s.db.Exec(query, userID, eventType, detailsJSON, ip, userAgent); err != nil {
+		log.Printf("Error recording security event: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListForUser returns the most recent events for userID
+func (s *SecurityEventService) ListForUser(userID, limit int) ([]SecurityEvent, error) {
+	query := `
+		SELECT id, event_type, details, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at
+		FROM security_events WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2
+	`
+	rows, err := s.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.EventType, &e.Details, &e.IPAddress, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/leketech/mental-health-app/models"
+)
+
+// SessionMeta describes the client a session was started or used from
+type SessionMeta struct {
+	UserAgent string
+	IPAddress string
+}
+
+// SessionService lists and manages a user's signed-in devices. A session
+// is a refresh token family: it starts at login and survives rotations.
+type SessionService struct {
+	db *sql.DB
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(db *sql.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+// ListActive returns the user's sessions that are neither revoked nor
+// expired, most recently used first. currentSessionID is marked as current.
+func (s *SessionService) ListActive(userID int, currentSessionID string) ([]models.Session, error) {
+	query := `
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), label, created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress, &session.Label,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke signs a session out: its refresh tokens are revoked and its
+// outstanding access tokens blacklisted. Reports whether the session
+// existed, belonged to userID and was still active.
+func (s *SessionService) Revoke(userID int, sessionID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	blacklisted, err := revokeFamilyTokens(tx, sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	cacheBlacklistedTokens(blacklisted)
+	return true, nil
+}
+
+// UpdateLabel sets the user-editable name of a session. An empty label
+// clears it.
+func (s *SessionService) UpdateLabel(userID int, sessionID, label string) (bool, error) {
+	query := `UPDATE sessions SET label = NULLIF($3, '') WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := s.db.Exec(query, sessionID, userID, label)
+	if err != nil {
+		log.Printf("Error updating session label: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// touchSession records a refresh on the session inside tx
+func touchSession(tx *sql.Tx, sessionID string, meta SessionMeta, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions SET
+			last_used_at = CURRENT_TIMESTAMP,
+			expires_at = $2,
+			ip_address = NULLIF($3, ''),
+			user_agent = COALESCE(NULLIF($4, ''), user_agent)
+		WHERE id = $1
+	`
+	_, err := tx.Exec(query, sessionID, expiresAt, meta.IPAddress, truncate(meta.UserAgent, 512))
+	return err
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// tokenBlacklistChannel is the Postgres channel new blacklist entries are
+// announced on (see the blacklisted_tokens_notify trigger)
+const tokenBlacklistChannel = "token_blacklist"
+
+// tokenBlacklistResync is how often the cache is reloaded in full, as a
+// backstop for notifications missed while the listener reconnects
+const tokenBlacklistResync = 5 * time.Minute
+
+// tokenBlacklist is the cache installed with SetTokenBlacklist. Refresh
+// token operations write through to it so revocations apply immediately on
+// the replica that made them.
+var tokenBlacklist *TokenBlacklist
+
+// SetTokenBlacklist installs the cache used by RefreshTokenService
+func SetTokenBlacklist(b *TokenBlacklist) {
+	tokenBlacklist = b
+}
+
+// TokenBlacklist keeps the IDs of revoked, unexpired access tokens in
+// memory so authenticating a request needs no database query. Replicas
+// learn about each other's revocations through Postgres LISTEN/NOTIFY.
+type TokenBlacklist struct {
+	db  *sql.DB
+	now func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]time.Time // token ID to expiry
+}
+
+// NewTokenBlacklist creates an empty blacklist cache; call Load to fill it
+func NewTokenBlacklist(db *sql.DB) *TokenBlacklist {
+	return &TokenBlacklist{
+		db:      db,
+		now:     time.Now,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Contains reports whether the token with this ID has been revoked
+func (b *TokenBlacklist) Contains(tokenID string) bool {
+	b.mu.RLock()
+	expiresAt, ok := b.entries[tokenID]
+	b.mu.RUnlock()
+	return ok && b.now().Before(expiresAt)
+}
+
+// Add records a revoked token. Entries are dropped once the token would
+// have expired anyway.
+func (b *TokenBlacklist) Add(tokenID string, expiresAt time.Time) {
+	if !b.now().Before(expiresAt) {
+		return
+	}
+	b.mu.Lock()
+	b.entries[tokenID] = expiresAt
+	b.mu.Unlock()
+}
+
+// Load replaces the cache with the unexpired entries in the database
+func (b *TokenBlacklist) Load() error {
+	rows, err := b.db.Query(`SELECT token_id, expires_at FROM blacklisted_tokens WHERE expires_at > CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Printf("Error loading token blacklist: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	entries := make(map[string]time.Time)
+	for rows.Next() {
+		var tokenID string
+		var expiresAt time.Time
+		if err := rows.Scan(&tokenID, &expiresAt); err != nil {
+			return err
+		}
+		entries[tokenID] = expiresAt
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.entries = entries
+	b.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of cached entries
+func (b *TokenBlacklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.entries)
+}
+
+// prune drops entries for tokens that have expired
+func (b *TokenBlacklist) prune() {
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for tokenID, expiresAt := range b.entries {
+		if !now.Before(expiresAt) {
+			delete(b.entries, tokenID)
+		}
+	}
+}
+
+// handleNotification adds the entry in a "<token ID> <unix expiry>" payload
+func (b *TokenBlacklist) handleNotification(payload string) {
+	i := strings.LastIndexByte(payload, ' ')
+	if i <= 0 {
+		log.Printf("Ignoring malformed token blacklist notification %q", payload)
+		return
+	}
+	expiresAt, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil {
+		log.Printf("Ignoring malformed token blacklist notification %q", payload)
+		return
+	}
+	b.Add(payload[:i], time.Unix(expiresAt, 0))
+}
+
+// Listen keeps the cache in sync with other replicas until ctx is done. It
+// applies notifications as they arrive and reloads in full after the
+// listener reconnects and every tokenBlacklistResync.
+func (b *TokenBlacklist) Listen(ctx context.Context, connStr string) {
+	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Token blacklist listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(tokenBlacklistChannel); err != nil {
+		log.Printf("Error listening for token blacklist updates: %v", err)
+	}
+
+	// Catch up on anything blacklisted before the listener was ready
+	b.Load()
+
+	ticker := time.NewTicker(tokenBlacklistResync)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established
+			// and notifications may have been missed
+			if n == nil {
+				b.Load()
+				continue
+			}
+			b.handleNotification(n.Extra)
+		case <-ticker.C:
+			// Reloading drops expired entries too; prune keeps the cache
+			// from growing while the database is unreachable
+			if err := b.Load(); err != nil {
+				b.prune()
+			}
+		}
+	}
+}
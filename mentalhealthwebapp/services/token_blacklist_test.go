@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/leketech/mental-health-app/utils"
+)
+
+// newTestBlacklist returns a cache with a clock the test controls
+func newTestBlacklist() (*TokenBlacklist, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	blacklist := NewTokenBlacklist(nil)
+	blacklist.now = func() time.Time { return now }
+	return blacklist, &now
+}
+
+// TestTokenBlacklistExpiry verifies entries stop matching once the token has expired
+func TestTokenBlacklistExpiry(t *testing.T) {
+	blacklist, now := newTestBlacklist()
+
+	blacklist.Add("token-1", now.Add(time.Minute))
+	blacklist.Add("already-expired", now.Add(-time.Minute))
+
+	if !blacklist.Contains("token-1") {
+		t.Error("Expected token-1 to be blacklisted")
+	}
+	if blacklist.Contains("already-expired") || blacklist.Contains("token-2") {
+		t.Error("Expected only token-1 to be blacklisted")
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if blacklist.Contains("token-1") {
+		t.Error("Expected token-1 to expire from the blacklist")
+	}
+
+	blacklist.prune()
+	if blacklist.Len() != 0 {
+		t.Errorf("Expected pruned cache to be empty, got %d entries", blacklist.Len())
+	}
+}
+
+// TestTokenBlacklistNotification verifies NOTIFY payloads from other replicas are applied
+func TestTokenBlacklistNotification(t *testing.T) {
+	blacklist, now := newTestBlacklist()
+
+	blacklist.handleNotification(fmt.Sprintf("token-1 %d", now.Add(time.Minute).Unix()))
+	blacklist.handleNotification("malformed")
+	blacklist.handleNotification("token-2 soon")
+
+	if !blacklist.Contains("token-1") {
+		t.Error("Expected token-1 from the notification to be blacklisted")
+	}
+	if blacklist.Len() != 1 {
+		t.Errorf("Expected malformed notifications to be ignored, got %d entries", blacklist.Len())
+	}
+}
+
+// TestTokenID verifies tokens are identified by jti, falling back to the token hash
+func TestTokenID(t *testing.T) {
+	if id := utils.TokenID("a.b.c", jwt.MapClaims{"jti": "abc"}); id != "abc" {
+		t.Errorf("Expected jti to be used, got %s", id)
+	}
+	if id := utils.TokenID("a.b.c", jwt.MapClaims{}); id != utils.HashToken("a.b.c") {
+		t.Errorf("Expected token hash for a token without jti, got %s", id)
+	}
+}
+
+// benchmarkAccessToken is about the size of a real access token
+var benchmarkAccessToken = "eyJhbGciOiJFZERTQSIsImtpZCI6IjEyMyIsInR5cCI6IkpXVCJ9." + strings.Repeat("x", 300) + "." + strings.Repeat("y", 86)
+
+// BenchmarkTokenIDFromHash measures deriving the blacklist key by hashing
+// the whole token, as every request did before jti
+func BenchmarkTokenIDFromHash(b *testing.B) {
+	claims := jwt.MapClaims{}
+	for i := 0; i < b.N; i++ {
+		utils.TokenID(benchmarkAccessToken, claims)
+	}
+}
+
+// BenchmarkTokenIDFromJTI measures reading the blacklist key from the jti claim
+func BenchmarkTokenIDFromJTI(b *testing.B) {
+	claims := jwt.MapClaims{"jti": uuid.NewString()}
+	for i := 0; i < b.N; i++ {
+		utils.TokenID(benchmarkAccessToken, claims)
+	}
+}
+
+// BenchmarkTokenBlacklistContains measures the per-request check against a
+// cache of 10,000 revoked tokens, which replaces a database query
+func BenchmarkTokenBlacklistContains(b *testing.B) {
+	blacklist := NewTokenBlacklist(nil)
+	expiresAt := time.Now().Add(time.Hour)
+	for i := 0; i < 10000; i++ {
+		blacklist.Add(uuid.NewString(), expiresAt)
+	}
+	tokenID := uuid.NewString()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			blacklist.Contains(tokenID)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestMatchTOTPRFCVectors verifies codes against the RFC 6238 test vectors
+// (truncated to 6 digits)
+func TestMatchTOTPRFCVectors(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, v := range vectors {
+		at := time.Unix(v.unix, 0)
+		counter, ok := MatchTOTP(rfcSecret, v.code, at)
+		if !ok {
+			t.Errorf("Expected %s to match at %d", v.code, v.unix)
+			continue
+		}
+		if counter != totpCounter(at) {
+			t.Errorf("Expected counter %d at %d, got %d", totpCounter(at), v.unix, counter)
+		}
+	}
+}
+
+// TestMatchTOTPSkew verifies one period of drift is tolerated but not more
+func TestMatchTOTPSkew(t *testing.T) {
+	at := time.Unix(59, 0) // code 287082 belongs to counter 1
+
+	if _, ok := MatchTOTP(rfcSecret, "287082", at.Add(totpPeriod)); !ok {
+		t.Error("Expected code from the previous period to match")
+	}
+	if _, ok := MatchTOTP(rfcSecret, "287082", at.Add(-totpPeriod)); !ok {
+		t.Error("Expected code from the next period to match")
+	}
+	if _, ok := MatchTOTP(rfcSecret, "287082", at.Add(2*totpPeriod)); ok {
+		t.Error("Expected code two periods old to be rejected")
+	}
+	if _, ok := MatchTOTP(rfcSecret, "287083", at); ok {
+		t.Error("Expected wrong code to be rejected")
+	}
+	if _, ok := MatchTOTP("not base32!", "287082", at); ok {
+		t.Error("Expected invalid secret to be rejected")
+	}
+}
+
+// TestGenerateTOTPSecret verifies secrets are 160 bits and unique
+func TestGenerateTOTPSecret(t *testing.T) {
+	a, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateTOTPSecret()
+
+	key, err := decodeTOTPSecret(a)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("Expected a 20-byte key, got %d bytes (%v)", len(key), err)
+	}
+	if a == b {
+		t.Error("Expected different secrets")
+	}
+}
+
+// TestTOTPProvisioningURI verifies the otpauth URI carries the secret and issuer
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Mental Health App", "jane@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+	if u.Path != "/Mental Health App:jane@example.com" {
+		t.Errorf("Unexpected label %q", u.Path)
+	}
+	if u.Query().Get("secret") != rfcSecret || u.Query().Get("issuer") != "Mental Health App" {
+		t.Errorf("Unexpected parameters %s", u.RawQuery)
+	}
+}
+
+// TestRecoveryCodes verifies recovery code format and normalization
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected recovery code format %q", code)
+		}
+		if isTOTPCode(code) {
+			t.Errorf("Recovery code %q looks like a TOTP code", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != recoveryCodeCount {
+		t.Errorf("Expected %d unique codes, got %d", recoveryCodeCount, len(seen))
+	}
+
+	if normalizeRecoveryCode(" ABCDE-fghjk") != "abcdefghjk" {
+		t.Error("Expected case, spaces and dashes to be ignored")
+	}
+	if !isTOTPCode("123 456") || isTOTPCode("12345a") {
+		t.Error("Unexpected TOTP code detection")
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/utils"
+)
+
+// recoveryCodeCount is how many recovery codes are issued on enrollment
+const recoveryCodeCount = 10
+
+// recoveryCodeAlphabet avoids characters that are easily confused (0/o, 1/l)
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// Two-factor authentication errors
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorSetupRequired  = errors.New("two-factor setup has not been started")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+)
+
+// TwoFactorService manages TOTP enrollment and one-time recovery codes.
+// Recovery codes are stored hashed and can each be used once.
+type TwoFactorService struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewTwoFactorService creates a new two-factor service
+func NewTwoFactorService(db *sql.DB) *TwoFactorService {
+	return &TwoFactorService{db: db, now: time.Now}
+}
+
+// Enabled reports whether the user has completed TOTP enrollment
+func (s *TwoFactorService) Enabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1`, userID).Scan(&enabled)
+	if err != nil {
+		log.Printf("Error checking two-factor status: %v", err)
+		return false, err
+	}
+	return enabled, nil
+}
+
+// BeginSetup generates a new secret for the user. It only takes effect once
+// confirmed with a code from the authenticator app (see Enable).
+func (s *TwoFactorService) BeginSetup(userID int) (string, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	query := `UPDATE users SET totp_pending_secret = $2 WHERE id = $1 AND totp_enabled_at IS NULL`
+	result, err := s.db.Exec(query, userID, secret)
+	if err != nil {
+		log.Printf("Error starting two-factor setup: %v", err)
+		return "", err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rowsAffected == 0 {
+		return "", ErrTwoFactorAlreadyEnabled
+	}
+
+	return secret, nil
+}
+
+// Enable confirms setup with a code generated from the pending secret and
+// returns a fresh set of recovery codes. The codes are only available in
+// plain text from this call.
+func (s *TwoFactorService) Enable(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var pendingSecret sql.NullString
+	var enabled bool
+	query := `SELECT totp_pending_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(query, userID).Scan(&pendingSecret, &enabled); err != nil {
+		log.Printf("Error loading two-factor setup: %v", err)
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if !pendingSecret.Valid {
+		return nil, ErrTwoFactorSetupRequired
+	}
+
+	counter, ok := MatchTOTP(pendingSecret.String, code, s.now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	query = `
+		UPDATE users
+		SET totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+		    totp_enabled_at = CURRENT_TIMESTAMP, totp_last_counter = $2
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, userID, counter); err != nil {
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable removes the user's TOTP secret and recovery codes
+func (s *TwoFactorService) Disable(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_counter = NULL
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, userID); err != nil {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Verify checks a second factor for userID: either a current TOTP code,
+// which cannot be replayed, or an unused recovery code, which is consumed.
+// It reports whether a recovery code was used.
+func (s *TwoFactorService) Verify(userID int, code string) (bool, error) {
+	var secret sql.NullString
+	err := s.db.QueryRow(`SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL`, userID).Scan(&secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrTwoFactorNotEnabled
+		}
+		log.Printf("Error loading two-factor secret: %v", err)
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		counter, ok := MatchTOTP(secret.String, code, s.now())
+		if !ok {
+			return false, ErrInvalidTwoFactorCode
+		}
+		return false, s.useTOTPCounter(userID, counter)
+	}
+
+	query := `UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := s.db.Exec(query, userID, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		log.Printf("Error consuming recovery code: %v", err)
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, ErrInvalidTwoFactorCode
+	}
+
+	return true, nil
+}
+
+// useTOTPCounter records the time step of an accepted code, failing if it
+// or a later one was already used
+func (s *TwoFactorService) useTOTPCounter(userID int, counter int64) error {
+	query := `
+		UPDATE users SET totp_last_counter = $2
+		WHERE id = $1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)
+	`
+	result, err := s.db.Exec(query, userID, counter)
+	if err != nil {
+		log.Printf("Error recording TOTP use: %v", err)
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has
+func (s *TwoFactorService) RemainingRecoveryCodes(userID int) (int, error) {
+	var remaining int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&remaining)
+	if err != nil {
+		log.Printf("Error counting recovery codes: %v", err)
+		return 0, err
+	}
+	return remaining, nil
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and stores a new set
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		log.Printf("Error deleting recovery codes: %v", err)
+		return nil, err
+	}
+
+	for _, code := range codes {
+		query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.Exec(query, userID, utils.HashToken(normalizeRecoveryCode(code))); err != nil {
+			log.Printf("Error storing recovery code: %v", err)
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCodes returns n random codes formatted as "xxxxx-xxxxx"
+func generateRecoveryCodes(n int) ([]string, error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	codes := make([]string, n)
+	for i := range codes {
+		var b strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			index, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryCodeAlphabet[index.Int64()])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes codes match regardless of case, spaces and dashes
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// isTOTPCode reports whether code has the shape of a TOTP code rather than
+// a recovery code
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
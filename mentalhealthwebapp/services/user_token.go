@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/leketech/mental-health-app/utils"
+)
+
+// Purposes of single-use user tokens
+const (
+	TokenPurposeEmailChange   = "email_change"
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeOIDCLogin     = "oidc_login"
+	TokenPurposeReauth        = "reauth"
+)
+
+// ErrInvalidUserToken is returned for unknown, used or expired tokens
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// ErrEmailTaken is returned when confirming a change to an address another
+// account has taken since
+var ErrEmailTaken = errors.New("email already exists")
+
+// UserToken is a consumed single-use token
+type UserToken struct {
+	UserID   int
+	Purpose  string
+	NewEmail string
+}
+
+// UserTokenService issues single-use, expiring tokens that are sent to the
+// user out of band (e.g. by email). Only the token hash is stored.
+type UserTokenService struct {
+	db *sql.DB
+}
+
+// NewUserTokenService creates a new user token service
+func NewUserTokenService(db *sql.DB) *UserTokenService {
+	return &UserTokenService{db: db}
+}
+
+// CreateToken issues a token for purpose, invalidating any earlier unused
+// tokens the user has for the same purpose. newEmail binds the token to an
+// address: the new address for email changes, the address being verified
+// for verification tokens.
+func (s *UserTokenService) CreateToken(userID int, purpose, newEmail string, ttl time.Duration) (string, error) {
+	token, err := utils.GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.Exec(query, userID, purpose); err != nil {
+		log.Printf("Error invalidating user tokens: %v", err)
+		return "", err
+	}
+
+	query = `INSERT INTO user_tokens (user_id, purpose, token_hash, new_email, expires_at) VALUES ($1, $2, $3, NULLIF($4, ''), $5)`
+	if _, err := tx.Exec(query, userID, purpose, utils.HashToken(token), newEmail, time.Now().Add(ttl)); err != nil {
+		log.Printf("Error storing user token: %v", err)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeToken marks a token as used and returns what it was issued for.
+// A token can only be consumed once.
+func (s *UserTokenService) ConsumeToken(purpose, token string) (*UserToken, error) {
+	return consumeToken(s.db, purpose, token)
+}
+
+// consumeToken is ConsumeToken on q, the database or a transaction
+func consumeToken(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, purpose, token string) (*UserToken, error) {
+	var t UserToken
+	var newEmail sql.NullString
+
+	query := `
+		UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, purpose, new_email
+	`
+	err := q.QueryRow(query, utils.HashToken(token), purpose).Scan(&t.UserID, &t.Purpose, &newEmail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidUserToken
+		}
+		log.Printf("Error consuming user token: %v", err)
+		return nil, err
+	}
+
+	t.NewEmail = newEmail.String
+	return &t, nil
+}
+
+// ConfirmEmailChange consumes an email change token and moves its user to
+// the new address in one transaction. Confirming the link proves ownership
+// of the address, so it is marked verified. If another account has taken
+// the address since, ErrEmailTaken is returned and the token is not used up.
+func (s *UserTokenService) ConfirmEmailChange(token string) (*UserToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := consumeToken(tx, TokenPurposeEmailChange, token)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE users SET email = $1, email_verified_at = CURRENT_TIMESTAMP WHERE id = $2`
+	if _, err := tx.Exec(query, t.NewEmail, t.UserID); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			return nil, ErrEmailTaken
+		}
+		log.Printf("Error updating email: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DeleteExpired removes tokens that have been used or have expired
+func (s *UserTokenService) DeleteExpired(ctx context.Context) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM user_tokens WHERE used_at IS NOT NULL OR expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Printf("Error deleting expired user tokens: %v", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is one key of a KeySet. Keys that are being retired may be
+// public only: they still verify tokens but no longer sign new ones.
+type SigningKey struct {
+	ID        string // "kid", the RFC 7638 thumbprint of the public key
+	Method    jwt.SigningMethod
+	private   crypto.Signer
+	PublicKey crypto.PublicKey
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// still accepted from. Rotation works by putting a new key first and
+// keeping the previous one after it until the tokens it signed expire.
+type KeySet struct {
+	signing *SigningKey
+	keys    []*SigningKey
+	byID    map[string]*SigningKey
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseKeySet reads one or more PEM blocks. The first private key signs new
+// tokens; every key (private, or public for retired keys) verifies them.
+// Ed25519 keys sign with EdDSA and RSA keys (2048 bits or more) with RS256.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	ks := &KeySet{byID: make(map[string]*SigningKey)}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := parseKeyBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := ks.byID[key.ID]; exists {
+			continue
+		}
+
+		ks.keys = append(ks.keys, key)
+		ks.byID[key.ID] = key
+		if ks.signing == nil && key.private != nil {
+			ks.signing = key
+		}
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("no PEM keys found")
+	}
+	if ks.signing == nil {
+		return nil, errors.New("key set has no private key to sign with")
+	}
+
+	return ks, nil
+}
+
+// parseKeyBlock decodes a PKCS#8 private key or PKIX public key
+func parseKeyBlock(block *pem.Block) (*SigningKey, error) {
+	var public crypto.PublicKey
+	var private crypto.Signer
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", parsed)
+		}
+		private, public = signer, signer.Public()
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+		private, public = parsed, parsed.Public()
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		public = parsed
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	return newSigningKey(private, public)
+}
+
+// newSigningKey picks the algorithm for a key and computes its kid
+func newSigningKey(private crypto.Signer, public crypto.PublicKey) (*SigningKey, error) {
+	key := &SigningKey{private: private, PublicKey: public}
+
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits, got %d", pub.N.BitLen())
+		}
+		key.Method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	key.ID = key.thumbprint()
+	return key, nil
+}
+
+// GenerateEphemeralKeySet creates a key set with a fresh Ed25519 key. Tokens
+// signed with it stop working on restart, so it is meant for development.
+func GenerateEphemeralKeySet() (*KeySet, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newSigningKey(private, private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySet{signing: key, keys: []*SigningKey{key}, byID: map[string]*SigningKey{key.ID: key}}, nil
+}
+
+// LoadKeySetFromEnv reads PEM keys from JWT_SIGNING_KEYS or the file named
+// by JWT_SIGNING_KEYS_FILE. With JWT_EPHEMERAL_KEY=true and neither set, a
+// throwaway key is generated instead. The old shared JWT_SECRET is no
+// longer accepted.
+func LoadKeySetFromEnv() (*KeySet, error) {
+	data := []byte(os.Getenv("JWT_SIGNING_KEYS"))
+
+	if path := os.Getenv("JWT_SIGNING_KEYS_FILE"); len(data) == 0 && path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("read JWT_SIGNING_KEYS_FILE: %w", err)
+		}
+	}
+
+	if len(data) == 0 {
+		if os.Getenv("JWT_EPHEMERAL_KEY") == "true" {
+			return GenerateEphemeralKeySet()
+		}
+		if os.Getenv("JWT_SECRET") != "" {
+			return nil, errors.New("JWT_SECRET is no longer supported; set JWT_SIGNING_KEYS or JWT_SIGNING_KEYS_FILE")
+		}
+		return nil, errors.New("no JWT signing keys: set JWT_SIGNING_KEYS or JWT_SIGNING_KEYS_FILE (or JWT_EPHEMERAL_KEY=true for development)")
+	}
+
+	return ParseKeySet(data)
+}
+
+// SigningKeyID returns the kid new tokens are signed with
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signing.ID
+}
+
+// Sign creates a token signed with the current signing key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Keyfunc finds the key for a token by its kid, refusing tokens whose
+// algorithm does not match the key (e.g. HS256 signed with a public key)
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for key %q", token.Method.Alg(), kid)
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS returns the public half of every key in the set
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwks.Keys = append(jwks.Keys, key.jwk())
+	}
+	return jwks
+}
+
+// jwk renders the key in JSON Web Key format
+func (k *SigningKey) jwk() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+	switch pub := k.PublicKey.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of the public key
+func (k *SigningKey) thumbprint() string {
+	jwk := k.jwk()
+
+	// Required members only, in lexicographic order, without whitespace
+	var canonical string
+	switch jwk.KeyType {
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Curve, jwk.KeyType, jwk.X)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.KeyType, jwk.N)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
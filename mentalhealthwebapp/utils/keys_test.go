@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newEd25519PEM returns a PKCS#8 private key and its PKIX public key as PEM
+func newEd25519PEM(t *testing.T) (privatePEM, publicPEM []byte) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+}
+
+// TestKeyThumbprintRFC8037 verifies kids against the RFC 8037 example key
+func TestKeyThumbprintRFC8037(t *testing.T) {
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := newSigningKey(nil, ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Errorf("Unexpected thumbprint %s", key.ID)
+	}
+}
+
+// TestKeySetRotation verifies tokens from a retired key are accepted during
+// the overlap and rejected once it is removed
+func TestKeySetRotation(t *testing.T) {
+	oldPrivate, oldPublic := newEd25519PEM(t)
+	newPrivate, _ := newEd25519PEM(t)
+
+	oldSet, err := ParseKeySet(oldPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := oldSet.Sign(jwt.MapClaims{"sub": 1, "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := ParseKeySet(append(append([]byte{}, newPrivate...), oldPublic...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.SigningKeyID() == oldSet.SigningKeyID() {
+		t.Error("Expected the first key to become the signing key")
+	}
+	if len(rotated.JWKS().Keys) != 2 {
+		t.Errorf("Expected both keys in the JWKS, got %d", len(rotated.JWKS().Keys))
+	}
+	if _, err := jwt.Parse(oldToken, rotated.Keyfunc); err != nil {
+		t.Errorf("Expected token from the previous key to verify: %v", err)
+	}
+
+	newOnly, _ := ParseKeySet(newPrivate)
+	if _, err := jwt.Parse(oldToken, newOnly.Keyfunc); err == nil {
+		t.Error("Expected token from a removed key to be rejected")
+	}
+}
+
+// TestKeySetRejectsAlgorithmMismatch verifies an HS256 token using the public
+// key as its secret is not accepted
+func TestKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	private, publicPEM := newEd25519PEM(t)
+	ks, err := ParseKeySet(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": 1})
+	forged.Header["kid"] = ks.SigningKeyID()
+	signed, err := forged.SignedString(publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(signed, ks.Keyfunc); err == nil {
+		t.Error("Expected HS256 token to be rejected")
+	}
+}
+
+// TestParseKeySetErrors verifies unusable key material is refused
+func TestParseKeySetErrors(t *testing.T) {
+	_, public := newEd25519PEM(t)
+
+	if _, err := ParseKeySet([]byte("not a key")); err == nil {
+		t.Error("Expected error for input without PEM blocks")
+	}
+	if _, err := ParseKeySet(public); err == nil {
+		t.Error("Expected error for a key set without a private key")
+	}
+}
+
+// TestTokenClaims verifies issued tokens carry iss/aud and a type
+func TestTokenClaims(t *testing.T) {
+	ks, err := GenerateEphemeralKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeySet(ks)
+	defer SetKeySet(nil)
+
+	pair, err := GenerateTokenPair(42, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseToken(pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != TokenIssuer() || claims["aud"] != TokenAudience() || claims["typ"] != TokenTypeAccess {
+		t.Errorf("Unexpected claims %v", claims)
+	}
+
+	if _, err := ParseMFAChallengeToken(pair.AccessToken); err == nil {
+		t.Error("Expected access token to be rejected as an MFA challenge")
+	}
+
+	mfaToken, err := GenerateMFAChallengeToken(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := ParseMFAChallengeToken(mfaToken); err != nil || userID != 42 {
+		t.Errorf("Expected MFA challenge for user 42, got %d (%v)", userID, err)
+	}
+
+	t.Setenv("JWT_AUDIENCE", "another-api")
+	if _, err := ParseToken(pair.AccessToken); err == nil {
+		t.Error("Expected token for another audience to be rejected")
+	}
+}
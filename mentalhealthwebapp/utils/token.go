@@ -5,10 +5,12 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 // TokenPair represents access and refresh tokens
@@ -16,52 +18,140 @@ type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"` // Access token expiry in seconds
+
+	// AccessTokenID is the access token's "jti" claim, used to blacklist it
+	AccessTokenID string `json:"-"`
+}
+
+// Token types, carried in the "typ" claim so one kind of token cannot be
+// used in place of another
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeRefresh      = "refresh"
+	TokenTypeMFAChallenge = "mfa"
+)
+
+// MFAChallengeTTL is how long a user has to enter their second factor
+const MFAChallengeTTL = 5 * time.Minute
+
+// keySet signs and verifies every token issued by the app. It is set once
+// at startup with SetKeySet.
+var keySet *KeySet
+
+// SetKeySet installs the key set used to sign and verify tokens
+func SetKeySet(ks *KeySet) {
+	keySet = ks
+}
+
+// Keys returns the installed key set
+func Keys() *KeySet {
+	return keySet
+}
+
+// TokenIssuer returns the "iss" claim, configured with JWT_ISSUER
+func TokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "mental-health-app"
+}
+
+// TokenAudience returns the "aud" claim, configured with JWT_AUDIENCE
+func TokenAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return "mental-health-api"
 }
 
-// getJWTSecret returns the JWT secret from environment or default
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "supersecretkey" // Fallback for development
+// VerifyIssuerAudience checks that a token was issued by and for this app
+func VerifyIssuerAudience(claims jwt.MapClaims) error {
+	if !claims.VerifyIssuer(TokenIssuer(), true) {
+		return jwt.NewValidationError("invalid issuer", jwt.ValidationErrorIssuer)
+	}
+	if !claims.VerifyAudience(TokenAudience(), true) {
+		return jwt.NewValidationError("invalid audience", jwt.ValidationErrorAudience)
 	}
-	return []byte(secret)
+	return nil
 }
 
-// GetJWTSecret exports the JWT secret for external use
-func GetJWTSecret() []byte {
-	return getJWTSecret()
+// ParseToken verifies a token's signature, expiry, issuer and audience
+func ParseToken(tokenString string) (jwt.MapClaims, error) {
+	if keySet == nil {
+		return nil, errors.New("signing keys are not configured")
+	}
+
+	token, err := jwt.Parse(tokenString, keySet.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, jwt.NewValidationError("invalid claims", jwt.ValidationErrorClaimsInvalid)
+	}
+	if err := VerifyIssuerAudience(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
 }
 
 // GenerateJWT creates a new access JWT token for a given user ID (short-lived)
 func GenerateJWT(userID int) (string, error) {
-	return generateJWTWithExpiry(userID, 30*time.Minute) // 30 minutes
+	token, _, err := generateJWTWithExpiry(userID, "", TokenTypeAccess, 30*time.Minute) // 30 minutes
+	return token, err
 }
 
 // GenerateRefreshToken creates a new refresh JWT token for a given user ID (long-lived)
 func GenerateRefreshToken(userID int) (string, error) {
-	return generateJWTWithExpiry(userID, 7*24*time.Hour) // 7 days
+	token, _, err := generateJWTWithExpiry(userID, "", TokenTypeRefresh, 7*24*time.Hour) // 7 days
+	return token, err
 }
 
-// generateJWTWithExpiry creates a JWT token with custom expiry
-func generateJWTWithExpiry(userID int, expiry time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// generateJWTWithExpiry creates a JWT token of the given type with custom
+// expiry and returns it with its unique ID. A non-empty sessionID is added
+// as the "sid" claim.
+func generateJWTWithExpiry(userID int, sessionID, tokenType string, expiry time.Duration) (string, string, error) {
+	if keySet == nil {
+		return "", "", errors.New("signing keys are not configured")
+	}
+
+	tokenID := uuid.NewString()
 
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["sub"] = userID
+	claims["iss"] = TokenIssuer()
+	claims["aud"] = TokenAudience()
 	claims["exp"] = time.Now().Add(expiry).Unix()
 	claims["iat"] = time.Now().Unix() // Issued at
+	claims["typ"] = tokenType
+	claims["jti"] = tokenID
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
 
-	return token.SignedString(getJWTSecret())
+	token, err := keySet.Sign(claims)
+	return token, tokenID, err
 }
 
-// GenerateTokenPair creates both access and refresh tokens
-func GenerateTokenPair(userID int) (*TokenPair, error) {
-	accessToken, err := GenerateJWT(userID)
+// TokenID returns the ID a token is blacklisted by: its "jti" claim, or for
+// tokens issued before jti was added, the hash of the whole token
+func TokenID(token string, claims jwt.MapClaims) string {
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		return jti
+	}
+	return HashToken(token)
+}
+
+// GenerateTokenPair creates both access and refresh tokens for a session
+func GenerateTokenPair(userID int, sessionID string) (*TokenPair, error) {
+	accessToken, accessTokenID, err := generateJWTWithExpiry(userID, sessionID, TokenTypeAccess, 30*time.Minute)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := GenerateRefreshToken(userID)
+	refreshToken, _, err := generateJWTWithExpiry(userID, sessionID, TokenTypeRefresh, 7*24*time.Hour)
 	if err != nil {
 		return nil, err
 	}
@@ -70,9 +160,38 @@ func GenerateTokenPair(userID int) (*TokenPair, error) {
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		ExpiresIn:    30 * 60, // 30 minutes in seconds
+
+		AccessTokenID: accessTokenID,
 	}, nil
 }
 
+// GenerateMFAChallengeToken creates the short-lived token a user exchanges,
+// together with a second factor, for a token pair
+func GenerateMFAChallengeToken(userID int) (string, error) {
+	token, _, err := generateJWTWithExpiry(userID, "", TokenTypeMFAChallenge, MFAChallengeTTL)
+	return token, err
+}
+
+// ParseMFAChallengeToken validates an MFA challenge token and returns the
+// user ID it was issued for
+func ParseMFAChallengeToken(tokenString string) (int, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	if claims["typ"] != TokenTypeMFAChallenge {
+		return 0, jwt.NewValidationError("not an MFA challenge token", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, jwt.NewValidationError("missing user ID", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	return int(userID), nil
+}
+
 // GenerateSecureToken creates a cryptographically secure random token
 func GenerateSecureToken() (string, error) {
 	bytes := make([]byte, 32)